@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// backfill normalises every record in the database, merging records which
+// share an identity and suggesting matches between those which are similar.
+func backfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "reports the records which would be merged without changing them")
+	fs.Parse(args)
+
+	pg := postgres.GetPgInstance().Connect(envFilepath)
+	defer pg.Close()
+
+	report, err := pg.MergeDuplicateRecords(*dryRun)
+	if err != nil {
+		slog.Error("merging duplicate records failed", "err", err)
+		pg.Close()
+		os.Exit(1)
+	}
+	for _, m := range report.Merges {
+		slog.Info("records merged", "canonical", m.Canonical, "duplicates", m.Duplicates,
+			"artist", m.Artist, "album", m.Album, "dry_run", report.DryRun)
+	}
+	slog.Info("backfill complete", "records", report.Records, "merges", len(report.Merges),
+		"prices_moved", report.PricesMoved, "prices_dropped", report.PricesDropped, "dry_run", report.DryRun)
+	if *dryRun {
+		return
+	}
+
+	// records which are not identical after normalisation may still be the
+	// same release, suggest them for the user to confirm.
+	n, err := pg.SuggestMatches(records.MatchThreshold)
+	if err != nil {
+		slog.Error("suggesting record matches failed", "err", err)
+		pg.Close()
+		os.Exit(1)
+	}
+	slog.Info("record matches suggested", "matches", n)
+}
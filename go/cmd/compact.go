@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/1602077/webscraper/go/pkg/postgres"
+)
+
+// compact compacts the price history under the retention policy set by
+// -retention-raw-days and -retention-daily-days, reporting what was removed.
+func compact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "reports the snapshots which would be removed without removing them")
+	fs.Parse(args)
+
+	pg := postgres.GetPgInstance().Connect(envFilepath)
+	defer pg.Close()
+
+	report, err := pg.CompactPrices(retention, *dryRun)
+	if err != nil {
+		slog.Error("compacting prices failed", "err", err)
+		pg.Close()
+		os.Exit(1)
+	}
+	slog.Info("prices compacted", "raw_before", report.RawBefore, "weekly_before", report.WeeklyBefore,
+		"daily", report.Daily, "weekly", report.Weekly, "records", report.Records,
+		"extremes_kept", report.ExtremesKept, "seller_offers", report.SellerOffers, "dry_run", report.DryRun)
+}
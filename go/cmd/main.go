@@ -1,13 +1,213 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
 	"github.com/1602077/webscraper/go/pkg/server"
+	"github.com/1602077/webscraper/go/pkg/webscraper"
 )
 
+var (
+	envFilepath     string
+	inputFilepath   string
+	shippingFile    string
+	scraperFile     string
+	replay          bool
+	selectorsFile   string
+	selectorsReload time.Duration
+	addr            string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	logLevel        string
+	snapshotEvery   time.Duration
+	retention       = postgres.DefaultRetentionPolicy
+	compactEvery    time.Duration
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [serve | backfill [-dry-run] | reparse -from DATE [-to DATE] [-dry-run] | prices add|edit|delete|audit [flags] | compact [-dry-run]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&envFilepath, "env", "../../.env", "sets environment config (.env) filepath")
+	flag.StringVar(&inputFilepath, "input", "../../input.txt", "sets filepath of urls to scrape")
+	flag.StringVar(&shippingFile, "shipping", "../../shipping.json", "sets filepath of per-retailer shipping rules")
+	flag.StringVar(&scraperFile, "scraper", "../../scraper.json", "sets filepath of the scraper proxy, identity and cookie config")
+	flag.StringVar(&selectorsFile, "selectors", "../../selectors.json", "sets filepath of the per-retailer page selectors")
+	flag.DurationVar(&selectorsReload, "selectors-reload", 30*time.Second, "sets how often the selectors file is checked for changes, 0 disables reloading")
+	flag.BoolVar(&replay, "replay", false, "serves every scrape from the response cache, without network")
+	flag.StringVar(&addr, "addr", ":8080", "sets address for the http server to listen on")
+	flag.DurationVar(&readTimeout, "read-timeout", 10*time.Second, "sets max duration for reading a request")
+	flag.DurationVar(&writeTimeout, "write-timeout", 2*time.Minute, "sets max duration for writing a response")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 2*time.Minute, "sets max time to wait for the next request on keep-alive connections")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "sets max time to drain in-flight requests and scrapes on shutdown")
+	flag.StringVar(&logLevel, "log-level", "info", "sets minimum log level (debug, info, warn, error)")
+	flag.DurationVar(&snapshotEvery, "snapshot-interval", postgres.DefaultSnapshotInterval, "sets the granularity prices are recorded at, 24h keeps one price a day")
+	flag.IntVar(&retention.RawDays, "retention-raw-days", retention.RawDays, "sets how many days of price snapshots are kept at full resolution")
+	flag.IntVar(&retention.DailyDays, "retention-daily-days", retention.DailyDays, "sets how many days older snapshots are kept as daily rather than weekly summaries")
+	flag.DurationVar(&compactEvery, "compact-every", 24*time.Hour, "sets how often the server compacts the price history, 0 disables compaction")
+}
+
 func main() {
-	router := server.NewRouter()
-	log.Fatal(http.ListenAndServe(":8080", router))
+	flag.Parse()
+
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		slog.Error("parsing flags failed", "err", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logging.New(os.Stdout, level))
+	if err := postgres.GetPgInstance().SetSnapshotInterval(snapshotEvery); err != nil {
+		slog.Error("parsing flags failed", "err", err)
+		os.Exit(2)
+	}
+	if err := retention.Validate(); err != nil {
+		slog.Error("parsing flags failed", "err", err)
+		os.Exit(2)
+	}
+	slog.Info("runtime config loaded", "env", envFilepath, "input", inputFilepath)
+
+	switch cmd := flag.Arg(0); cmd {
+	case "", "serve":
+		serve()
+	case "backfill":
+		backfill(flag.Args()[1:])
+	case "reparse":
+		reparse(flag.Args()[1:])
+	case "prices":
+		prices(flag.Args()[1:])
+	case "compact":
+		compact(flag.Args()[1:])
+	default:
+		slog.Error("unknown command", "command", cmd)
+		os.Exit(2)
+	}
+}
+
+// serve runs the http server until an interrupt or termination signal is
+// received.
+func serve() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// scrapes run under their own context so that they may finish writing to
+	// the database after we stop accepting requests.
+	scrapeCtx, cancelScrapes := context.WithCancel(context.Background())
+	defer cancelScrapes()
+
+	pg := postgres.GetPgInstance().Connect(envFilepath)
+	if n, err := pg.BackfillSortKeys(); err != nil {
+		slog.Error("backfilling record sort keys failed", "err", err)
+	} else if n > 0 {
+		slog.Info("backfilled record sort keys", "records", n)
+	}
+	if n, err := pg.FailStaleRuns(postgres.StaleRunAge); err != nil {
+		slog.Error("failing interrupted scrape runs failed", "err", err)
+	} else if n > 0 {
+		slog.Warn("failed interrupted scrape runs", "runs", n)
+	}
+	configureScraper()
+	if selectorsReload > 0 {
+		go webscraper.WatchSelectors(ctx, selectorsFile, selectorsReload)
+	}
+	if compactEvery > 0 {
+		go pg.ScheduleCompaction(ctx, retention, compactEvery)
+	}
+
+	s := server.NewServer(scrapeCtx, pg, inputFilepath)
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      server.NewRouter(s),
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("http server listening", "addr", addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, http.ErrServerClosed) {
+			pg.Close()
+			slog.Error("http server failed", "err", err)
+			os.Exit(1)
+		}
+	case <-ctx.Done():
+		slog.Info("shutdown signal received, draining in-flight requests")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("http server shutdown failed", "err", err)
+	}
+	if err := s.Wait(shutdownCtx); err != nil {
+		slog.Warn("scrape jobs did not finish before deadline, cancelling", "err", err)
+	}
+
+	// abort any scrapes still outstanding and wait for them to return before
+	// closing the connection pool they write to.
+	cancelScrapes()
+	s.Wait(context.Background())
+	if err := webscraper.SaveCookies(); err != nil {
+		slog.Error("saving scraper cookies failed", "err", err)
+	}
+	pg.Close()
+	slog.Info("shutdown complete")
+}
+
+// configureScraper loads the shipping rules, page selectors and scraper
+// config, exiting if any is invalid.
+func configureScraper() {
+	rules, err := records.LoadShippingRules(shippingFile)
+	if err != nil {
+		slog.Error("loading shipping rules failed", "err", err)
+		os.Exit(1)
+	}
+	webscraper.SetShippingRules(rules)
+	slog.Info("shipping rules loaded", "retailers", len(rules))
+	sel, err := webscraper.LoadSelectors(selectorsFile)
+	if err != nil {
+		slog.Error("loading selectors failed", "err", err)
+		os.Exit(1)
+	}
+	webscraper.SetSelectors(sel)
+	slog.Info("selectors loaded", "path", selectorsFile, "retailers", len(sel.Retailers))
+	cfg, err := webscraper.LoadConfig(scraperFile)
+	if err == nil && replay {
+		if cfg.Cache.Dir == "" {
+			err = errors.New("-replay requires a cache dir")
+		}
+		cfg.Cache.ReplayOnly = true
+	}
+	if err == nil {
+		err = webscraper.Configure(cfg)
+	}
+	if err != nil {
+		slog.Error("loading scraper config failed", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("scraper config loaded", "proxies", len(cfg.Proxies.Urls), "identities", len(cfg.Identities),
+		"cache", cfg.Cache.Dir, "replay", cfg.Cache.ReplayOnly)
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// prices enters, edits or deletes a price by hand, or lists the audit trail
+// of a record's prices, as its first argument is add, edit, delete or audit.
+func prices(args []string) {
+	if len(args) == 0 || (args[0] != "add" && args[0] != "edit" && args[0] != "delete" && args[0] != "audit") {
+		slog.Error("prices requires a command: add, edit, delete or audit")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("prices "+args[0], flag.ExitOnError)
+	recordID := fs.Int("record", 0, "id of the record to price or audit (add, audit)")
+	date := fs.String("date", "", "date of the price (YYYY-MM-DD) or time (RFC3339), defaults to now (add)")
+	retailer := fs.String("retailer", records.DefaultRetailer, "retailer of the price (add)")
+	source := fs.String("source", postgres.SourceManual, "where the price came from, manual or imported (add)")
+	available := fs.Bool("available", true, "whether the record was in stock (add)")
+	price := fs.Float64("price", 0, "price of the record (add, edit)")
+	shipping := fs.Float64("shipping", 0, "shipping of the record (add, edit)")
+	priceID := fs.Int("id", 0, "id of the price to change (edit, delete)")
+	user := fs.String("user", os.Getenv("USER"), "who is changing the price, recorded in the audit trail")
+	note := fs.String("note", "", "why the price is being changed, recorded in the audit trail")
+	fs.Parse(args[1:])
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if args[0] != "audit" && *user == "" {
+		slog.Error("-user must name who is changing the price")
+		os.Exit(2)
+	}
+
+	pg := postgres.GetPgInstance().Connect(envFilepath)
+	defer pg.Close()
+
+	switch args[0] {
+	case "add":
+		e := postgres.PriceEntry{
+			RecordID:   *recordID,
+			CapturedAt: time.Now(),
+			Retailer:   *retailer,
+			Price:      float32(*price),
+			Shipping:   float32(*shipping),
+			Available:  *available,
+			Source:     *source,
+		}
+		var err error
+		if *date != "" {
+			if e.CapturedAt, err = time.ParseInLocation(time.DateOnly, *date, time.Local); err != nil {
+				e.CapturedAt, err = time.Parse(time.RFC3339, *date)
+			}
+		}
+		if err != nil || !set["record"] || e.Price <= 0 {
+			slog.Error("add requires -record and a positive -price, and -date must be a date (YYYY-MM-DD) or time (RFC3339)")
+			pg.Close()
+			os.Exit(2)
+		}
+		p, err := pg.InsertPrice(e, *user, *note)
+		if err != nil {
+			slog.Error("entering price failed", "record_id", *recordID, "err", err)
+			pg.Close()
+			os.Exit(1)
+		}
+		slog.Info("price entered", "price_id", p.Id, "record_id", p.RecordID, "captured_at", p.CapturedAt,
+			"retailer", p.Retailer, "price", p.Price, "source", p.Source)
+	case "edit":
+		var newPrice, newShipping *float32
+		if set["price"] {
+			v := float32(*price)
+			newPrice = &v
+		}
+		if set["shipping"] {
+			v := float32(*shipping)
+			newShipping = &v
+		}
+		if !set["id"] || (newPrice == nil && newShipping == nil) {
+			slog.Error("edit requires -id and -price or -shipping")
+			pg.Close()
+			os.Exit(2)
+		}
+		p, err := pg.UpdatePrice(*priceID, newPrice, newShipping, *user, *note)
+		if err != nil {
+			slog.Error("editing price failed", "price_id", *priceID, "err", err)
+			pg.Close()
+			os.Exit(1)
+		}
+		slog.Info("price edited", "price_id", p.Id, "price", p.Price, "shipping", p.Shipping)
+	case "delete":
+		if !set["id"] {
+			slog.Error("delete requires -id")
+			pg.Close()
+			os.Exit(2)
+		}
+		if err := pg.DeletePrice(*priceID, *user, *note); err != nil {
+			slog.Error("deleting price failed", "price_id", *priceID, "err", err)
+			pg.Close()
+			os.Exit(1)
+		}
+		slog.Info("price deleted", "price_id", *priceID)
+	case "audit":
+		changes, err := pg.GetPriceChanges(*recordID)
+		if err != nil {
+			slog.Error("reading audit trail failed", "record_id", *recordID, "err", err)
+			pg.Close()
+			os.Exit(1)
+		}
+		for _, c := range changes {
+			attrs := []any{"price_id", c.PriceID, "captured_at", c.CapturedAt, "retailer", c.Retailer,
+				"action", c.Action, "by", c.ChangedBy, "at", c.ChangedAt}
+			if c.OldPrice != nil {
+				attrs = append(attrs, "old_price", *c.OldPrice, "old_source", *c.OldSource)
+			}
+			if c.NewPrice != nil {
+				attrs = append(attrs, "new_price", *c.NewPrice, "new_source", *c.NewSource)
+			}
+			if c.Note != "" {
+				attrs = append(attrs, "note", c.Note)
+			}
+			slog.Info("price changed", attrs...)
+		}
+		slog.Info("audit trail read", "record_id", *recordID, "changes", len(changes))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/1602077/webscraper/go/pkg/webscraper"
+)
+
+// reparse runs the current extractors over the pages archived between -from
+// and -to, backfilling prices missing from those dates and correcting those
+// which were scraped wrongly.
+func reparse(args []string) {
+	fs := flag.NewFlagSet("reparse", flag.ExitOnError)
+	from := fs.String("from", "", "first date of archived pages to re-parse (YYYY-MM-DD)")
+	to := fs.String("to", "", "last date of archived pages to re-parse (YYYY-MM-DD), defaults to -from")
+	dryRun := fs.Bool("dry-run", false, "reports the prices which would be written without writing them")
+	fs.Parse(args)
+
+	start, err := time.ParseInLocation(time.DateOnly, *from, time.Local)
+	end := start
+	if err == nil && *to != "" {
+		end, err = time.ParseInLocation(time.DateOnly, *to, time.Local)
+	}
+	if err != nil || end.Before(start) {
+		slog.Error("-from and -to must be dates (YYYY-MM-DD), -to no earlier than -from", "from", *from, "to", *to)
+		os.Exit(2)
+	}
+
+	configureScraper()
+	archive := webscraper.ConfiguredArchive()
+	if archive == nil {
+		slog.Error("reparse requires an archive dir in the scraper config", "scraper", scraperFile)
+		os.Exit(1)
+	}
+
+	pg := postgres.GetPgInstance().Connect(envFilepath)
+	defer pg.Close()
+
+	ctx := context.Background()
+	var written int
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		rs, err := archive.Reparse(ctx, day)
+		if err != nil {
+			slog.Error("re-parsing archived pages failed", "date", day.Format(time.DateOnly), "err", err)
+			pg.Close()
+			os.Exit(1)
+		}
+		corrections, err := pg.CorrectPrices(rs, day, records.DefaultAnomalyRules, *dryRun)
+		if err != nil {
+			slog.Error("correcting prices failed", "date", day.Format(time.DateOnly), "err", err)
+			pg.Close()
+			os.Exit(1)
+		}
+		for _, c := range corrections {
+			attrs := []any{"date", day.Format(time.DateOnly), "record_id", c.RecordID, "artist", c.Artist,
+				"album", c.Album, "retailer", c.Retailer, "price", c.New, "dry_run", *dryRun}
+			if c.Old != nil {
+				slog.Info("price corrected", append(attrs, "was", *c.Old)...)
+			} else {
+				slog.Info("price backfilled", attrs...)
+			}
+		}
+		slog.Info("archived pages re-parsed", "date", day.Format(time.DateOnly), "records", len(rs),
+			"prices", len(corrections), "dry_run", *dryRun)
+		written += len(corrections)
+	}
+	slog.Info("reparse complete", "from", start.Format(time.DateOnly), "to", end.Format(time.DateOnly), "prices", written, "dry_run", *dryRun)
+}
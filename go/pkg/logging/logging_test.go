@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		level   slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLevel(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel(%s) err = %v, wantErr %t", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.level {
+				t.Errorf("ParseLevel(%s) = %v, expected %v", tt.in, got, tt.level)
+			}
+		})
+	}
+}
+
+func TestContextLogger(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Errorf("expected default logger from empty context")
+	}
+
+	var b bytes.Buffer
+	ctx := NewContext(context.Background(), New(&b, slog.LevelInfo))
+	ctx = With(ctx, "request_id", "abc")
+	FromContext(ctx).Info("hello", "url", "https://example.com")
+
+	var line map[string]any
+	if err := json.Unmarshal(b.Bytes(), &line); err != nil {
+		t.Fatalf("log line is not json: %s", err)
+	}
+	for k, v := range map[string]any{"msg": "hello", "request_id": "abc", "url": "https://example.com"} {
+		if line[k] != v {
+			t.Errorf("log line %s = %v, expected %v", k, line[k], v)
+		}
+	}
+}
+
+func TestNewID(t *testing.T) {
+	a, b := NewID(), NewID()
+	if len(a) != 16 || a == b {
+		t.Errorf("expected two distinct 16 character ids, got %s and %s", a, b)
+	}
+}
@@ -0,0 +1,222 @@
+// api methods to read & write to postgres database
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/lib/pq"
+)
+
+// featuredArtists returns the featured artists of n as a non nil slice, as
+// the featured_artists column may not be null.
+func featuredArtists(n records.Normalised) []string {
+	if n.Featured == nil {
+		return []string{}
+	}
+	return n.Featured
+}
+
+// Merge is a set of duplicate records combined into their canonical record.
+type Merge struct {
+	Canonical  int    `json:"canonical"`
+	Duplicates []int  `json:"duplicates"`
+	Artist     string `json:"artist"`
+	Album      string `json:"album"`
+}
+
+// MergeReport summarises the changes made by MergeDuplicateRecords.
+type MergeReport struct {
+	Records       int     `json:"records"`        // records examined
+	Merges        []Merge `json:"merges"`         // duplicate sets merged
+	PricesMoved   int     `json:"prices_moved"`   // prices reassigned to a canonical record
+	PricesDropped int     `json:"prices_dropped"` // duplicate prices in a snapshot and retailer the canonical record already has
+	DryRun        bool    `json:"dry_run"`
+}
+
+// identityRecord is a row of the records table being backfilled.
+type identityRecord struct {
+	id            int
+	artist, album string
+	norm          records.Normalised
+}
+
+// MergeDuplicateRecords normalises every record, merging records which share
+// an identity into the one with the lowest id. Prices of a duplicate are
+// moved to the canonical record unless it already has a price from the same
+// retailer in that snapshot, tags are combined and a target price is kept if the
+// canonical record has none. The unique identity index is created once no
+// duplicates remain.
+//
+// All changes are made in a single transaction which is rolled back if
+// dryRun is set, so the report describes what would be merged.
+func (pg *PgInstance) MergeDuplicateRecords(dryRun bool) (*MergeReport, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("MergeDuplicateRecords() begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	// lock out concurrent inserts which could add a duplicate mid merge.
+	if _, err := tx.Exec(`LOCK TABLE records IN SHARE ROW EXCLUSIVE MODE;`); err != nil {
+		return nil, fmt.Errorf("MergeDuplicateRecords() lock failed: %w", err)
+	}
+
+	recs, err := identityRecords(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MergeReport{Records: len(recs), DryRun: dryRun}
+	type identity struct{ artist, album string }
+	groups := make(map[identity][]identityRecord)
+	var order []identity
+	for _, r := range recs {
+		id := identity{r.norm.ArtistKey, r.norm.AlbumKey}
+		if _, ok := groups[id]; !ok {
+			order = append(order, id)
+		}
+		groups[id] = append(groups[id], r)
+	}
+
+	for _, id := range order {
+		group := groups[id]
+		canonical := group[0]
+		if len(group) > 1 {
+			m := Merge{Canonical: canonical.id, Artist: canonical.norm.Artist, Album: canonical.norm.Album}
+			for _, dup := range group[1:] {
+				dropped, moved, err := mergeRecord(tx, canonical.id, dup.id)
+				if err != nil {
+					return nil, err
+				}
+				report.PricesDropped += dropped
+				report.PricesMoved += moved
+				m.Duplicates = append(m.Duplicates, dup.id)
+			}
+			report.Merges = append(report.Merges, m)
+		}
+		if err := updateIdentity(tx, canonical); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS records_identity_idx
+		ON records (artist_key, album_key);`); err != nil {
+		return nil, fmt.Errorf("MergeDuplicateRecords() creating identity index failed: %w", err)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("MergeDuplicateRecords() commit failed: %w", err)
+	}
+	return report, nil
+}
+
+// identityRecords reads every record in id order along with its normalised
+// form.
+func identityRecords(tx *sql.Tx) ([]identityRecord, error) {
+	rows, err := tx.Query(`SELECT id, artist, album FROM records ORDER BY id;`)
+	if err != nil {
+		return nil, fmt.Errorf("MergeDuplicateRecords() query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []identityRecord
+	for rows.Next() {
+		var r identityRecord
+		if err := rows.Scan(&r.id, &r.artist, &r.album); err != nil {
+			return nil, fmt.Errorf("MergeDuplicateRecords() row scan failed: %w", err)
+		}
+		r.norm = records.Normalise(r.artist, r.album)
+		recs = append(recs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("MergeDuplicateRecords() failed: %w", err)
+	}
+	return recs, nil
+}
+
+// mergeRecord folds the duplicate record, its prices, listings, pending
+// prices and price audit into the canonical record and deletes it, returning
+// the number of prices dropped and moved.
+func mergeRecord(tx *sql.Tx, canonical, duplicate int) (int, int, error) {
+	res, err := tx.Exec(`
+		DELETE FROM prices d
+		USING prices c
+		WHERE d.record_id = $2 AND c.record_id = $1
+			AND c.captured_at = d.captured_at AND c.retailer = d.retailer;`, canonical, duplicate)
+	if err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() dropping prices of record %d failed: %w", duplicate, err)
+	}
+	dropped, _ := res.RowsAffected()
+
+	res, err = tx.Exec(`UPDATE prices SET record_id = $1 WHERE record_id = $2;`, canonical, duplicate)
+	if err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() moving prices of record %d failed: %w", duplicate, err)
+	}
+	moved, _ := res.RowsAffected()
+
+	if _, err := tx.Exec(`
+		DELETE FROM seller_offers d
+		USING seller_offers c
+		WHERE d.record_id = $2 AND c.record_id = $1 AND c.captured_at = d.captured_at AND c.retailer = d.retailer
+			AND c.seller = d.seller AND c.condition = d.condition;`, canonical, duplicate); err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() dropping seller offers of record %d failed: %w", duplicate, err)
+	}
+	if _, err := tx.Exec(`UPDATE seller_offers SET record_id = $1 WHERE record_id = $2;`, canonical, duplicate); err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() moving seller offers of record %d failed: %w", duplicate, err)
+	}
+
+	if _, err := tx.Exec(`UPDATE listings SET record_id = $1 WHERE record_id = $2;`, canonical, duplicate); err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() moving listings of record %d failed: %w", duplicate, err)
+	}
+	if _, err := tx.Exec(`UPDATE pending_prices SET record_id = $1 WHERE record_id = $2;`, canonical, duplicate); err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() moving pending prices of record %d failed: %w", duplicate, err)
+	}
+	if _, err := tx.Exec(`UPDATE price_audit SET record_id = $1 WHERE record_id = $2;`, canonical, duplicate); err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() moving price audit of record %d failed: %w", duplicate, err)
+	}
+	// suggestions involving the duplicate are stale once it is merged.
+	if _, err := tx.Exec(`
+		DELETE FROM record_matches
+		WHERE candidate_id = $1 AND status = 'suggested';`, duplicate); err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() dropping matches of record %d failed: %w", duplicate, err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE records c
+		SET tags = ARRAY(SELECT DISTINCT t FROM unnest(c.tags || d.tags) t ORDER BY t),
+			target_price = COALESCE(c.target_price, d.target_price)
+		FROM records d
+		WHERE c.id = $1 AND d.id = $2;`, canonical, duplicate)
+	if err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() merging settings of record %d failed: %w", duplicate, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM records WHERE id = $1;`, duplicate); err != nil {
+		return 0, 0, fmt.Errorf("mergeRecord() deleting record %d failed: %w", duplicate, err)
+	}
+	return int(dropped), int(moved), nil
+}
+
+// updateIdentity writes the normalised album, sort and identity keys of a
+// record. The artist is left as credited, only its key is normalised.
+func updateIdentity(tx *sql.Tx, r identityRecord) error {
+	n := r.norm
+	_, err := tx.Exec(`
+		UPDATE records
+		SET album = $2, artist_sort = $3, album_sort = $4,
+			artist_key = $5, album_key = $6, featured_artists = $7,
+			edition = NULLIF($8, ''), format = NULLIF($9, '')
+		WHERE id = $1;`, r.id, n.Album,
+		records.ArtistSortKey(n.Artist), records.SortKey(n.Album), n.ArtistKey, n.AlbumKey,
+		pq.Array(featuredArtists(n)), n.Edition, n.Format)
+	if err != nil {
+		return fmt.Errorf("MergeDuplicateRecords() updating record %d failed: %w", r.id, err)
+	}
+	return nil
+}
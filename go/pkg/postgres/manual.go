@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sources of a price.
+const (
+	SourceScraped  = "scraped"
+	SourceManual   = "manual"
+	SourceImported = "imported"
+)
+
+// actions recorded in the audit trail of a price.
+const (
+	AuditInsert = "insert"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+var (
+	// ErrRecordNotFound is returned when pricing a record which does not
+	// exist.
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrPriceNotFound is returned when editing a price which does not exist.
+	ErrPriceNotFound = errors.New("price not found")
+	// ErrPriceExists is returned when entering a price for a retailer in a
+	// snapshot which already has a price entered by hand or imported, which
+	// must be edited instead.
+	ErrPriceExists = errors.New("price already entered")
+	// ErrInvalidSource is returned when entering a price as scraped.
+	ErrInvalidSource = errors.New("source must be manual or imported")
+)
+
+// PriceEntry is the price of a record from a retailer in a snapshot, and
+// where it came from. Entries are written to the snapshot containing
+// CapturedAt.
+type PriceEntry struct {
+	Id         int       `json:"id"`
+	RecordID   int       `json:"record_id"`
+	CapturedAt time.Time `json:"captured_at"`
+	Retailer   string    `json:"retailer"`
+	Price      float32   `json:"price"`
+	Shipping   float32   `json:"shipping"`
+	Available  bool      `json:"available"`
+	Source     string    `json:"source"`
+}
+
+// PriceChange is an entry in the audit trail of prices entered, edited or
+// deleted by hand. The old values are nil for an insert of a new price and
+// the new values nil for a delete.
+type PriceChange struct {
+	Id          int       `json:"id"`
+	PriceID     int       `json:"price_id"`
+	RecordID    int       `json:"record_id"`
+	CapturedAt  time.Time `json:"captured_at"`
+	Retailer    string    `json:"retailer"`
+	Action      string    `json:"action"`
+	OldPrice    *float32  `json:"old_price,omitempty"`
+	NewPrice    *float32  `json:"new_price,omitempty"`
+	OldShipping *float32  `json:"old_shipping,omitempty"`
+	NewShipping *float32  `json:"new_shipping,omitempty"`
+	OldSource   *string   `json:"old_source,omitempty"`
+	NewSource   *string   `json:"new_source,omitempty"`
+	ChangedBy   string    `json:"changed_by"`
+	Note        string    `json:"note,omitempty"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// lockPrice reads the price with the given id within tx, locking it until tx
+// ends.
+func lockPrice(tx *sql.Tx, id int) (*PriceEntry, error) {
+	p := &PriceEntry{}
+	err := tx.QueryRow(`
+		SELECT id, record_id, captured_at, retailer, price, shipping, available, source
+		FROM prices
+		WHERE id = $1
+		FOR UPDATE;`, id).Scan(&p.Id, &p.RecordID, &p.CapturedAt, &p.Retailer, &p.Price, &p.Shipping, &p.Available, &p.Source)
+	if err == sql.ErrNoRows {
+		return nil, ErrPriceNotFound
+	}
+	return p, err
+}
+
+// audit records a change to a price, before is nil for an insert and after
+// nil for a delete.
+func audit(tx *sql.Tx, action string, before, after *PriceEntry, by, note string) error {
+	p := after
+	if p == nil {
+		p = before
+	}
+	var oldPrice, newPrice, oldShipping, newShipping *float32
+	var oldSource, newSource *string
+	if before != nil {
+		oldPrice, oldShipping, oldSource = &before.Price, &before.Shipping, &before.Source
+	}
+	if after != nil {
+		newPrice, newShipping, newSource = &after.Price, &after.Shipping, &after.Source
+	}
+	_, err := tx.Exec(`
+		INSERT INTO
+			price_audit (price_id, record_id, date, captured_at, retailer, action, old_price, new_price,
+				old_shipping, new_shipping, old_source, new_source, changed_by, note)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''));`,
+		p.Id, p.RecordID, p.CapturedAt, p.CapturedAt, p.Retailer, action, oldPrice, newPrice,
+		oldShipping, newShipping, oldSource, newSource, by, note)
+	return err
+}
+
+// InsertPrice enters the price of a record from a retailer by hand or from an
+// import, recording who entered it in the audit trail. A snapshot holds one
+// price per retailer: a scraped price is replaced, as the price entered is
+// taken to correct it, but a price already entered by hand or imported must
+// be edited instead and ErrPriceExists is returned. Prices entered are never
+// overwritten by later scrapes.
+func (pg *PgInstance) InsertPrice(e PriceEntry, by, note string) (*PriceEntry, error) {
+	if e.Source != SourceManual && e.Source != SourceImported {
+		return nil, fmt.Errorf("InsertPrice() failed: %w", ErrInvalidSource)
+	}
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("InsertPrice() begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM records WHERE id = $1);`, e.RecordID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("InsertPrice() failed: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("InsertPrice() of record %d failed: %w", e.RecordID, ErrRecordNotFound)
+	}
+
+	e.CapturedAt = pg.snapshot(e.CapturedAt)
+	var old *PriceEntry
+	var id int
+	err = tx.QueryRow(`
+		SELECT id
+		FROM prices
+		WHERE captured_at = $1 AND record_id = $2 AND retailer = $3;`, e.CapturedAt, e.RecordID, e.Retailer).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, fmt.Errorf("InsertPrice() failed: %w", err)
+	default:
+		if old, err = lockPrice(tx, id); err != nil {
+			return nil, fmt.Errorf("InsertPrice() failed: %w", err)
+		}
+		if old.Source != SourceScraped {
+			return nil, fmt.Errorf("InsertPrice() of price %d failed: %w", id, ErrPriceExists)
+		}
+	}
+
+	// a price written since it was read above is replaced if it was scraped
+	// and refused otherwise, rather than conflicting.
+	var inserted bool
+	err = tx.QueryRow(`
+		INSERT INTO
+			prices (date, captured_at, price, shipping, record_id, retailer, available, source)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (captured_at, record_id, retailer) DO UPDATE
+		SET price = EXCLUDED.price, shipping = EXCLUDED.shipping, available = EXCLUDED.available,
+			source = EXCLUDED.source, seller = NULL, fulfilled = FALSE, condition = 'new', currency = NULL
+		WHERE prices.source = 'scraped'
+		RETURNING id, xmax = 0;`,
+		e.CapturedAt, e.CapturedAt, e.Price, e.Shipping, e.RecordID, e.Retailer, e.Available, e.Source).Scan(&e.Id, &inserted)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("InsertPrice() of record %d failed: %w", e.RecordID, ErrPriceExists)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("InsertPrice() writing price failed: %w", err)
+	}
+	action := AuditInsert
+	if !inserted {
+		action = AuditUpdate
+	}
+	if err := audit(tx, action, old, &e, by, note); err != nil {
+		return nil, fmt.Errorf("InsertPrice() writing audit failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("InsertPrice() commit failed: %w", err)
+	}
+	return &e, nil
+}
+
+// UpdatePrice edits the price and shipping of the price with the given id,
+// leaving those which are nil, and records the edit in the audit trail. A
+// scraped price becomes a manual price so is not overwritten by later
+// scrapes, one entered by hand or imported keeps its source.
+func (pg *PgInstance) UpdatePrice(id int, price, shipping *float32, by, note string) (*PriceEntry, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("UpdatePrice() begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := lockPrice(tx, id)
+	if err != nil {
+		return nil, fmt.Errorf("UpdatePrice() of price %d failed: %w", id, err)
+	}
+	p := *old
+	if p.Source == SourceScraped {
+		p.Source = SourceManual
+	}
+	if price != nil {
+		p.Price = *price
+	}
+	if shipping != nil {
+		p.Shipping = *shipping
+	}
+	_, err = tx.Exec(`
+		UPDATE prices
+		SET price = $2, shipping = $3, source = $4
+		WHERE id = $1;`, id, p.Price, p.Shipping, p.Source)
+	if err != nil {
+		return nil, fmt.Errorf("UpdatePrice() of price %d failed: %w", id, err)
+	}
+	if err := audit(tx, AuditUpdate, old, &p, by, note); err != nil {
+		return nil, fmt.Errorf("UpdatePrice() writing audit failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("UpdatePrice() commit failed: %w", err)
+	}
+	return &p, nil
+}
+
+// DeletePrice deletes the price with the given id, recording it in the audit
+// trail.
+func (pg *PgInstance) DeletePrice(id int, by, note string) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return fmt.Errorf("DeletePrice() begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	old, err := lockPrice(tx, id)
+	if err != nil {
+		return fmt.Errorf("DeletePrice() of price %d failed: %w", id, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM prices WHERE id = $1;`, id); err != nil {
+		return fmt.Errorf("DeletePrice() of price %d failed: %w", id, err)
+	}
+	if err := audit(tx, AuditDelete, old, nil, by, note); err != nil {
+		return fmt.Errorf("DeletePrice() writing audit failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DeletePrice() commit failed: %w", err)
+	}
+	return nil
+}
+
+// GetPriceChanges gets the audit trail of the prices of a record, most recent
+// first.
+func (pg *PgInstance) GetPriceChanges(recordID int) ([]*PriceChange, error) {
+	rows, err := pg.db.Query(`
+		SELECT id, price_id, record_id, captured_at, retailer, action, old_price, new_price, old_shipping,
+			new_shipping, old_source, new_source, changed_by, COALESCE(note, ''), changed_at
+		FROM price_audit
+		WHERE record_id = $1
+		ORDER BY changed_at DESC, id DESC;`, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("GetPriceChanges() query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*PriceChange
+	for rows.Next() {
+		c := &PriceChange{}
+		if err := rows.Scan(&c.Id, &c.PriceID, &c.RecordID, &c.CapturedAt, &c.Retailer, &c.Action, &c.OldPrice,
+			&c.NewPrice, &c.OldShipping, &c.NewShipping, &c.OldSource, &c.NewSource, &c.ChangedBy, &c.Note,
+			&c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("GetPriceChanges() row scan failed: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetPriceChanges() failed: %w", err)
+	}
+	return changes, nil
+}
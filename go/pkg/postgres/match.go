@@ -0,0 +1,224 @@
+// api methods to read & write to postgres database
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/lib/pq"
+)
+
+// statuses of a RecordMatch.
+const (
+	MatchSuggested = "suggested"
+	MatchConfirmed = "confirmed"
+	MatchRejected  = "rejected"
+)
+
+var (
+	// ErrMatchNotFound is returned when deciding a match which does not exist.
+	ErrMatchNotFound = errors.New("match not found")
+	// ErrMatchDecided is returned when deciding a match which has already
+	// been confirmed or rejected.
+	ErrMatchDecided = errors.New("match already decided")
+)
+
+// MatchedRecord is one side of a RecordMatch.
+type MatchedRecord struct {
+	Id     int    `json:"id"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+}
+
+// RecordMatch is a suggestion that two records are the same release. The
+// candidate is merged into the record when the match is confirmed, after
+// which only its id is retained.
+type RecordMatch struct {
+	Id          int            `json:"id"`
+	Record      MatchedRecord  `json:"record"`
+	Candidate   *MatchedRecord `json:"candidate,omitempty"`
+	CandidateId int            `json:"candidate_id"`
+	records.MatchScore
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// SuggestMatches scores every pair of records by the similarity of their
+// normalised artist and album and the identifiers of their listings,
+// suggesting pairs scoring at least threshold. Pairs which have already been
+// suggested, confirmed or rejected are not suggested again. The number of new
+// suggestions is returned.
+func (pg *PgInstance) SuggestMatches(threshold float64) (int, error) {
+	rows, err := pg.db.Query(`
+		SELECT r.id, r.artist, r.album,
+			ARRAY_REMOVE(ARRAY_AGG(DISTINCT l.barcode), NULL),
+			ARRAY_REMOVE(ARRAY_AGG(DISTINCT l.catalogue_number), NULL)
+		FROM records r
+		LEFT JOIN listings l ON l.record_id = r.id
+		GROUP BY r.id
+		ORDER BY r.id;`)
+	if err != nil {
+		return 0, fmt.Errorf("SuggestMatches() query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var releases []records.Release
+	for rows.Next() {
+		var r records.Release
+		if err := rows.Scan(&r.Id, &r.Artist, &r.Album, pq.Array(&r.Barcodes), pq.Array(&r.CatalogueNumbers)); err != nil {
+			return 0, fmt.Errorf("SuggestMatches() row scan failed: %w", err)
+		}
+		releases = append(releases, r)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("SuggestMatches() failed: %w", err)
+	}
+
+	n := 0
+	for _, c := range records.FindMatches(releases, threshold) {
+		res, err := pg.db.Exec(`
+			INSERT INTO
+				record_matches (record_id, candidate_id, score, reasons)
+			VALUES
+				($1, $2, $3, $4)
+			ON CONFLICT (record_id, candidate_id) DO NOTHING;`,
+			c.A, c.B, c.Score, pq.Array(c.Reasons))
+		if err != nil {
+			return n, fmt.Errorf("SuggestMatches() insert of %d, %d failed: %w", c.A, c.B, err)
+		}
+		if inserted, _ := res.RowsAffected(); inserted > 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GetMatches gets the matches with the given status, or all matches if
+// status is empty, most likely first.
+func (pg *PgInstance) GetMatches(status string) ([]*RecordMatch, error) {
+	rows, err := pg.db.Query(`
+		SELECT m.id, m.score, m.reasons, m.status, m.created_at, m.decided_at,
+			r.id, r.artist, r.album, m.candidate_id, c.artist, c.album
+		FROM record_matches m
+		INNER JOIN records r ON r.id = m.record_id
+		LEFT JOIN records c ON c.id = m.candidate_id
+		WHERE $1 = '' OR m.status = $1
+		ORDER BY m.score DESC, m.id;`, status)
+	if err != nil {
+		return nil, fmt.Errorf("GetMatches() query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []*RecordMatch
+	for rows.Next() {
+		m := &RecordMatch{}
+		var artist, album sql.NullString
+		if err := rows.Scan(&m.Id, &m.Score, pq.Array(&m.Reasons), &m.Status, &m.CreatedAt, &m.DecidedAt,
+			&m.Record.Id, &m.Record.Artist, &m.Record.Album, &m.CandidateId, &artist, &album); err != nil {
+			return nil, fmt.Errorf("GetMatches() row scan failed: %w", err)
+		}
+		if artist.Valid {
+			m.Candidate = &MatchedRecord{Id: m.CandidateId, Artist: artist.String, Album: album.String}
+		}
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetMatches() failed: %w", err)
+	}
+	return matches, nil
+}
+
+// decideMatch locks the suggested match with the given id within tx,
+// returning its record and candidate ids.
+func decideMatch(tx *sql.Tx, id int) (int, int, error) {
+	var recordID, candidateID int
+	var status string
+	err := tx.QueryRow(`
+		SELECT record_id, candidate_id, status
+		FROM record_matches
+		WHERE id = $1
+		FOR UPDATE;`, id).Scan(&recordID, &candidateID, &status)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrMatchNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if status != MatchSuggested {
+		return 0, 0, ErrMatchDecided
+	}
+	return recordID, candidateID, nil
+}
+
+// setMatchStatus records the decision made on a match, ErrMatchNotFound if
+// there is no match with the given id.
+func setMatchStatus(tx *sql.Tx, id int, status string) error {
+	res, err := tx.Exec(`
+		UPDATE record_matches
+		SET status = $2, decided_at = now()
+		WHERE id = $1;`, id, status)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMatchNotFound
+	}
+	return nil
+}
+
+// ConfirmMatch confirms the match with the given id, merging its candidate
+// record, along with its prices and listings, into its record.
+func (pg *PgInstance) ConfirmMatch(id int) (*Merge, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmMatch() begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	recordID, candidateID, err := decideMatch(tx, id)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmMatch() of match %d failed: %w", id, err)
+	}
+	// the match is confirmed before merging, as merging drops the suggested
+	// matches of the candidate.
+	if err := setMatchStatus(tx, id, MatchConfirmed); err != nil {
+		return nil, fmt.Errorf("ConfirmMatch() of match %d failed: %w", id, err)
+	}
+	if _, _, err := mergeRecord(tx, recordID, candidateID); err != nil {
+		return nil, fmt.Errorf("ConfirmMatch() of match %d failed: %w", id, err)
+	}
+
+	m := &Merge{Canonical: recordID, Duplicates: []int{candidateID}}
+	if err := tx.QueryRow(`SELECT artist, album FROM records WHERE id = $1;`, recordID).Scan(&m.Artist, &m.Album); err != nil {
+		return nil, fmt.Errorf("ConfirmMatch() of match %d failed: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ConfirmMatch() commit failed: %w", err)
+	}
+	return m, nil
+}
+
+// RejectMatch rejects the match with the given id so the pair of records is
+// not suggested again.
+func (pg *PgInstance) RejectMatch(id int) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return fmt.Errorf("RejectMatch() begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, _, err := decideMatch(tx, id); err != nil {
+		return fmt.Errorf("RejectMatch() of match %d failed: %w", id, err)
+	}
+	if err := setMatchStatus(tx, id, MatchRejected); err != nil {
+		return fmt.Errorf("RejectMatch() of match %d failed: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("RejectMatch() commit failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/lib/pq"
+)
+
+// statuses of a PendingPrice.
+const (
+	PendingHeld      = "pending"
+	PendingApproved  = "approved"
+	PendingCorrected = "corrected"
+	PendingDiscarded = "discarded"
+)
+
+// priceHistoryLength is how many previous prices a scraped price is judged
+// against.
+const priceHistoryLength = 30
+
+// MaxPrice is the largest price the pricing table holds, pending prices may
+// be larger as they hold whatever was scraped.
+const MaxPrice = 9999.99
+
+var (
+	// ErrPendingNotFound is returned when deciding a pending price which does
+	// not exist.
+	ErrPendingNotFound = errors.New("pending price not found")
+	// ErrPendingDecided is returned when deciding a pending price which has
+	// already been approved, corrected or discarded.
+	ErrPendingDecided = errors.New("pending price already decided")
+	// ErrPendingOutOfRange is returned when approving a pending price larger
+	// than MaxPrice, it must be corrected or discarded instead.
+	ErrPendingOutOfRange = errors.New("pending price out of range")
+)
+
+// PendingPrice is a scraped offer whose price was flagged as anomalous
+// against its record's history, held for review rather than written to the
+// pricing table. Approving it writes its price as scraped, correcting it
+// writes CorrectedPrice instead. DecidedBy and Note record who decided it and
+// why.
+type PendingPrice struct {
+	Id             int           `json:"id"`
+	Record         MatchedRecord `json:"record"`
+	Date           time.Time     `json:"date"`
+	Offer          records.Offer `json:"offer"`
+	Reasons        []string      `json:"reasons"`
+	Status         string        `json:"status"`
+	CorrectedPrice *float32      `json:"corrected_price,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	DecidedAt      *time.Time    `json:"decided_at,omitempty"`
+	DecidedBy      string        `json:"decided_by,omitempty"`
+	Note           string        `json:"note,omitempty"`
+}
+
+// priceHistory returns the last prices of a record from a retailer before
+// the snapshot, and the currency they were last recorded in.
+func (w snapshotWriter) priceHistory(recordID int, retailer string) (records.PriceHistory, error) {
+	rows, err := w.q.Query(`
+		SELECT price, COALESCE(currency, '')
+		FROM prices
+		WHERE record_id = $1 AND retailer = $2 AND captured_at < $3
+		ORDER BY captured_at DESC
+		LIMIT $4;`, recordID, retailer, w.at, priceHistoryLength)
+	if err != nil {
+		return records.PriceHistory{}, err
+	}
+	defer rows.Close()
+
+	var h records.PriceHistory
+	for rows.Next() {
+		var price float32
+		var currency string
+		if err := rows.Scan(&price, &currency); err != nil {
+			return h, err
+		}
+		h.Prices = append(h.Prices, price)
+		if h.Currency == "" {
+			h.Currency = currency
+		}
+	}
+	return h, rows.Err()
+}
+
+// InsertCheckedRecord inserts a record as InsertRecord does, except that
+// tracked offers whose price is anomalous against the record's history under
+// rules, or which were held while scraping, are held in the pending prices
+// table for review rather than priced. Their listings are still recorded.
+// Records not yet in the database have no history so only the prices held
+// while scraping are held. The record id and the prices held are returned.
+func (pg *PgInstance) InsertCheckedRecord(rec *records.Record, rules records.AnomalyRules) (int, []*PendingPrice, error) {
+	w := snapshotWriter{q: pg.db, at: pg.snapshot(time.Now())}
+	recordID, held, err := w.checkedRecord(rec, rules)
+	if err != nil {
+		return recordID, held, fmt.Errorf("InsertCheckedRecord() failed: %w", err)
+	}
+	return recordID, held, nil
+}
+
+// checkedRecord writes rec, holding its anomalous prices for review, see
+// InsertCheckedRecord.
+func (w snapshotWriter) checkedRecord(rec *records.Record, rules records.AnomalyRules) (int, []*PendingPrice, error) {
+	recordID, err := findRecord(w.q, rec)
+	if err == sql.ErrNoRows {
+		if len(rec.GetHeld()) == 0 {
+			recordID, _, err = w.record(rec)
+			return recordID, nil, err
+		}
+		recordID, err = w.recordID(rec)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("finding record failed: %w", err)
+	}
+
+	offerType, err := w.offerType(recordID)
+	if err != nil {
+		return recordID, nil, err
+	}
+
+	var held []*PendingPrice
+	flagged := make(map[records.Offer]bool)
+	for _, o := range rec.GetOffers().Tracked(offerType) {
+		h, err := w.priceHistory(recordID, o.Retailer)
+		if err != nil {
+			return recordID, held, fmt.Errorf("reading price history failed: %w", err)
+		}
+		reasons := append(rules.Check(o, h), rec.GetHeld()...)
+		if len(reasons) == 0 {
+			continue
+		}
+		p := &PendingPrice{
+			Record:  MatchedRecord{Id: recordID, Artist: rec.GetArtist(), Album: rec.GetAlbum()},
+			Date:    w.at,
+			Offer:   o,
+			Reasons: reasons,
+			Status:  PendingHeld,
+		}
+		err = w.q.QueryRow(`
+			INSERT INTO
+				pending_prices (record_id, date, retailer, url, price, shipping, currency, available,
+					seller, fulfilled, condition, reasons, captured_at, run_id)
+			VALUES
+				($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), $8, NULLIF($9, ''), $10, $11, $12, $13, $14)
+			RETURNING id, created_at;`,
+			recordID, w.at, o.Retailer, o.Url, o.Price, o.Shipping, o.Currency, o.Available,
+			o.Seller, o.Fulfilled, condition(o), pq.Array(reasons), w.at, w.run).Scan(&p.Id, &p.CreatedAt)
+		if err != nil {
+			return recordID, held, fmt.Errorf("holding price failed: %w", err)
+		}
+		logger().Warn("price held for review", "record_id", recordID, "album", rec.GetAlbum(),
+			"retailer", o.Retailer, "price", o.Price, "reasons", reasons, "pending_id", p.Id)
+		flagged[o] = true
+		held = append(held, p)
+	}
+
+	if _, _, err := w.recordHolding(rec, flagged); err != nil {
+		return recordID, held, err
+	}
+	// the prices held are not reported as scraped until they are decided.
+	var kept records.Offers
+	for _, o := range rec.GetOffers() {
+		if !flagged[o] {
+			kept = append(kept, o)
+		}
+	}
+	rec.WithOffers(kept)
+	return recordID, held, nil
+}
+
+// GetPendingPrices gets the pending prices with the given status, or all
+// pending prices if status is empty, oldest first.
+func (pg *PgInstance) GetPendingPrices(status string) ([]*PendingPrice, error) {
+	rows, err := pg.db.Query(`
+		SELECT p.id, p.record_id, r.artist, r.album, p.captured_at, p.retailer, COALESCE(p.url, ''), p.price,
+			p.shipping, COALESCE(p.currency, ''), p.available, COALESCE(p.seller, ''), p.fulfilled,
+			p.condition, p.reasons, p.status, p.corrected_price, p.created_at, p.decided_at,
+			COALESCE(p.decided_by, ''), COALESCE(p.note, '')
+		FROM pending_prices p
+		INNER JOIN records r ON r.id = p.record_id
+		WHERE $1 = '' OR p.status = $1
+		ORDER BY p.created_at, p.id;`, status)
+	if err != nil {
+		return nil, fmt.Errorf("GetPendingPrices() query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []*PendingPrice
+	for rows.Next() {
+		p := &PendingPrice{}
+		o := &p.Offer
+		if err := rows.Scan(&p.Id, &p.Record.Id, &p.Record.Artist, &p.Record.Album, &p.Date, &o.Retailer,
+			&o.Url, &o.Price, &o.Shipping, &o.Currency, &o.Available, &o.Seller, &o.Fulfilled, &o.Condition,
+			pq.Array(&p.Reasons), &p.Status, &p.CorrectedPrice, &p.CreatedAt, &p.DecidedAt, &p.DecidedBy,
+			&p.Note); err != nil {
+			return nil, fmt.Errorf("GetPendingPrices() row scan failed: %w", err)
+		}
+		pending = append(pending, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetPendingPrices() failed: %w", err)
+	}
+	return pending, nil
+}
+
+// ApprovePendingPrice writes the price held with the given id to the pricing
+// table as scraped, recording who approved it in the audit trail.
+func (pg *PgInstance) ApprovePendingPrice(id int, by, note string) error {
+	return pg.decidePending(id, PendingApproved, nil, by, note)
+}
+
+// CorrectPendingPrice writes price to the pricing table in place of the
+// price held with the given id, as a manual price so later scrapes do not
+// overwrite it, recording who corrected it in the audit trail.
+func (pg *PgInstance) CorrectPendingPrice(id int, price float32, by, note string) error {
+	return pg.decidePending(id, PendingCorrected, &price, by, note)
+}
+
+// DiscardPendingPrice discards the price held with the given id, nothing is
+// written to the pricing table.
+func (pg *PgInstance) DiscardPendingPrice(id int, by, note string) error {
+	return pg.decidePending(id, PendingDiscarded, nil, by, note)
+}
+
+// decidePending records the decision made on a pending price and who made
+// it, writing its price, or corrected, unless it is discarded. A scraped
+// price already written for the retailer in the snapshot of the pending
+// price is replaced, one entered by hand is kept. Prices written are
+// recorded in the audit trail.
+func (pg *PgInstance) decidePending(id int, status string, corrected *float32, by, note string) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return fmt.Errorf("decidePending() begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	e := PriceEntry{Source: SourceScraped}
+	err = tx.QueryRow(`
+		SELECT status, record_id, captured_at, retailer, price, shipping, available
+		FROM pending_prices
+		WHERE id = $1
+		FOR UPDATE;`, id).Scan(&current, &e.RecordID, &e.CapturedAt, &e.Retailer, &e.Price, &e.Shipping, &e.Available)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("decidePending() of %d failed: %w", id, ErrPendingNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("decidePending() of %d failed: %w", id, err)
+	}
+	if current != PendingHeld {
+		return fmt.Errorf("decidePending() of %d failed: %w", id, ErrPendingDecided)
+	}
+	if corrected != nil {
+		e.Price, e.Source = *corrected, SourceManual
+	}
+	if status != PendingDiscarded && e.Price > MaxPrice {
+		return fmt.Errorf("decidePending() of %d failed: %w", id, ErrPendingOutOfRange)
+	}
+
+	if status != PendingDiscarded {
+		if err := writePending(tx, id, e, by, note); err != nil {
+			return fmt.Errorf("decidePending() writing price of %d failed: %w", id, err)
+		}
+	}
+	_, err = tx.Exec(`
+		UPDATE pending_prices
+		SET status = $2, corrected_price = $3, decided_at = now(), decided_by = $4, note = NULLIF($5, '')
+		WHERE id = $1;`, id, status, corrected, by, note)
+	if err != nil {
+		return fmt.Errorf("decidePending() of %d failed: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("decidePending() commit failed: %w", err)
+	}
+	return nil
+}
+
+// writePending writes e, the price decided on the pending price with the
+// given id, to its snapshot within tx and records it in the audit trail. A
+// price entered by hand in the snapshot is kept and nothing is written.
+func writePending(tx *sql.Tx, id int, e PriceEntry, by, note string) error {
+	var old *PriceEntry
+	err := tx.QueryRow(`
+		SELECT id
+		FROM prices
+		WHERE captured_at = $1 AND record_id = $2 AND retailer = $3;`, e.CapturedAt, e.RecordID, e.Retailer).Scan(&e.Id)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	default:
+		if old, err = lockPrice(tx, e.Id); err != nil {
+			return err
+		}
+		if old.Source != SourceScraped {
+			logger().Info("price entered by hand kept", "pending_id", id, "price_id", old.Id)
+			return nil
+		}
+	}
+
+	action := AuditInsert
+	if old == nil {
+		err = tx.QueryRow(`
+			INSERT INTO
+				prices (date, captured_at, price, shipping, record_id, retailer, available, seller, fulfilled,
+					condition, currency, run_id, source)
+			SELECT date, captured_at, $2::numeric, shipping, record_id, retailer, available, seller, fulfilled,
+				condition, currency, run_id, $3::text
+			FROM pending_prices
+			WHERE id = $1
+			RETURNING id;`, id, e.Price, e.Source).Scan(&e.Id)
+	} else {
+		action = AuditUpdate
+		_, err = tx.Exec(`
+			UPDATE prices p
+			SET price = $3, shipping = d.shipping, available = d.available, seller = d.seller,
+				fulfilled = d.fulfilled, condition = d.condition, currency = d.currency, run_id = d.run_id,
+				source = $4
+			FROM pending_prices d
+			WHERE p.id = $1 AND d.id = $2;`, e.Id, id, e.Price, e.Source)
+	}
+	if err != nil {
+		return err
+	}
+	return audit(tx, action, old, &e, by, note)
+}
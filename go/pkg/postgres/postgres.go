@@ -2,24 +2,40 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/1602077/webscraper/go/pkg/records"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PgInstance struct {
 	db *sql.DB
+	// interval is the granularity of price snapshots, DefaultSnapshotInterval
+	// if unset.
+	interval time.Duration
 }
 
 var pginstance *PgInstance
 
+// logger returns the default logger tagged as the postgres component.
+func logger() *slog.Logger {
+	return slog.Default().With("component", "postgres")
+}
+
+// fatal logs msg at error level and exits the process.
+func fatal(msg string, args ...any) {
+	logger().Error(msg, args...)
+	os.Exit(1)
+}
+
 // NewPgInstace is a factory function for creating a singleton PgInstance
 // TODO:  Embed this into the Connect method and remove redundant PgInstance struct
 func GetPgInstance() *PgInstance {
@@ -33,7 +49,7 @@ func GetPgInstance() *PgInstance {
 func GetEnVar(filepath, key string) string {
 	err := godotenv.Load(filepath)
 	if err != nil {
-		log.Fatalf("error loading .env file: %s", err)
+		fatal("loading .env file failed", "path", filepath, "err", err)
 	}
 	return os.Getenv(key)
 }
@@ -44,7 +60,7 @@ func (pg *PgInstance) Connect(filepath string) *PgInstance {
 	host := GetEnVar(filepath, "DB_HOST")
 	port, err := strconv.Atoi(GetEnVar(filepath, "DB_PORT"))
 	if err != nil {
-		log.Fatalf("Connect() failed: Port convervsion failed: %v\n", err)
+		fatal("Connect() failed: port conversion failed", "err", err)
 	}
 	user := GetEnVar(filepath, "DB_USER")
 	pwd := GetEnVar(filepath, "DB_PASSWORD")
@@ -55,14 +71,14 @@ func (pg *PgInstance) Connect(filepath string) *PgInstance {
 
 	db, err := sql.Open("postgres", psqlInfo)
 	if err != nil {
-		log.Fatalf("err: opening connection to database '%s' failed.", dbname)
+		fatal("opening connection to database failed", "db", dbname, "err", err)
 	}
 
 	if err = db.Ping(); err != nil {
-		log.Fatalf("err: ping to database '%s' failed: %s", dbname, err)
+		fatal("ping to database failed", "db", dbname, "err", err)
 	}
 
-	log.Printf("connection to database '%s' opened.\n", dbname)
+	logger().Info("connection to database opened", "db", dbname)
 	pg.db = db
 	return pg
 }
@@ -70,85 +86,302 @@ func (pg *PgInstance) Connect(filepath string) *PgInstance {
 // Close connection to postgres database.
 func (pg *PgInstance) Close() {
 	pg.db.Close()
-	log.Print("connection to database closed.")
+	logger().Info("connection to database closed")
 }
 
-// GetRecordID retrieves the id of the input record from 'records' table.
-func (pg *PgInstance) GetRecordID(rec *records.Record) (int, bool) {
-	existsQuery := `
-		SELECT id
-		FROM records
-		WHERE artist = $1 AND album = $2
-		LIMIT 1;`
+// Ping verifies the connection to the database is still alive.
+func (pg *PgInstance) Ping(ctx context.Context) error {
+	return pg.db.PingContext(ctx)
+}
 
-	var recordID int
-	if err := pg.db.QueryRow(existsQuery, rec.GetArtist(), rec.GetAlbum()).Scan(&recordID); err == sql.ErrNoRows {
+// Stats returns the connection pool statistics of the database.
+func (pg *PgInstance) Stats() sql.DBStats {
+	return pg.db.Stats()
+}
+
+// GetRecordID retrieves the id of the input record from 'records' table. The
+// record is matched by the product ids of its listings, then their barcode,
+// then on its normalised identity against listings grouped under a record and
+// records themselves, falling back to the exact artist and album for records
+// whose identity keys have not been backfilled.
+func (pg *PgInstance) GetRecordID(rec *records.Record) (int, bool) {
+	recordID, err := findRecord(pg.db, rec)
+	if err == sql.ErrNoRows {
 		return 0, false
 	}
 	return recordID, true
 }
 
-// GetPriceID retrieves the id of a price row for a given record_id and date.
-func (pg *PgInstance) GetPriceID(recordID int, date time.Time) (int, bool) {
+// GetPriceID retrieves the id of a price row for a given record_id, retailer
+// and the snapshot containing t.
+func (pg *PgInstance) GetPriceID(recordID int, retailer string, t time.Time) (int, bool) {
 	existsQuery := `
 		SELECT id
 		FROM prices
-		WHERE date = $1 AND record_id = $2
+		WHERE captured_at = $1 AND record_id = $2 AND retailer = $3
 		LIMIT 1;`
 
 	var priceID int
-	if err := pg.db.QueryRow(existsQuery, date, recordID).Scan(&priceID); err == sql.ErrNoRows {
+	if err := pg.db.QueryRow(existsQuery, pg.snapshot(t), recordID, retailer).Scan(&priceID); err == sql.ErrNoRows {
 		return 0, false
 	}
 	return priceID, true
 }
 
-// GetCurrentRecordPrice gets most recent prices of all records in pg database.
+// GetListing retrieves the id of the record listed under a retailer's product
+// id, false is returned if the product has not been listed.
+func (pg *PgInstance) GetListing(id records.ProductID) (int, bool, error) {
+	var recordID int
+	err := pg.db.QueryRow(`
+		SELECT record_id
+		FROM listings
+		WHERE retailer = $1 AND product_id = $2;`,
+		id.Retailer, id.Id).Scan(&recordID)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, fmt.Errorf("GetListing() failed for %s: %w", id, err)
+	}
+	return recordID, true, nil
+}
+
+// GetCurrentRecordPrice gets most recent prices of all records in pg database,
+// each record has an offer for every retailer it has been priced by. Prices
+// are the offers tracked by each record so every offer counts towards its
+// price.
 func (pg *PgInstance) GetCurrentRecordPrices() records.Records {
 	rows, err := pg.db.Query(`
-		SELECT r.Artist, r.Album, p.MaxPrice
+		SELECT r.id, r.artist, r.album, p.retailer, p.price, p.shipping, p.available,
+			COALESCE(p.seller, ''), p.fulfilled, p.condition
 		FROM records r
 		INNER JOIN (
-			SELECT record_id, MAX(Date) as MaxDate, MAX(price) as MaxPrice
+			SELECT DISTINCT ON (record_id, retailer) record_id, retailer, price, shipping, available,
+				seller, fulfilled, condition
 			FROM prices
-			GROUP BY record_id
-		) p ON p.record_id = r.id;`)
+			ORDER BY record_id, retailer, captured_at DESC
+		) p ON p.record_id = r.id
+		ORDER BY r.id, p.retailer;`)
 
 	if err != nil {
-		log.Fatalf("err: GetCurrentRecordPrices() failed: %v.", err)
+		fatal("GetCurrentRecordPrices() query failed", "err", err)
 	}
 
 	var Records records.Records
+	lastID := 0
 	for rows.Next() {
+		var id int
 		var art, alb string
-		var price float32
-		if err := rows.Scan(&art, &alb, &price); err != nil {
+		var o records.Offer
+		if err := rows.Scan(&id, &art, &alb, &o.Retailer, &o.Price, &o.Shipping, &o.Available,
+			&o.Seller, &o.Fulfilled, &o.Condition); err != nil {
 			break
 		}
-		Records = append(Records, records.NewRecord(art, alb, "", price))
+		if id != lastID {
+			Records = append(Records, records.NewRecord(art, alb, "", 0).WithOffers(nil).WithOfferType(records.OfferAny))
+			lastID = id
+		}
+		rec := Records[len(Records)-1]
+		rec.WithOffers(append(rec.GetOffers(), o))
 	}
 	if err := rows.Err(); err != nil {
-		log.Fatalf("err: GetCurrentRecordPrices() failed: %v.", err)
+		fatal("GetCurrentRecordPrices() row read failed", "err", err)
 	}
 	return Records
 }
 
+// GetRecordSummaries gets the current, previous, lowest and highest price of
+// all records in pg database.
+func (pg *PgInstance) GetRecordSummaries() ([]*records.RecordSummary, error) {
+	page, err := pg.GetRecordPage(RecordQuery{})
+	if err != nil {
+		return nil, err
+	}
+	return page.Records, nil
+}
+
+// BackfillSortKeys computes the artist and album sort keys of records which
+// were inserted before the keys existed, returning the number updated.
+func (pg *PgInstance) BackfillSortKeys() (int, error) {
+	rows, err := pg.db.Query(`
+		SELECT id, artist, album
+		FROM records
+		WHERE artist_sort IS NULL OR album_sort IS NULL;`)
+	if err != nil {
+		return 0, fmt.Errorf("BackfillSortKeys() query failed: %w", err)
+	}
+
+	type record struct {
+		id            int
+		artist, album string
+	}
+	var missing []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.artist, &r.album); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("BackfillSortKeys() row scan failed: %w", err)
+		}
+		missing = append(missing, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("BackfillSortKeys() failed: %w", err)
+	}
+
+	for _, r := range missing {
+		_, err := pg.db.Exec(`
+			UPDATE records
+			SET artist_sort = $2, album_sort = $3
+			WHERE id = $1;`, r.id, records.ArtistSortKey(r.artist), records.SortKey(r.album))
+		if err != nil {
+			return 0, fmt.Errorf("BackfillSortKeys() update of record %d failed: %w", r.id, err)
+		}
+	}
+	return len(missing), nil
+}
+
+// UpdateRecordSettings updates the tags, target price and tracked offer type
+// of the record with the given id, a target price of 0 clears the target.
+// Changing the offer type re-tracks every price scraped with its seller
+// offers. false is returned if no such record exists.
+func (pg *PgInstance) UpdateRecordSettings(id int, rs records.RecordSettings) (bool, error) {
+	var tags any
+	if rs.Tags != nil {
+		tags = pq.Array(rs.Tags)
+	}
+	var offerType any
+	if rs.OfferType != nil {
+		offerType = string(*rs.OfferType)
+	}
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("UpdateRecordSettings() failed to begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE records
+		SET tags = COALESCE($2, tags),
+			target_price = CASE WHEN $3::numeric IS NULL THEN target_price ELSE NULLIF($3::numeric, 0) END,
+			offer_type = COALESCE($4, offer_type)
+		WHERE id = $1;`, id, tags, rs.TargetPrice, offerType)
+	if err != nil {
+		return false, fmt.Errorf("UpdateRecordSettings() failed: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("UpdateRecordSettings() failed: %w", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if rs.OfferType != nil {
+		if err := retrackPrices(tx, id, *rs.OfferType); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("UpdateRecordSettings() failed to commit: %w", err)
+	}
+	return true, nil
+}
+
+// retrackPrices rewrites the scraped prices of a record in every snapshot
+// its seller offers were scraped with the cheapest offer of type t from each
+// retailer. A retailer's price is removed from snapshots it had no offer of
+// type t, prices scraped before seller offers were recorded and those
+// entered by hand are left as they are.
+func retrackPrices(tx *sql.Tx, recordID int, t records.OfferType) error {
+	rows, err := tx.Query(`
+		SELECT captured_at, date, retailer, seller, fulfilled, condition, price, shipping, available, run_id
+		FROM seller_offers
+		WHERE record_id = $1
+		ORDER BY captured_at, id;`, recordID)
+	if err != nil {
+		return fmt.Errorf("retrackPrices() query of record %d failed: %w", recordID, err)
+	}
+	var snapshots []time.Time
+	dates := make(map[time.Time]time.Time)
+	runs := make(map[time.Time]sql.NullInt64)
+	bySnapshot := make(map[time.Time]records.Offers)
+	for rows.Next() {
+		var at, date time.Time
+		var run sql.NullInt64
+		var o records.Offer
+		if err := rows.Scan(&at, &date, &o.Retailer, &o.Seller, &o.Fulfilled, &o.Condition, &o.Price, &o.Shipping, &o.Available, &run); err != nil {
+			rows.Close()
+			return fmt.Errorf("retrackPrices() scan of record %d failed: %w", recordID, err)
+		}
+		if _, ok := bySnapshot[at]; !ok {
+			snapshots = append(snapshots, at)
+			dates[at] = date
+			runs[at] = run
+		}
+		bySnapshot[at] = append(bySnapshot[at], o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("retrackPrices() read of record %d failed: %w", recordID, err)
+	}
+
+	for _, at := range snapshots {
+		offers := bySnapshot[at]
+		tracked := make(map[string]records.Offer)
+		for _, o := range offers.Tracked(t) {
+			tracked[o.Retailer] = o
+		}
+		for _, o := range offers {
+			if _, ok := tracked[o.Retailer]; ok {
+				continue
+			}
+			if _, err := tx.Exec(`
+				DELETE FROM prices
+				WHERE captured_at = $1 AND record_id = $2 AND retailer = $3 AND source = 'scraped';`,
+				at, recordID, o.Retailer); err != nil {
+				return fmt.Errorf("retrackPrices() dropping price of record %d failed: %w", recordID, err)
+			}
+		}
+		for _, o := range tracked {
+			if _, err := tx.Exec(`
+				INSERT INTO
+					prices (date, captured_at, price, shipping, record_id, retailer, available, seller, fulfilled, condition,
+						run_id)
+				VALUES
+					($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11)
+				ON CONFLICT (captured_at, record_id, retailer) DO UPDATE
+				SET price = EXCLUDED.price, shipping = EXCLUDED.shipping, available = EXCLUDED.available,
+					seller = EXCLUDED.seller, fulfilled = EXCLUDED.fulfilled, condition = EXCLUDED.condition,
+					run_id = EXCLUDED.run_id
+				WHERE prices.source = 'scraped';`,
+				dates[at], at, o.Price, o.Shipping, recordID, o.Retailer, o.Available, o.Seller, o.Fulfilled, condition(o),
+				runs[at]); err != nil {
+				return fmt.Errorf("retrackPrices() writing price of record %d failed: %w", recordID, err)
+			}
+		}
+	}
+	return nil
+}
+
 // GetAllRecordPrices retrieves the full price history of a single input record.
 func (pg *PgInstance) GetAllRecordPrices(r *records.Record) map[string]float32 {
+	prices := make(map[string]float32)
+	recordID, ok := pg.GetRecordID(r)
+	if !ok {
+		return prices
+	}
+
 	rows, err := pg.db.Query(`
-		SELECT date, price
+		SELECT date, MIN(price)
 		FROM prices
-		WHERE record_id IN (
-			SELECT id
-			FROM records
-			WHERE album = $1 AND artist = $2
-		);`, r.GetAlbum(), r.GetArtist())
+		WHERE record_id = $1
+		GROUP BY date;`, recordID)
 
 	if err != nil {
-		log.Fatalf("err: GetRecordPrices(%s) failed: %v.", r.GetAlbum(), err)
+		fatal("GetAllRecordPrices() query failed", "album", r.GetAlbum(), "err", err)
 	}
 
-	prices := make(map[string]float32)
 	for rows.Next() {
 		var date time.Time
 		var price float32
@@ -161,60 +394,296 @@ func (pg *PgInstance) GetAllRecordPrices(r *records.Record) map[string]float32 {
 	return prices
 }
 
-// InsertRecord adds record to the 'records' table if it does not exist and
-// inserts into current price into the pricing table. If a price already exists
-// for the date of insert it is updated instead.
+// InsertRecord adds record to the 'records' table, in its normalised form, if
+// a record of the same identity does not exist. Every offer is written to the
+// seller offers table and the offer of each retailer matching the record's
+// tracked offer type into the pricing table. If a retailer's price already
+// exists for the snapshot of the time of insert it is updated instead. The
+// id of the price of the cheapest tracked offer is returned.
 func (pg *PgInstance) InsertRecord(rec *records.Record) (int, int) {
-	recordID, ok := pg.GetRecordID(rec)
-	if !ok {
-		insertQuery := `
-			INSERT INTO
-				records (artist, album)
-			VALUES
-				($1, $2)
-			RETURNING ID;`
+	return pg.insertRecordOn(rec, time.Now())
+}
 
-		var rID int
-		err := pg.db.QueryRow(insertQuery, rec.GetArtist(), rec.GetAlbum()).Scan(&rID)
-		if err != nil {
-			log.Fatalf("err: InsertRecordIntoRecords() failed: %v.", err)
-		}
-		recordID = rID
+// insertRecordOn inserts a record scraped at t, see InsertRecord.
+func (pg *PgInstance) insertRecordOn(rec *records.Record, t time.Time) (int, int) {
+	w := snapshotWriter{q: pg.db, at: pg.snapshot(t)}
+	recordID, priceID, err := w.record(rec)
+	if err != nil {
+		logger().Error("InsertRecord() failed", "artist", rec.GetArtist(), "album", rec.GetAlbum(), "err", err)
+	}
+	return recordID, priceID
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used to write scraped records,
+// so that a record may be written alone or as part of a scrape run.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// snapshotWriter writes scraped records through q into the snapshot at,
+// linking the prices and offers written to the scrape run with id run, if it
+// is set.
+type snapshotWriter struct {
+	q   querier
+	at  time.Time
+	run *int
+}
+
+// findRecord retrieves the id of rec through q, see GetRecordID.
+// sql.ErrNoRows is returned if it does not exist.
+func findRecord(q querier, rec *records.Record) (int, error) {
+	existsQuery := `
+		SELECT record_id
+		FROM (
+			SELECT record_id, -1 AS rank
+			FROM listings
+			WHERE (retailer, product_id) IN (SELECT unnest($6::text[]), unnest($7::text[]))
+			UNION ALL
+			SELECT record_id, 0
+			FROM listings
+			WHERE barcode = NULLIF($5, '')
+			UNION ALL
+			SELECT record_id, 1
+			FROM listings
+			WHERE artist_key = $1 AND album_key = $2
+			UNION ALL
+			SELECT id, 2
+			FROM records
+			WHERE (artist_key = $1 AND album_key = $2)
+				OR (artist_key IS NULL AND artist = $3 AND album = $4)
+		) m
+		ORDER BY rank, record_id
+		LIMIT 1;`
+
+	var retailers, productIDs []string
+	for _, id := range rec.ProductIDs() {
+		retailers, productIDs = append(retailers, id.Retailer), append(productIDs, id.Id)
+	}
+
+	n := rec.Normalise()
+	var recordID int
+	err := q.QueryRow(existsQuery, n.ArtistKey, n.AlbumKey, rec.GetArtist(), rec.GetAlbum(),
+		rec.GetBarcode(), pq.Array(retailers), pq.Array(productIDs)).Scan(&recordID)
+	return recordID, err
+}
+
+// recordID returns the id of rec, inserting it in its normalised form if a
+// record of the same identity does not exist. A record of the same identity
+// inserted concurrently is returned rather than conflicting.
+func (w snapshotWriter) recordID(rec *records.Record) (int, error) {
+	recordID, err := findRecord(w.q, rec)
+	if err != sql.ErrNoRows {
+		return recordID, err
 	}
+	n := rec.Normalise()
+	err = w.q.QueryRow(`
+		INSERT INTO
+			records (artist, album, artist_sort, album_sort, artist_key, album_key,
+				featured_artists, edition, format)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''))
+		ON CONFLICT (artist_key, album_key) DO UPDATE
+		SET artist_key = EXCLUDED.artist_key
+		RETURNING ID;`, n.Artist, n.Album,
+		records.ArtistSortKey(n.Artist), records.SortKey(n.Album), n.ArtistKey, n.AlbumKey,
+		pq.Array(featuredArtists(n)), n.Edition, n.Format).Scan(&recordID)
+	if err != nil {
+		return 0, fmt.Errorf("inserting record failed: %w", err)
+	}
+	return recordID, nil
+}
 
-	today := time.Now()
-	priceID, ok := pg.GetPriceID(recordID, today)
-	if ok {
-		updateQuery := `
-			UPDATE prices
-			SET price = $1
-			WHERE date = $2 AND record_id = $3
-			RETURNING ID;`
+// offerType returns the type of offer the record with the given id tracks.
+func (w snapshotWriter) offerType(recordID int) (records.OfferType, error) {
+	offerType := records.DefaultOfferType
+	if err := w.q.QueryRow(`SELECT offer_type FROM records WHERE id = $1;`, recordID).Scan(&offerType); err != nil {
+		return offerType, fmt.Errorf("reading offer type of record %d failed: %w", recordID, err)
+	}
+	return offerType, nil
+}
 
-		err := pg.db.QueryRow(updateQuery, rec.GetPrice(), today, recordID).Scan(&priceID)
-		if err == sql.ErrNoRows {
-			return recordID, priceID
+// record writes rec, its listings, seller offers and tracked prices, see
+// InsertRecord. The record id and the id of the price of the cheapest tracked
+// offer are returned.
+func (w snapshotWriter) record(rec *records.Record) (int, int, error) {
+	return w.recordHolding(rec, nil)
+}
+
+// recordHolding writes rec as record does, except that the offers in held
+// are neither priced nor written as seller offers.
+func (w snapshotWriter) recordHolding(rec *records.Record, held map[records.Offer]bool) (int, int, error) {
+	recordID, err := w.recordID(rec)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := w.listings(recordID, rec); err != nil {
+		return recordID, 0, err
+	}
+	if err := w.sellerOffers(recordID, rec, held); err != nil {
+		return recordID, 0, err
+	}
+	offerType, err := w.offerType(recordID)
+	if err != nil {
+		return recordID, 0, err
+	}
+	tracked := rec.GetOffers().Tracked(offerType)
+
+	var cheapestID int
+	cheapest := tracked.Cheapest()
+	for _, o := range tracked {
+		if held[o] {
+			continue
+		}
+		priceID, err := w.price(recordID, rec, o)
+		if err != nil {
+			return recordID, cheapestID, err
+		}
+		if cheapest != nil && o == *cheapest {
+			cheapestID = priceID
 		}
-		log.Printf("%s: updated in db.", rec.GetAlbum())
-		return recordID, priceID
 	}
+	return recordID, cheapestID, nil
+}
 
-	insertQuery := `
+// price writes the price of a record's offer in the snapshot, updating the
+// price if the retailer has already been priced in the snapshot unless the
+// price was entered by hand or imported.
+func (w snapshotWriter) price(recordID int, rec *records.Record, o records.Offer) (int, error) {
+	var priceID int
+	var inserted bool
+	err := w.q.QueryRow(`
 		INSERT INTO
-			prices (date, price, record_id)
+			prices (date, price, shipping, record_id, retailer, available, seller, fulfilled, condition, currency,
+				captured_at, run_id)
 		VALUES
-			($1, $2, $3)
-		RETURNING ID;`
+			($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, NULLIF($10, ''), $11, $12)
+		ON CONFLICT (captured_at, record_id, retailer) DO UPDATE
+		SET price = EXCLUDED.price, shipping = EXCLUDED.shipping, available = EXCLUDED.available,
+			seller = EXCLUDED.seller, fulfilled = EXCLUDED.fulfilled, condition = EXCLUDED.condition,
+			currency = EXCLUDED.currency, run_id = EXCLUDED.run_id
+		WHERE prices.source = 'scraped'
+		RETURNING id, xmax = 0;`,
+		w.at, o.Price, o.Shipping, recordID, o.Retailer, o.Available,
+		o.Seller, o.Fulfilled, condition(o), o.Currency, w.at, w.run).Scan(&priceID, &inserted)
+	if err == sql.ErrNoRows {
+		err = w.q.QueryRow(`
+			SELECT id
+			FROM prices
+			WHERE captured_at = $1 AND record_id = $2 AND retailer = $3;`, w.at, recordID, o.Retailer).Scan(&priceID)
+		if err != nil {
+			return 0, fmt.Errorf("reading price of record %d failed: %w", recordID, err)
+		}
+		logger().Info("price entered by hand kept", "record_id", recordID, "retailer", o.Retailer, "captured_at", w.at)
+		return priceID, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("writing price of record %d failed: %w", recordID, err)
+	}
+	if inserted {
+		logger().Info("price written", "record_id", recordID, "album", rec.GetAlbum(), "retailer", o.Retailer, "price", o.Price)
+	} else {
+		logger().Info("price updated", "record_id", recordID, "album", rec.GetAlbum(), "retailer", o.Retailer, "price", o.Price)
+	}
+	return priceID, nil
+}
 
-	pg.db.QueryRow(insertQuery, today, rec.GetPrice(), recordID).Scan(&priceID)
-	log.Printf("%s: written to db.", rec.GetAlbum())
-	return recordID, priceID
+// condition returns the condition of an offer, which is new if unset.
+func condition(o records.Offer) string {
+	if o.Condition == "" {
+		return records.ConditionNew
+	}
+	return o.Condition
+}
+
+// sellerOffers writes the offer of every seller of a record in the snapshot,
+// other than those held for review, updating any offer the seller has
+// already made in the snapshot in the same condition.
+func (w snapshotWriter) sellerOffers(recordID int, rec *records.Record, held map[records.Offer]bool) error {
+	for _, o := range rec.GetOffers() {
+		if held[o] {
+			continue
+		}
+		_, err := w.q.Exec(`
+			INSERT INTO
+				seller_offers (date, record_id, retailer, seller, fulfilled, condition, price, shipping, available,
+					captured_at, run_id)
+			VALUES
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (captured_at, record_id, retailer, seller, condition) DO UPDATE
+			SET fulfilled = EXCLUDED.fulfilled, price = EXCLUDED.price,
+				shipping = EXCLUDED.shipping, available = EXCLUDED.available, run_id = EXCLUDED.run_id;`,
+			w.at, recordID, o.Retailer, o.Seller, o.Fulfilled, condition(o), o.Price, o.Shipping, o.Available, w.at, w.run)
+		if err != nil {
+			return fmt.Errorf("writing seller offer of record %d from %s failed: %w", recordID, o.Retailer, err)
+		}
+	}
+	return nil
+}
+
+// listingID returns the product id of an offer's listing, derived from its
+// url. Offers without a product url, such as those entered by hand, are
+// identified by the normalised artist and album of the record.
+func listingID(n records.Normalised, o records.Offer) (string, string) {
+	if id, canonical, err := records.ParseProductURL(o.Url); err == nil && id.Retailer == o.Retailer {
+		return id.Id, canonical
+	}
+	return "name:" + n.ArtistKey + "/" + n.AlbumKey, o.Url
+}
+
+// listings records the listing of each offer of rec under recordID,
+// keeping any identifiers previously scraped if they are now missing. A
+// listing identified by name before its product id was known takes on the
+// product id.
+func (w snapshotWriter) listings(recordID int, rec *records.Record) error {
+	n := rec.Normalise()
+	seen := make(map[string]bool)
+	for _, o := range rec.GetOffers() {
+		productID, url := listingID(n, o)
+		// marketplace offers share the listing of their retailer.
+		if seen[o.Retailer+":"+productID] {
+			continue
+		}
+		seen[o.Retailer+":"+productID] = true
+		if !strings.HasPrefix(productID, "name:") {
+			_, err := w.q.Exec(`
+				UPDATE listings
+				SET product_id = $3, url = $4
+				WHERE record_id = $1 AND retailer = $2 AND product_id = $5
+					AND NOT EXISTS (SELECT 1 FROM listings WHERE retailer = $2 AND product_id = $3);`,
+				recordID, o.Retailer, productID, url, "name:"+n.ArtistKey+"/"+n.AlbumKey)
+			if err != nil {
+				return fmt.Errorf("identifying listing %s of record %d failed: %w", productID, recordID, err)
+			}
+		}
+
+		_, err := w.q.Exec(`
+			INSERT INTO
+				listings (record_id, retailer, product_id, url, artist, album, artist_key, album_key,
+					barcode, catalogue_number)
+			VALUES
+				($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''))
+			ON CONFLICT (retailer, product_id) DO UPDATE
+			SET url = COALESCE(EXCLUDED.url, listings.url),
+				barcode = COALESCE(EXCLUDED.barcode, listings.barcode),
+				catalogue_number = COALESCE(EXCLUDED.catalogue_number, listings.catalogue_number);`,
+			recordID, o.Retailer, productID, url, rec.GetArtist(), rec.GetAlbum(), n.ArtistKey, n.AlbumKey,
+			rec.GetBarcode(), rec.GetCatalogueNumber())
+		if err != nil {
+			return fmt.Errorf("writing listing %s of record %d failed: %w", productID, recordID, err)
+		}
+	}
+	return nil
 }
 
 // PrintCurrentPrices prints the artist, album and most recent price for
-// all records in database as tab written table.
+// all records in database as tab written table, sorted by the
+// records.DefaultSortOrder.
 func (pg *PgInstance) PrintCurrentPrices() {
 	rec := pg.GetCurrentRecordPrices()
+	cmps, _ := records.DefaultSortOrder.Comparators()
+	rec.SortBy(cmps...)
 	rec.Print()
 }
 
@@ -222,44 +691,105 @@ func (pg *PgInstance) PrintCurrentPrices() {
 // the record specified by the input id.
 func (pg *PgInstance) GetRecordPriceHistory(id int) *records.RecordPriceHistory {
 	rIdQuery := `
-		SELECT r.artist, r.album
+		SELECT r.artist, r.album, r.offer_type
 		FROM records r
 		WHERE r.id = $1;`
 
 	var artist, album string
-	if err := pg.db.QueryRow(rIdQuery, id).Scan(&artist, &album); err != nil {
+	var offerType records.OfferType
+	if err := pg.db.QueryRow(rIdQuery, id).Scan(&artist, &album, &offerType); err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("err: GetRecordPriceHistory: no record id found at id %v\n", id)
+			logger().Warn("GetRecordPriceHistory: no record found", "record_id", id)
 			return nil
 		}
-		log.Printf("GetRecordPriceHistory: parsing sql rId query failed: %s\n", err)
+		logger().Error("GetRecordPriceHistory: record query failed", "record_id", id, "err", err)
 	}
 
 	phQuery := `
-		SELECT p.date, p.price
+		SELECT p.captured_at, p.price, p.shipping, p.retailer, COALESCE(p.seller, ''), p.condition
 		FROM prices p
 		WHERE p.record_id = $1
-		ORDER BY p.date ASC;`
+		ORDER BY p.captured_at ASC, p.retailer ASC;`
 
 	rows, err := pg.db.Query(phQuery, id)
 	if err != nil {
-		log.Printf("err: GetRecordPriceHistory: price history query failed: %s\n", err)
+		logger().Error("GetRecordPriceHistory: price history query failed", "record_id", id, "err", err)
 	}
 
 	var priceHistory []*records.PriceHist
 	for rows.Next() {
-		var date string
-		var price float32
-		if err := rows.Scan(&date, &price); err != nil {
+		ph := &records.PriceHist{}
+		if err := rows.Scan(&ph.Date, &ph.Price, &ph.Shipping, &ph.Retailer, &ph.Seller, &ph.Condition); err != nil {
 			break
 		}
-		priceHistory = append(priceHistory, &records.PriceHist{Date: date, Price: price})
+		priceHistory = append(priceHistory, ph)
+	}
+
+	offers, err := pg.getOffers(id)
+	if err != nil {
+		logger().Error("GetRecordPriceHistory: offers query failed", "record_id", id, "err", err)
+	}
+	sellerOffers, err := pg.getSellerOffers(id)
+	if err != nil {
+		logger().Error("GetRecordPriceHistory: seller offers query failed", "record_id", id, "err", err)
 	}
 
 	return &records.RecordPriceHistory{
 		Id:           id,
 		Artist:       artist,
 		Album:        album,
+		OfferType:    offerType,
+		Offers:       offers,
+		SellerOffers: sellerOffers,
 		PriceHistory: priceHistory,
 	}
 }
+
+// getOffers gets the latest price of the record with the given id from each
+// retailer, cheapest first.
+func (pg *PgInstance) getOffers(id int) (records.Offers, error) {
+	return queryOffers(pg.db, `
+		SELECT retailer, price, shipping, available, COALESCE(seller, ''), fulfilled, condition
+		FROM (
+			SELECT DISTINCT ON (retailer) retailer, price, shipping, available, seller, fulfilled, condition
+			FROM prices
+			WHERE record_id = $1
+			ORDER BY retailer, captured_at DESC
+		) latest
+		ORDER BY available DESC, price + shipping, retailer;`, id)
+}
+
+// getSellerOffers gets the offers of every seller of the record with the
+// given id from the latest scrape of each retailer, cheapest first.
+func (pg *PgInstance) getSellerOffers(id int) (records.Offers, error) {
+	return queryOffers(pg.db, `
+		SELECT retailer, price, shipping, available, seller, fulfilled, condition
+		FROM seller_offers
+		WHERE record_id = $1 AND (retailer, captured_at) IN (
+			SELECT retailer, MAX(captured_at)
+			FROM seller_offers
+			WHERE record_id = $1
+			GROUP BY retailer
+		)
+		ORDER BY available DESC, price + shipping, retailer, seller;`, id)
+}
+
+// queryOffers runs a query selecting the retailer, price, shipping,
+// availability, seller, fulfilment and condition of offers.
+func queryOffers(db *sql.DB, query string, args ...any) (records.Offers, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offers := records.Offers{}
+	for rows.Next() {
+		var o records.Offer
+		if err := rows.Scan(&o.Retailer, &o.Price, &o.Shipping, &o.Available, &o.Seller, &o.Fulfilled, &o.Condition); err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
@@ -1,11 +1,14 @@
 package postgres
 
 import (
+	"errors"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/lib/pq"
 )
 
 // Tests integration between records and postgres pkgs to confirm that records
@@ -44,8 +47,8 @@ func TestGetRecordPrices(t *testing.T) {
 	// Initial insert into records and prices
 	pg.InsertRecord(r1)
 	// Second insert into prices
-	pg.db.QueryRow(`INSERT INTO prices (date, price, record_id) VALUES ($1, $2, $3);`,
-		day2, p2, 1)
+	pg.db.QueryRow(`INSERT INTO prices (date, captured_at, price, record_id) VALUES ($1, $2, $3, $4);`,
+		day2, day2, p2, 1)
 
 	returned := pg.GetAllRecordPrices(r1)
 	expected := map[string]float32{
@@ -57,3 +60,928 @@ func TestGetRecordPrices(t *testing.T) {
 		t.Errorf("Pricing history returned does not matched inserted.")
 	}
 }
+
+// Builds a price history and confirms GetRecordSummaries reports the current,
+// previous, lowest and highest prices.
+func TestGetRecordSummaries(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	r1 := records.NewRecord("Chaka Khan", "I feel for you", "", 12.00)
+	pg.InsertRecord(r1)
+	for _, p := range []struct {
+		date  time.Time
+		price float32
+	}{
+		{time.Date(2022, 04, 14, 0, 0, 0, 0, time.Local), 15.00},
+		{time.Date(2022, 04, 15, 0, 0, 0, 0, time.Local), 10.50},
+		{time.Date(2022, 04, 16, 0, 0, 0, 0, time.Local), 11.00},
+	} {
+		pg.db.QueryRow(`INSERT INTO prices (date, captured_at, price, record_id) VALUES ($1, $2, $3, $4);`,
+			p.date, p.date, p.price, 1)
+	}
+
+	summaries, err := pg.GetRecordSummaries()
+	if err != nil {
+		t.Fatalf("GetRecordSummaries() returned an error: %s", err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("expected 1 summary, got %v", len(summaries))
+	}
+
+	got := summaries[0]
+	expected := &records.RecordSummary{
+		Id:            1,
+		Artist:        "Chaka Khan",
+		Album:         "I feel for you",
+		Date:          time.Now().Format("2006-01-02"),
+		Price:         12.00,
+		PreviousPrice: 11.00,
+		LowestPrice:   10.50,
+		HighestPrice:  15.00,
+		Retailer:      "amazon",
+		Available:     true,
+		Tags:          []string{},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("GetRecordSummaries() = %+v, expected %+v", got, expected)
+	}
+}
+
+// Inserts records and pages through them with filters and sorting applied to
+// confirm GetRecordPage returns every matching record exactly once.
+func TestGetRecordPage(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	insertRec := records.Records{
+		records.NewRecord("Tom Misch", "What Kinda Music", "", 25),
+		records.NewRecord("Tom Misch", "Geography", "", 20),
+		records.NewRecord("Tom Misch", "Beat Tape 2", "", 20),
+		records.NewRecord("Bon Iver", "Bon Iver", "", 30),
+	}
+	for _, rec := range insertRec {
+		pg.InsertRecord(rec)
+	}
+	target := float32(22)
+	pg.UpdateRecordSettings(1, records.RecordSettings{Tags: []string{"jazz"}, TargetPrice: &target})
+	pg.UpdateRecordSettings(2, records.RecordSettings{TargetPrice: &target})
+
+	var albums []string
+	q := RecordQuery{Artist: "misch", Sort: records.SortOrder{{Name: "price", Desc: true}}, Limit: 2}
+	for {
+		page, err := pg.GetRecordPage(q)
+		if err != nil {
+			t.Fatalf("GetRecordPage() returned an error: %s", err)
+		}
+		if page.Total != 3 {
+			t.Errorf("expected total of 3, got %v", page.Total)
+		}
+		for _, rs := range page.Records {
+			albums = append(albums, rs.Album)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	expected := []string{"What Kinda Music", "Beat Tape 2", "Geography"}
+	if !reflect.DeepEqual(albums, expected) {
+		t.Errorf("paged albums = %v, expected %v", albums, expected)
+	}
+
+	t.Run("tag and below target filters", func(t *testing.T) {
+		page, _ := pg.GetRecordPage(RecordQuery{Tag: "jazz"})
+		if page.Total != 1 || page.Records[0].Album != "What Kinda Music" {
+			t.Errorf("expected only What Kinda Music tagged jazz, got %+v", page.Records)
+		}
+		page, _ = pg.GetRecordPage(RecordQuery{BelowTarget: true})
+		if page.Total != 1 || page.Records[0].Album != "Geography" {
+			t.Errorf("expected only Geography below target, got %+v", page.Records)
+		}
+	})
+
+	t.Run("full text search", func(t *testing.T) {
+		page, _ := pg.GetRecordPage(RecordQuery{Search: "bon"})
+		if page.Total != 1 || page.Records[0].Artist != "Bon Iver" {
+			t.Errorf("expected only Bon Iver to match search, got %+v", page.Records)
+		}
+	})
+}
+
+func TestGetRecordPageLanded(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	pg.InsertRecord(records.NewRecord("Tom Misch", "Geography", "", 0).WithOffers(records.Offers{
+		{Retailer: "amazon", Price: 19.99, Shipping: 4.49, Available: true},
+	}))
+	pg.InsertRecord(records.NewRecord("Tom Misch", "What Kinda Music", "", 22))
+	target := float32(22)
+	pg.UpdateRecordSettings(1, records.RecordSettings{TargetPrice: &target})
+
+	albums := func(q RecordQuery) []string {
+		page, err := pg.GetRecordPage(q)
+		if err != nil {
+			t.Fatalf("GetRecordPage() returned an error: %s", err)
+		}
+		var albums []string
+		for _, rs := range page.Records {
+			albums = append(albums, rs.Album)
+		}
+		return albums
+	}
+
+	byPrice := records.SortOrder{{Name: "price"}}
+	if got := albums(RecordQuery{Sort: byPrice}); !reflect.DeepEqual(got, []string{"Geography", "What Kinda Music"}) {
+		t.Errorf("headline price order = %v", got)
+	}
+	if got := albums(RecordQuery{Sort: byPrice, Basis: records.BasisLanded}); !reflect.DeepEqual(got, []string{"What Kinda Music", "Geography"}) {
+		t.Errorf("landed price order = %v", got)
+	}
+	if got := albums(RecordQuery{BelowTarget: true, Basis: records.BasisLanded}); len(got) != 0 {
+		t.Errorf("landed price below target = %v, expected none", got)
+	}
+
+	page, _ := pg.GetRecordPage(RecordQuery{Basis: records.BasisLanded, Sort: byPrice})
+	if rs := page.Records[1]; rs.LowestPrice != 24.48 || rs.Current() != 24.48 {
+		t.Errorf("landed summary = %+v, expected a lowest price of 24.48", rs)
+	}
+	if _, err := pg.GetRecordPage(RecordQuery{Basis: "net"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("GetRecordPage() of an unknown basis = %v, expected ErrInvalidQuery", err)
+	}
+}
+
+// Inserts listings of the same release under different spellings and checks
+// they resolve to a single normalised record.
+func TestInsertRecordNormalised(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	id, _ := pg.InsertRecord(records.NewRecord("Tom Misch feat. Yussef Dayes", "What Kinda Music [VINYL]", "", 25))
+	for _, rec := range []*records.Record{
+		records.NewRecord("TOM MISCH", "WHAT KINDA MUSIC", "", 25),
+		records.NewRecord("Tom Misch", "What Kinda Music (Deluxe Edition)", "", 25),
+	} {
+		if got, ok := pg.GetRecordID(rec); !ok || got != id {
+			t.Errorf("GetRecordID(%s - %s) = %d, %t, Expected: %d, true", rec.GetArtist(), rec.GetAlbum(), got, ok, id)
+		}
+	}
+
+	var artist, album, format string
+	var featured []string
+	if err := pg.db.QueryRow(`SELECT artist, album, format, featured_artists FROM records WHERE id = $1;`, id).
+		Scan(&artist, &album, &format, pq.Array(&featured)); err != nil {
+		t.Fatal(err)
+	}
+	if artist != "Tom Misch feat. Yussef Dayes" || album != "What Kinda Music" || format != "VINYL" || !reflect.DeepEqual(featured, []string{"Yussef Dayes"}) {
+		t.Errorf("record stored as %q, %q, %q, %v", artist, album, format, featured)
+	}
+}
+
+// Creates duplicate records as inserted before normalisation and checks they
+// are merged into the record with the lowest id.
+func TestMergeDuplicateRecords(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	day1, day2 := time.Date(2022, 4, 16, 0, 0, 0, 0, time.Local), time.Date(2022, 4, 17, 0, 0, 0, 0, time.Local)
+	pg.db.Exec(`
+		INSERT INTO records (artist, album, tags, target_price)
+		VALUES ('TOM MISCH', 'WHAT KINDA MUSIC', '{jazz}', NULL),
+			('Tom Misch', 'What Kinda Music [VINYL]', '{wishlist}', 20),
+			('Bon Iver', 'Bon Iver', '{}', NULL);`)
+	pg.db.Exec(`
+		INSERT INTO prices (date, captured_at, price, record_id)
+		VALUES ($1, $3, 25, 1), ($1, $3, 24, 2), ($2, $4, 23, 2), ($1, $3, 20, 3);`, day1, day2, day1, day2)
+	pg.db.Exec(`INSERT INTO pending_prices (record_id, date, retailer, price, captured_at) VALUES (2, $1, 'amazon', 2, $1);`, day2)
+	pg.db.Exec(`
+		INSERT INTO price_audit (price_id, record_id, date, captured_at, retailer, action, new_price, changed_by)
+		VALUES (2, 2, $1, $1, 'amazon', 'insert', 24, 'sam');`, day1)
+
+	report, err := pg.MergeDuplicateRecords(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Merges) != 1 || report.PricesMoved != 1 || report.PricesDropped != 1 {
+		t.Errorf("dry run report %+v", report)
+	}
+	if _, ok := pg.GetRecordID(records.NewRecord("Tom Misch", "What Kinda Music [VINYL]", "", 0)); !ok {
+		t.Errorf("dry run merged records")
+	}
+
+	report, err = pg.MergeDuplicateRecords(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Merge{{Canonical: 1, Duplicates: []int{2}, Artist: "TOM MISCH", Album: "WHAT KINDA MUSIC"}}
+	if !reflect.DeepEqual(report.Merges, expected) {
+		t.Errorf("Expected merges: %+v\nGot: %+v", expected, report.Merges)
+	}
+
+	var n int
+	pg.db.QueryRow(`SELECT COUNT(*) FROM records;`).Scan(&n)
+	if n != 2 {
+		t.Errorf("Expected 2 records after merge, got %d", n)
+	}
+
+	var tags []string
+	var target *float32
+	pg.db.QueryRow(`SELECT tags, target_price FROM records WHERE id = 1;`).Scan(pq.Array(&tags), &target)
+	if !reflect.DeepEqual(tags, []string{"jazz", "wishlist"}) || target == nil || *target != 20 {
+		t.Errorf("merged settings: tags %v, target %v", tags, target)
+	}
+
+	hist := pg.GetRecordPriceHistory(1)
+	if len(hist.PriceHistory) != 2 || hist.PriceHistory[0].Price != 25 || hist.PriceHistory[1].Price != 23 {
+		t.Errorf("merged price history: %+v", hist.PriceHistory)
+	}
+
+	pending, err := pg.GetPendingPrices(PendingHeld)
+	if err != nil || len(pending) != 1 || pending[0].Record.Id != 1 {
+		t.Errorf("GetPendingPrices() after merge = %+v, %v, Expected: the pending price of record 2 moved to 1", pending, err)
+	}
+	if changes, err := pg.GetPriceChanges(1); err != nil || len(changes) != 1 || changes[0].ChangedBy != "sam" {
+		t.Errorf("GetPriceChanges() after merge = %+v, %v, Expected: the audit of record 2 moved to 1", changes, err)
+	}
+}
+
+// Inserts near duplicate records and checks they are suggested as a match,
+// that rejecting a match stops it being suggested and confirming one merges
+// the records.
+func TestRecordMatches(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	carner, _ := pg.InsertRecord(records.NewRecord("Loyle Carner", "Not Waving, But Drowning", "", 25))
+	typo, _ := pg.InsertRecord(records.NewRecord("Loyle Carner", "Not Waving But Drowing", "", 24))
+	geography, _ := pg.InsertRecord(records.NewRecord("Tom Misch", "Geography", "", 20).WithIdentifiers("", "BTR001LP"))
+	geografy, _ := pg.InsertRecord(records.NewRecord("Tom Misch", "Geografy", "", 20).WithIdentifiers("", "btr-001lp"))
+
+	// listings sharing a barcode are grouped without needing confirmation.
+	am, _ := pg.InsertRecord(records.NewRecord("Arctic Monkeys", "AM", "", 20).WithIdentifiers("0887828031719", ""))
+	if amLtd, _ := pg.InsertRecord(records.NewRecord("Arctic Monkeys", "AM Tour Pressing", "", 30).WithIdentifiers("887828031719", "")); amLtd != am {
+		t.Errorf("InsertRecord() of listing with a known barcode = %d, Expected: %d", amLtd, am)
+	}
+
+	if n, err := pg.SuggestMatches(records.MatchThreshold); err != nil || n != 2 {
+		t.Fatalf("SuggestMatches() = %d, %v, Expected: 2, nil", n, err)
+	}
+	if n, _ := pg.SuggestMatches(records.MatchThreshold); n != 0 {
+		t.Errorf("SuggestMatches() resuggested %d matches", n)
+	}
+
+	matches, err := pg.GetMatches(MatchSuggested)
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("GetMatches() = %v, %v", matches, err)
+	}
+	byRecord := map[int]*RecordMatch{}
+	for _, m := range matches {
+		byRecord[m.Record.Id] = m
+	}
+	if m := byRecord[geography]; m == nil || m.CandidateId != geografy ||
+		!reflect.DeepEqual(m.Reasons, []string{records.ReasonName, records.ReasonCatalogue}) {
+		t.Errorf("catalogue number match: %+v", m)
+	}
+
+	if err := pg.RejectMatch(byRecord[geography].Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := pg.RejectMatch(byRecord[geography].Id); !errors.Is(err, ErrMatchDecided) {
+		t.Errorf("RejectMatch() of a decided match = %v, Expected: %v", err, ErrMatchDecided)
+	}
+
+	merge, err := pg.ConfirmMatch(byRecord[carner].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merge.Canonical != carner || !reflect.DeepEqual(merge.Duplicates, []int{typo}) {
+		t.Errorf("ConfirmMatch() = %+v", merge)
+	}
+	confirmed, err := pg.GetMatches(MatchConfirmed)
+	if err != nil || len(confirmed) != 1 || confirmed[0].Id != byRecord[carner].Id || confirmed[0].DecidedAt == nil {
+		t.Errorf("GetMatches(%q) after merging = %v, %v, Expected: match %d", MatchConfirmed, confirmed, err, byRecord[carner].Id)
+	}
+	if id, ok := pg.GetRecordID(records.NewRecord("Loyle Carner", "Not Waving But Drowing", "", 0)); !ok || id != carner {
+		t.Errorf("GetRecordID() of merged listing = %d, %t, Expected: %d, true", id, ok, carner)
+	}
+	if _, err := pg.ConfirmMatch(0); !errors.Is(err, ErrMatchNotFound) {
+		t.Errorf("ConfirmMatch() of unknown match = %v, Expected: %v", err, ErrMatchNotFound)
+	}
+	if matches, _ := pg.GetMatches(MatchSuggested); len(matches) != 0 {
+		t.Errorf("GetMatches() after deciding = %v", matches)
+	}
+}
+
+// Inserts a record priced by several retailers and checks the cheapest offer
+// including shipping is summarised while every offer is listed for the record.
+func TestRecordOffers(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	offers := records.Offers{
+		{Retailer: "amazon", Price: 19.99, Shipping: 4.49, Available: true, Condition: records.ConditionNew},
+		{Retailer: "hmv", Price: 18, Available: false, Condition: records.ConditionNew},
+		{Retailer: "roughtrade", Price: 22, Available: true, Condition: records.ConditionNew},
+	}
+	id, _ := pg.InsertRecord(records.NewRecord("Tom Misch", "What Kinda Music", "", 0).WithOffers(offers))
+
+	summaries, err := pg.GetRecordSummaries()
+	if err != nil || len(summaries) != 1 {
+		t.Fatalf("GetRecordSummaries() = %v, %v", summaries, err)
+	}
+	if rs := summaries[0]; rs.Retailer != "roughtrade" || rs.Price != 22 || rs.OfferCount != 3 {
+		t.Errorf("cheapest offer summary = %+v", rs)
+	}
+
+	rph := pg.GetRecordPriceHistory(id)
+	expected := records.Offers{offers[2], offers[0], offers[1]}
+	if !reflect.DeepEqual(rph.Offers, expected) {
+		t.Errorf("GetRecordPriceHistory() offers\nExpected: %+v\nGot: %+v", expected, rph.Offers)
+	}
+	if len(rph.PriceHistory) != 3 {
+		t.Errorf("GetRecordPriceHistory() history = %+v, Expected a price per retailer", rph.PriceHistory)
+	}
+}
+
+func TestTrackedOfferType(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	rec := records.NewRecord("Tom Misch", "Geography", "https://www.amazon.co.uk/dp/B07BKSN5L8", 0).WithOffers(records.Offers{
+		{Retailer: "amazon", Price: 24.99, Available: true, Seller: "Amazon", Fulfilled: true, Condition: records.ConditionNew},
+		{Retailer: "amazon", Price: 19.5, Shipping: 2.99, Available: true, Seller: "Vinyl Vault", Condition: records.ConditionNew},
+		{Retailer: "amazon", Price: 12, Shipping: 2.99, Available: true, Seller: "Second Spin", Condition: records.ConditionUsedVeryGood},
+	})
+	id, _ := pg.InsertRecord(rec)
+
+	rph := pg.GetRecordPriceHistory(id)
+	if rph.OfferType != records.OfferNew || len(rph.PriceHistory) != 1 || rph.PriceHistory[0].Seller != "Vinyl Vault" {
+		t.Errorf("GetRecordPriceHistory() tracking %v = %+v, Expected the Vinyl Vault offer", rph.OfferType, rph.PriceHistory)
+	}
+	if len(rph.SellerOffers) != 3 || rph.SellerOffers[0].Seller != "Second Spin" {
+		t.Errorf("GetRecordPriceHistory() seller offers = %+v, Expected all 3 cheapest first", rph.SellerOffers)
+	}
+
+	used := records.OfferUsed
+	if ok, err := pg.UpdateRecordSettings(id, records.RecordSettings{OfferType: &used}); !ok || err != nil {
+		t.Fatalf("UpdateRecordSettings() = %v, %v", ok, err)
+	}
+	rph = pg.GetRecordPriceHistory(id)
+	if len(rph.PriceHistory) != 1 || rph.PriceHistory[0].Price != 12 || rph.PriceHistory[0].Condition != records.ConditionUsedVeryGood {
+		t.Errorf("GetRecordPriceHistory() tracking used = %+v, Expected the Second Spin offer", rph.PriceHistory)
+	}
+
+	retailer := records.OfferRetailer
+	pg.UpdateRecordSettings(id, records.RecordSettings{OfferType: &retailer})
+	pg.InsertRecord(rec)
+	if rph := pg.GetRecordPriceHistory(id); len(rph.PriceHistory) != 1 || rph.PriceHistory[0].Price != 24.99 {
+		t.Errorf("GetRecordPriceHistory() tracking retailer = %+v, Expected the Amazon offer", rph.PriceHistory)
+	}
+}
+
+func TestListingProductIDs(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	rec := records.NewRecord("Tom Misch", "Geography", "https://www.amazon.co.uk/Geography-VINYL-Tom-Misch/dp/B07BKSN5L8/ref=sr_1_1?qid=1&sr=8-1", 24.99)
+	pg.InsertRecord(rec)
+	recordID, ok := pg.GetRecordID(rec)
+	if !ok {
+		t.Fatalf("GetRecordID() = %v, %v, Expected the inserted record", recordID, ok)
+	}
+
+	id := records.ProductID{Retailer: "amazon", Id: "B07BKSN5L8"}
+	got, ok, err := pg.GetListing(id)
+	if err != nil || !ok || got != recordID {
+		t.Errorf("GetListing(%s) = %v, %v, %v, Expected: %v", id, got, ok, err, recordID)
+	}
+
+	// The same ASIN under a differently titled listing is the same record.
+	renamed := records.NewRecord("Misch, Tom", "Geography [Deluxe]", "https://www.amazon.co.uk/dp/b07bksn5l8?th=1&psc=1", 23.99)
+	if got, ok := pg.GetRecordID(renamed); !ok || got != recordID {
+		t.Errorf("GetRecordID(renamed) = %v, %v, Expected: %v", got, ok, recordID)
+	}
+
+	if _, ok, err := pg.GetListing(records.ProductID{Retailer: "amazon", Id: "B000000000"}); ok || err != nil {
+		t.Errorf("GetListing(unlisted) = %v, %v, Expected: false, nil", ok, err)
+	}
+}
+
+// Re-parsed prices are backfilled on dates they are missing and corrected on
+// those they differ, leaving others untouched. Corrections are written to the
+// snapshot they were compared with and anomalous ones are held for review.
+func TestCorrectPrices(t *testing.T) {
+	setupNoData()
+	defer teardown()
+	defer pg.SetSnapshotInterval(DefaultSnapshotInterval)
+
+	yesterday := time.Now().AddDate(0, 0, -1)
+	rec := records.NewRecord("Tom Misch", "What Kinda Music", "https://www.amazon.co.uk/dp/B084P38346", float32(25))
+	recordID, _ := pg.InsertRecord(rec)
+	reparse := func(price float32) records.Records {
+		return records.Records{
+			records.NewRecord("Tom Misch", "What Kinda Music", "https://www.amazon.co.uk/dp/B084P38346", price),
+		}
+	}
+
+	for _, date := range []time.Time{yesterday, time.Now()} {
+		corrections, err := pg.CorrectPrices(reparse(22), date, records.DefaultAnomalyRules, false)
+		if err != nil {
+			t.Fatalf("CorrectPrices() failed: %s", err)
+		}
+		if len(corrections) != 1 || corrections[0].RecordID != recordID || corrections[0].New != 22 {
+			t.Fatalf("CorrectPrices() = %+v, Expected: one correction to 22", corrections)
+		}
+	}
+
+	corrections, err := pg.CorrectPrices(reparse(22), yesterday, records.DefaultAnomalyRules, true)
+	if err != nil || len(corrections) != 0 {
+		t.Errorf("CorrectPrices() of unchanged prices = %+v, %v, Expected: none", corrections, err)
+	}
+
+	// the price read is corrected even once the interval no longer floors
+	// its snapshot to the same time.
+	pg.SetSnapshotInterval(24 * time.Hour)
+	corrections, err = pg.CorrectPrices(reparse(21), time.Now(), records.DefaultAnomalyRules, false)
+	if err != nil || len(corrections) != 1 || corrections[0].Old == nil || *corrections[0].Old != 22 {
+		t.Errorf("CorrectPrices() after changing interval = %+v, %v, Expected: 22 corrected to 21", corrections, err)
+	}
+
+	corrections, err = pg.CorrectPrices(reparse(1), time.Now(), records.DefaultAnomalyRules, false)
+	if err != nil || len(corrections) != 0 {
+		t.Errorf("CorrectPrices() of an anomalous price = %+v, %v, Expected: none, held for review", corrections, err)
+	}
+	if pending, err := pg.GetPendingPrices(PendingHeld); err != nil || len(pending) != 1 {
+		t.Errorf("GetPendingPrices() = %+v, %v, Expected: the anomalous price held", pending, err)
+	}
+
+	var rows int
+	pg.db.QueryRow(`SELECT COUNT(*) FROM prices WHERE record_id = $1;`, recordID).Scan(&rows)
+	if rows != 2 {
+		t.Errorf("prices written = %d, Expected: 2, one per date", rows)
+	}
+	prices := pg.GetAllRecordPrices(rec)
+	if len(prices) != 2 || prices[yesterday.Format("2006-11-02")] != 22 || prices[time.Now().Format("2006-11-02")] != 21 {
+		t.Errorf("GetAllRecordPrices() = %v, Expected: 22 yesterday and 21 today", prices)
+	}
+}
+
+func TestPendingPrices(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	url := "https://www.amazon.co.uk/dp/B084P38346"
+	rec := records.NewRecord("Tom Misch", "What Kinda Music", url, float32(25))
+	recordID, _ := pg.InsertRecord(rec)
+	for i, price := range []float32{24.99, 22.5, 24, 23.5, 25} {
+		date := time.Now().AddDate(0, 0, -i-1)
+		pg.db.Exec(`INSERT INTO prices (date, captured_at, price, record_id, currency) VALUES ($1, $2, $3, $4, 'GBP');`,
+			date, date, price, recordID)
+	}
+
+	scraped := func(price float32, currency string) *records.Record {
+		rec := records.NewRecord("Tom Misch", "What Kinda Music", url, price)
+		o := rec.GetOffers()[0]
+		o.Currency = currency
+		return rec.WithOffers(records.Offers{o})
+	}
+	tests := []struct {
+		name     string
+		rec      *records.Record
+		expected []string
+	}{
+		{"in range", scraped(23.99, "GBP"), nil},
+		{"parsed from the wrong number", scraped(2, "GBP"), []string{records.AnomalyNearZero}},
+		{"wrong currency", scraped(21, "EUR"), []string{records.AnomalyCurrency}},
+	}
+	for _, tt := range tests {
+		_, held, err := pg.InsertCheckedRecord(tt.rec, records.DefaultAnomalyRules)
+		if err != nil {
+			t.Fatalf("InsertCheckedRecord() %s failed: %s", tt.name, err)
+		}
+		var reasons []string
+		if len(held) == 1 {
+			reasons = held[0].Reasons
+		}
+		if len(held) > 1 || !reflect.DeepEqual(reasons, tt.expected) {
+			t.Errorf("InsertCheckedRecord() %s = %+v, Expected: held for %v", tt.name, held, tt.expected)
+		}
+	}
+	if got := pg.GetAllRecordPrices(rec)[time.Now().Format("2006-11-02")]; got != 23.99 {
+		t.Errorf("price today = %v, Expected: 23.99 with anomalies held", got)
+	}
+
+	pending, err := pg.GetPendingPrices(PendingHeld)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("GetPendingPrices() = %+v, %v, Expected: 2 pending prices", pending, err)
+	}
+	if err := pg.CorrectPendingPrice(pending[0].Id, 20, "sam", "parsed from the wrong number"); err != nil {
+		t.Fatalf("CorrectPendingPrice() failed: %s", err)
+	}
+	if err := pg.DiscardPendingPrice(pending[1].Id, "sam", "priced in euros"); err != nil {
+		t.Fatalf("DiscardPendingPrice() failed: %s", err)
+	}
+	if err := pg.ApprovePendingPrice(pending[1].Id, "sam", ""); !errors.Is(err, ErrPendingDecided) {
+		t.Errorf("ApprovePendingPrice() of a decided price = %v, Expected: %v", err, ErrPendingDecided)
+	}
+	if err := pg.ApprovePendingPrice(-1, "sam", ""); !errors.Is(err, ErrPendingNotFound) {
+		t.Errorf("ApprovePendingPrice() of a missing price = %v, Expected: %v", err, ErrPendingNotFound)
+	}
+	if got := pg.GetAllRecordPrices(rec)[time.Now().Format("2006-11-02")]; got != 20 {
+		t.Errorf("price today = %v, Expected: the corrected 20", got)
+	}
+	decided, err := pg.GetPendingPrices(PendingDiscarded)
+	if err != nil || len(decided) != 1 || decided[0].DecidedBy != "sam" || decided[0].Note != "priced in euros" {
+		t.Errorf("GetPendingPrices() = %+v, %v, Expected: discarded by sam", decided, err)
+	}
+	changes, err := pg.GetPriceChanges(recordID)
+	if err != nil {
+		t.Fatalf("GetPriceChanges() failed: %s", err)
+	}
+	if len(changes) == 0 || changes[0].ChangedBy != "sam" || changes[0].Note != "parsed from the wrong number" ||
+		changes[0].NewSource == nil || *changes[0].NewSource != SourceManual {
+		t.Errorf("GetPriceChanges() = %+v, Expected: the correction by sam first", changes)
+	}
+
+	var huge int
+	pg.db.QueryRow(`
+		INSERT INTO pending_prices (record_id, retailer, price, captured_at)
+		VALUES ($1, 'amazon', 12499, now())
+		RETURNING id;`, recordID).Scan(&huge)
+	if err := pg.ApprovePendingPrice(huge, "sam", ""); !errors.Is(err, ErrPendingOutOfRange) {
+		t.Errorf("ApprovePendingPrice() of a price too large = %v, Expected: %v", err, ErrPendingOutOfRange)
+	}
+	if err := pg.DiscardPendingPrice(huge, "sam", "parsed from the wrong number"); err != nil {
+		t.Errorf("DiscardPendingPrice() of a price too large failed: %s", err)
+	}
+}
+
+// Prices held while scraping are held for review even for records not yet
+// in the database, whose listing and other sellers' offers are still written.
+func TestHeldWhileScraping(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	url := "https://www.amazon.co.uk/dp/B084P38346"
+	rec := records.NewRecord("Tom Misch", "What Kinda Music", url, 0).WithOffers(records.Offers{
+		{Retailer: records.DefaultRetailer, Url: url, Price: 0, Available: true},
+		{Retailer: records.DefaultRetailer, Url: url, Price: 18, Shipping: 2.99, Available: true,
+			Seller: "Vinyl Vault", Condition: records.ConditionUsedGood},
+	}).Hold("zero_price")
+
+	recordID, held, err := pg.InsertCheckedRecord(rec, records.DefaultAnomalyRules)
+	if err != nil {
+		t.Fatalf("InsertCheckedRecord() failed: %s", err)
+	}
+	if len(held) != 1 || !reflect.DeepEqual(held[0].Reasons, []string{"zero_price"}) || held[0].Record.Id != recordID {
+		t.Errorf("InsertCheckedRecord() held = %+v, Expected: the buy box held for zero_price", held)
+	}
+	if id, ok, err := pg.GetListing(records.ProductID{Retailer: records.DefaultRetailer, Id: "B084P38346"}); err != nil || !ok || id != recordID {
+		t.Errorf("GetListing() = %d, %t, %v, Expected: %d, true", id, ok, err, recordID)
+	}
+	var prices, offers int
+	pg.db.QueryRow(`SELECT COUNT(*) FROM prices WHERE record_id = $1;`, recordID).Scan(&prices)
+	pg.db.QueryRow(`SELECT COUNT(*) FROM seller_offers WHERE record_id = $1;`, recordID).Scan(&offers)
+	if prices != 0 || offers != 1 {
+		t.Errorf("prices, seller offers written = %d, %d, Expected: 0, 1", prices, offers)
+	}
+}
+
+func TestManualPrices(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	// scrapes and prices entered are in the same snapshot of today.
+	y, m, d := time.Now().Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.Local)
+	rec := records.NewRecord("Tom Misch", "What Kinda Music", "https://www.amazon.co.uk/dp/B084P38346", float32(25))
+	recordID, _ := pg.insertRecordOn(rec, noon)
+	today := func() float32 { return pg.GetAllRecordPrices(rec)[time.Now().Format("2006-11-02")] }
+
+	e := PriceEntry{RecordID: recordID, CapturedAt: noon, Retailer: records.DefaultRetailer, Price: 18, Source: SourceManual}
+	p, err := pg.InsertPrice(e, "jack", "seen at a record fair")
+	if err != nil {
+		t.Fatalf("InsertPrice() failed: %s", err)
+	}
+	if got := today(); got != 18 {
+		t.Errorf("price today = %v, Expected: the manual 18 replacing the scraped price", got)
+	}
+	if _, err := pg.InsertPrice(e, "jack", ""); !errors.Is(err, ErrPriceExists) {
+		t.Errorf("InsertPrice() of an entered price = %v, Expected: %v", err, ErrPriceExists)
+	}
+	if _, err := pg.InsertPrice(PriceEntry{RecordID: -1, CapturedAt: noon, Retailer: "hmv", Price: 1, Source: SourceManual}, "jack", ""); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("InsertPrice() of a missing record = %v, Expected: %v", err, ErrRecordNotFound)
+	}
+	e.Source = SourceScraped
+	if _, err := pg.InsertPrice(e, "jack", ""); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("InsertPrice() as scraped = %v, Expected: %v", err, ErrInvalidSource)
+	}
+
+	pg.insertRecordOn(records.NewRecord("Tom Misch", "What Kinda Music", "https://www.amazon.co.uk/dp/B084P38346", float32(30)), noon.Add(time.Minute))
+	if got := today(); got != 18 {
+		t.Errorf("price today after a scrape = %v, Expected: the manual 18 kept", got)
+	}
+
+	price := float32(17.5)
+	if _, err := pg.UpdatePrice(p.Id, &price, nil, "jill", "typo"); err != nil {
+		t.Fatalf("UpdatePrice() failed: %s", err)
+	}
+	if got := today(); got != 17.5 {
+		t.Errorf("price today = %v, Expected: the edited 17.5", got)
+	}
+	imported, err := pg.InsertPrice(PriceEntry{RecordID: recordID, CapturedAt: noon, Retailer: "hmv", Price: 21, Source: SourceImported}, "jack", "")
+	if err != nil {
+		t.Fatalf("InsertPrice() imported failed: %s", err)
+	}
+	if p, err := pg.UpdatePrice(imported.Id, nil, &price, "jill", ""); err != nil || p.Source != SourceImported {
+		t.Errorf("UpdatePrice() of an imported price = %+v, %v, Expected: source %s kept", p, err, SourceImported)
+	}
+	if err := pg.DeletePrice(p.Id, "jill", "wrong record"); err != nil {
+		t.Fatalf("DeletePrice() failed: %s", err)
+	}
+	if err := pg.DeletePrice(p.Id, "jill", ""); !errors.Is(err, ErrPriceNotFound) {
+		t.Errorf("DeletePrice() of a deleted price = %v, Expected: %v", err, ErrPriceNotFound)
+	}
+
+	changes, err := pg.GetPriceChanges(recordID)
+	if err != nil {
+		t.Fatalf("GetPriceChanges() failed: %s", err)
+	}
+	var actions []string
+	for _, c := range changes {
+		actions = append(actions, c.Action+" by "+c.ChangedBy)
+	}
+	expected := []string{"delete by jill", "update by jill", "insert by jack", "update by jill", "update by jack"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("GetPriceChanges() = %v, Expected: %v", actions, expected)
+	}
+}
+
+// Prices entered concurrently for the same snapshot are refused with
+// ErrPriceExists once one has been entered, rather than conflicting.
+func TestConcurrentManualPrices(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	recordID, _ := pg.InsertRecord(records.NewRecord("Tom Misch", "What Kinda Music", "", 25))
+	e := PriceEntry{RecordID: recordID, CapturedAt: time.Now(), Retailer: "hmv", Price: 18, Source: SourceManual}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = pg.InsertPrice(e, "jack", "")
+		}(i)
+	}
+	wg.Wait()
+
+	var entered int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			entered++
+		case !errors.Is(err, ErrPriceExists):
+			t.Errorf("InsertPrice() = %v, Expected: nil or %v", err, ErrPriceExists)
+		}
+	}
+	if entered != 1 {
+		t.Errorf("InsertPrice() entered %d prices, Expected: 1", entered)
+	}
+}
+
+// Scrapes within a snapshot update its price, those in later snapshots add
+// prices, and the daily view derives the open, close, low and high of each
+// date.
+func TestPriceSnapshots(t *testing.T) {
+	setupNoData()
+	defer teardown()
+	defer pg.SetSnapshotInterval(DefaultSnapshotInterval)
+
+	if err := pg.SetSnapshotInterval(7 * time.Hour); !errors.Is(err, ErrInvalidInterval) {
+		t.Errorf("SetSnapshotInterval(7h) = %v, Expected: %v", err, ErrInvalidInterval)
+	}
+	if err := pg.SetSnapshotInterval(time.Hour); err != nil {
+		t.Fatalf("SetSnapshotInterval(1h) failed: %s", err)
+	}
+
+	url := "https://www.amazon.co.uk/dp/B084P38346"
+	day := func(d, h, m int) time.Time { return time.Date(2024, 5, d, h, m, 0, 0, time.Local) }
+	var recordID int
+	for _, s := range []struct {
+		at    time.Time
+		price float32
+	}{
+		{day(4, 10, 5), 25},
+		{day(4, 10, 40), 24},
+		{day(4, 13, 10), 19},
+		{day(4, 17, 0), 22},
+		{day(5, 9, 0), 21},
+	} {
+		recordID, _ = pg.insertRecordOn(records.NewRecord("Tom Misch", "What Kinda Music", url, s.price), s.at)
+	}
+
+	if hist := pg.GetRecordPriceHistory(recordID).PriceHistory; len(hist) != 4 {
+		t.Errorf("GetRecordPriceHistory() = %d prices, Expected: 4 snapshots", len(hist))
+	}
+	daily, err := pg.GetDailyPrices(recordID)
+	if err != nil {
+		t.Fatalf("GetDailyPrices() failed: %s", err)
+	}
+	expected := []*records.DailyPrice{
+		{Date: "2024-05-04", Retailer: "amazon", Open: 24, Close: 22, Low: 19, High: 24, Snapshots: 3},
+		{Date: "2024-05-05", Retailer: "amazon", Open: 21, Close: 21, Low: 21, High: 21, Snapshots: 1},
+	}
+	if !reflect.DeepEqual(daily, expected) {
+		t.Errorf("GetDailyPrices() = %+v, Expected: %+v", daily, expected)
+	}
+}
+
+// Compaction keeps the close, low and high of each old date and week, every
+// all-time low or high and prices entered by hand, and a dry run removes
+// nothing.
+func TestCompactPrices(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	if _, err := pg.CompactPrices(RetentionPolicy{RawDays: 0}, true); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("CompactPrices() keeping no snapshots = %v, Expected: %v", err, ErrInvalidPolicy)
+	}
+
+	url := "https://www.amazon.co.uk/dp/B084P38346"
+	y, m, d := time.Now().Date()
+	daily := time.Date(y, m, d-40, 0, 0, 0, 0, time.Local)
+	weekly := time.Date(y, m, d-500, 0, 0, 0, 0, time.Local)
+	weekly = weekly.AddDate(0, 0, -(int(weekly.Weekday())+6)%7)
+	recent := time.Date(y, m, d-1, 0, 0, 0, 0, time.Local)
+	var recordID int
+	for _, s := range []struct {
+		at    time.Time
+		price float32
+	}{
+		{daily.Add(9 * time.Hour), 20},
+		{daily.Add(12 * time.Hour), 15},
+		{daily.Add(13 * time.Hour), 15},
+		{daily.Add(15 * time.Hour), 25},
+		{daily.Add(18 * time.Hour), 22},
+		{daily.Add(21 * time.Hour), 21},
+		{weekly.Add(12 * time.Hour), 30},
+		{weekly.AddDate(0, 0, 1).Add(12 * time.Hour), 31},
+		{weekly.AddDate(0, 0, 2).Add(12 * time.Hour), 29},
+		{weekly.AddDate(0, 0, 3).Add(12 * time.Hour), 32},
+		{weekly.AddDate(0, 0, 4).Add(12 * time.Hour), 30},
+		{recent.Add(10 * time.Hour), 24},
+		{recent.Add(11 * time.Hour), 26},
+	} {
+		recordID, _ = pg.insertRecordOn(records.NewRecord("Tom Misch", "What Kinda Music", url, s.price), s.at)
+	}
+	e := PriceEntry{RecordID: recordID, CapturedAt: daily.Add(10 * time.Hour), Retailer: records.DefaultRetailer, Price: 23, Source: SourceManual}
+	if _, err := pg.InsertPrice(e, "jack", ""); err != nil {
+		t.Fatalf("InsertPrice() failed: %s", err)
+	}
+	// a snapshot whose tracked offer was held has seller offers but no price.
+	unpriced := daily.Add(16 * time.Hour)
+	pg.db.Exec(`
+		INSERT INTO seller_offers (date, record_id, retailer, seller, condition, price, captured_at)
+		VALUES ($1, $2, 'amazon', 'Vinyl Vault', $3, 12, $1);`, unpriced, recordID, records.ConditionUsedGood)
+	count := func() (n int) {
+		pg.db.QueryRow(`SELECT COUNT(*) FROM prices;`).Scan(&n)
+		return n
+	}
+
+	expected := CompactionReport{
+		RawBefore:    time.Date(y, m, d-30, 0, 0, 0, 0, time.Local).Format(time.DateOnly),
+		WeeklyBefore: time.Date(y, m, d-395, 0, 0, 0, 0, time.Local).Format(time.DateOnly),
+		Daily:        2,
+		Weekly:       2,
+		Records:      1,
+		ExtremesKept: 1,
+		SellerOffers: 4,
+	}
+	for _, dryRun := range []bool{true, false} {
+		report, err := pg.CompactPrices(DefaultRetentionPolicy, dryRun)
+		if err != nil {
+			t.Fatalf("CompactPrices(%v) failed: %s", dryRun, err)
+		}
+		expected.DryRun = dryRun
+		if report != expected {
+			t.Errorf("CompactPrices(%v) = %+v, Expected: %+v", dryRun, report, expected)
+		}
+		if dryRun && count() != 14 {
+			t.Errorf("prices after a dry run = %d, Expected: 14", count())
+		}
+	}
+	if got := count(); got != 10 {
+		t.Errorf("prices after compaction = %d, Expected: 10", got)
+	}
+	if report, _ := pg.CompactPrices(DefaultRetentionPolicy, false); report.Daily != 0 || report.Weekly != 0 {
+		t.Errorf("CompactPrices() again = %+v, Expected: nothing removed", report)
+	}
+	var offers int
+	pg.db.QueryRow(`SELECT COUNT(*) FROM seller_offers WHERE captured_at = $1;`, unpriced).Scan(&offers)
+	if offers != 1 {
+		t.Errorf("seller offers of a snapshot with no price = %d, Expected: 1 kept", offers)
+	}
+
+	days, err := pg.GetDailyPrices(recordID)
+	if err != nil {
+		t.Fatalf("GetDailyPrices() failed: %s", err)
+	}
+	for _, p := range days {
+		if p.Date == daily.Format(time.DateOnly) && (p.Close != 21 || p.Low != 15 || p.High != 25) {
+			t.Errorf("GetDailyPrices() of a compacted date = %+v, Expected: close 21, low 15, high 25", p)
+		}
+	}
+}
+
+// A scrape run writes every record in one snapshot linked to the run, and a
+// second run in the same snapshot updates the prices rather than conflicting.
+func TestUpsertRecords(t *testing.T) {
+	setupNoData()
+	defer teardown()
+	defer pg.SetSnapshotInterval(DefaultSnapshotInterval)
+	pg.SetSnapshotInterval(24 * time.Hour)
+
+	rs := records.Records{
+		records.NewRecord("Tom Misch", "What Kinda Music", "https://www.amazon.co.uk/dp/B084P38346", 25),
+		records.NewRecord("Loyle Carner", "Hugo", "https://www.amazon.co.uk/dp/B0B5Q7Z5Y1", 22),
+	}
+	first, err := pg.UpsertRecords(rs, records.DefaultAnomalyRules)
+	if err != nil {
+		t.Fatalf("UpsertRecords() failed: %s", err)
+	}
+	if first.Status != RunCompleted || first.Records != 2 || first.Failed != 0 || first.Prices != 2 || len(first.Written) != 2 {
+		t.Errorf("UpsertRecords() = %+v, Expected: completed with 2 records and 2 prices", first)
+	}
+
+	second, err := pg.UpsertRecords(rs, records.DefaultAnomalyRules)
+	if err != nil {
+		t.Fatalf("UpsertRecords() again failed: %s", err)
+	}
+	if second.Id == first.Id || second.Prices != 2 {
+		t.Errorf("UpsertRecords() again = %+v, Expected: a new run updating 2 prices", second)
+	}
+
+	var prices, linked int
+	pg.db.QueryRow(`SELECT COUNT(*), COUNT(*) FILTER (WHERE run_id = $1) FROM prices;`, second.Id).Scan(&prices, &linked)
+	if prices != 2 || linked != 2 {
+		t.Errorf("prices = %d with %d linked to run %d, Expected: 2 both linked", prices, linked, second.Id)
+	}
+	var status string
+	pg.db.QueryRow(`SELECT status FROM scrape_runs WHERE id = $1;`, first.Id).Scan(&status)
+	if status != RunCompleted {
+		t.Errorf("status of run %d = %q, Expected: %q", first.Id, status, RunCompleted)
+	}
+}
+
+// Concurrent runs writing the same records in opposite orders both complete,
+// and a run left running by an interrupted process is failed on startup.
+func TestConcurrentRuns(t *testing.T) {
+	setupNoData()
+	defer teardown()
+
+	rs := records.Records{
+		records.NewRecord("Tom Misch", "What Kinda Music", "https://www.amazon.co.uk/dp/B084P38346", 25),
+		records.NewRecord("Loyle Carner", "Hugo", "https://www.amazon.co.uk/dp/B0B5Q7Z5Y1", 22),
+		records.NewRecord("The xx", "xx", "https://www.amazon.co.uk/dp/B002BWJ3J8", 20),
+	}
+	reversed := records.Records{rs[2], rs[1], rs[0]}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, run := range []records.Records{rs, reversed} {
+		wg.Add(1)
+		go func(i int, run records.Records) {
+			defer wg.Done()
+			_, errs[i] = pg.UpsertRecords(run, records.DefaultAnomalyRules)
+		}(i, run)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("UpsertRecords() run %d failed: %s", i, err)
+		}
+	}
+
+	var stale, live int
+	pg.db.QueryRow(`
+		INSERT INTO scrape_runs (captured_at, started_at)
+		VALUES (now(), now() - interval '2 hours')
+		RETURNING id;`).Scan(&stale)
+	pg.db.QueryRow(`INSERT INTO scrape_runs (captured_at) VALUES (now()) RETURNING id;`).Scan(&live)
+	if n, err := pg.FailStaleRuns(StaleRunAge); err != nil || n != 1 {
+		t.Errorf("FailStaleRuns() = %d, %v, Expected: 1, nil", n, err)
+	}
+	var status string
+	pg.db.QueryRow(`SELECT status FROM scrape_runs WHERE id = $1;`, stale).Scan(&status)
+	if status != RunFailed {
+		t.Errorf("status of run %d = %q, Expected: %q", stale, status, RunFailed)
+	}
+	pg.db.QueryRow(`SELECT status FROM scrape_runs WHERE id = $1;`, live).Scan(&status)
+	if status != RunRunning {
+		t.Errorf("status of run %d = %q, Expected: %q as it may still be written", live, status, RunRunning)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/1602077/webscraper/go/pkg/records"
 	_ "github.com/1602077/webscraper/go/testing"
@@ -117,6 +118,9 @@ func TestInsertRecordPricing(t *testing.T) {
 
 	t.Run("multiple inserts into db only write once to pricing table if day is the same",
 		func(t *testing.T) {
+			// a daily snapshot so the inserts can't straddle an hourly one.
+			pg.SetSnapshotInterval(24 * time.Hour)
+			defer pg.SetSnapshotInterval(DefaultSnapshotInterval)
 			pg.wipe()
 			pg.InsertRecord(recThatExists)
 			pg.InsertRecord(recThatExists2)
@@ -186,9 +190,9 @@ func (pg *PgInstance) insertTestData() *PgInstance {
 	return pg
 }
 
-// Runs "SELECT * FROM records"
+// Runs "SELECT id, artist, album FROM records"
 func (pg *PgInstance) GetAllRecords() *sql.Rows {
-	rows, err := pg.db.Query("SELECT * FROM records;")
+	rows, err := pg.db.Query("SELECT id, artist, album FROM records;")
 	if err != nil {
 		log.Fatalf("err: QueryRecordAllRows() failed: %v.", err)
 	}
@@ -0,0 +1,343 @@
+// api methods to read & write to postgres database
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/lib/pq"
+)
+
+// basisColumns are the columns of prices holding each basis of price.
+var basisColumns = map[records.PriceBasis]string{
+	records.BasisHeadline: "price",
+	records.BasisLanded:   "landed_price",
+}
+
+// summaryQuery selects each record with its cheapest current offer, being
+// the latest price of each retailer with the lowest landed price, preferring
+// available offers. The value, previous, lowest and highest prices are of
+// the basis b, the previous price is the same retailer's in the snapshot
+// before while the lowest and highest prices are across all retailers.
+// Records without any prices are excluded.
+func summaryQuery(b records.PriceBasis) string {
+	col, ok := basisColumns[b]
+	if !ok {
+		col = basisColumns[records.BasisHeadline]
+	}
+	return fmt.Sprintf(`
+	SELECT r.id, r.artist, r.album, r.tags, r.target_price, r.offer_type,
+		COALESCE(r.artist_sort, lower(r.artist)) AS artist_sort,
+		COALESCE(r.album_sort, lower(r.album)) AS album_sort,
+		cur.date, cur.price, cur.shipping, cur.retailer, cur.available, cur.offer_count, cur.value,
+		COALESCE(prev.value, cur.value) AS previous_value,
+		stats.min_value, stats.max_value
+	FROM records r
+	INNER JOIN LATERAL (
+		SELECT date, price, shipping, retailer, available, %[1]s AS value, COUNT(*) OVER () AS offer_count
+		FROM (
+			SELECT DISTINCT ON (retailer) date, price, shipping, landed_price, retailer, available
+			FROM prices
+			WHERE record_id = r.id
+			ORDER BY retailer, captured_at DESC
+		) latest
+		ORDER BY available DESC, landed_price, retailer
+		LIMIT 1
+	) cur ON true
+	LEFT JOIN LATERAL (
+		SELECT %[1]s AS value
+		FROM prices
+		WHERE record_id = r.id AND retailer = cur.retailer
+		ORDER BY captured_at DESC
+		OFFSET 1 LIMIT 1
+	) prev ON true
+	INNER JOIN (
+		SELECT record_id, MIN(%[1]s) AS min_value, MAX(%[1]s) AS max_value
+		FROM prices
+		GROUP BY record_id
+	) stats ON stats.record_id = r.id`, col)
+}
+
+// sortColumn is the sql expression and type of a field records can be
+// sorted by, evaluated against the columns of summaryQuery. Text fields sort
+// on the keys computed by records.SortKey under the "C" collation, comparing
+// bytes as go does rather than by the database's collation, so the order of
+// a page and the keyset condition of its cursor agree.
+type sortColumn struct {
+	expr    string
+	sqlType string
+}
+
+var sortColumns = map[string]sortColumn{
+	"id":       {"id", "int"},
+	"artist":   {`artist_sort COLLATE "C"`, "text"},
+	"album":    {`album_sort COLLATE "C"`, "text"},
+	"price":    {"value", "numeric"},
+	"change":   {"(value - previous_value)", "numeric"},
+	"discount": {"COALESCE((max_value - value) / NULLIF(max_value, 0), 0)", "numeric"},
+	"low":      {"min_value", "numeric"},
+}
+
+// ErrInvalidQuery is returned when a RecordQuery cannot be executed.
+var ErrInvalidQuery = errors.New("invalid record query")
+
+// RecordQuery filters, sorts and paginates the records returned by
+// GetRecordPage. Zero valued fields do not filter.
+type RecordQuery struct {
+	Artist      string   // case-insensitive substring of the artist
+	Search      string   // full text search over artist and album
+	MinPrice    *float32 // current price at or above
+	MaxPrice    *float32 // current price at or below
+	Available   *bool    // availability at the last scrape
+	Tag         string   // records tagged with
+	Retailer    string   // retailer of the current price
+	BelowTarget bool     // current price at or below the record's target
+
+	// price filtered, sorted and compared to the target on, and of the
+	// statistics of each record, the headline price if empty.
+	Basis records.PriceBasis
+
+	// fields of id, artist, album, price, change, discount or low, ties
+	// are broken by id in the direction of the last field.
+	Sort records.SortOrder
+
+	Limit  int    // page size, 0 returns all records
+	Cursor string // NextCursor of the previous page
+}
+
+// RecordPage is a single page of records matching a RecordQuery.
+type RecordPage struct {
+	Records    []*records.RecordSummary `json:"records"`
+	Total      int                      `json:"total"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// cursor is the position of the last record of a page in the sort order,
+// holding its value of each sort field.
+type cursor struct {
+	Values []string `json:"v"`
+	Id     int      `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
+
+// whereClause accumulates sql conditions and their positional arguments.
+type whereClause struct {
+	conds []string
+	args  []any
+}
+
+// add appends cond, in which each ? is replaced by the next positional
+// argument.
+func (w *whereClause) add(cond string, args ...any) {
+	for _, a := range args {
+		w.args = append(w.args, a)
+		cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(w.args)), 1)
+	}
+	w.conds = append(w.conds, cond)
+}
+
+func (w *whereClause) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// filters builds the where clause for all filters of q, excluding the cursor.
+func (q RecordQuery) filters() *whereClause {
+	w := &whereClause{}
+	if q.Artist != "" {
+		w.add("artist ILIKE ?", "%"+escapeLike(q.Artist)+"%")
+	}
+	if q.Search != "" {
+		w.add("to_tsvector('simple', artist || ' ' || album) @@ plainto_tsquery('simple', ?)", q.Search)
+	}
+	if q.MinPrice != nil {
+		w.add("value >= ?", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		w.add("value <= ?", *q.MaxPrice)
+	}
+	if q.Available != nil {
+		w.add("available = ?", *q.Available)
+	}
+	if q.Tag != "" {
+		w.add("? = ANY(tags)", q.Tag)
+	}
+	if q.Retailer != "" {
+		w.add("retailer = ?", q.Retailer)
+	}
+	if q.BelowTarget {
+		w.add("target_price IS NOT NULL AND value <= target_price")
+	}
+	return w
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// orderBy resolves the sort fields of q to their columns, appending id as
+// the final tie breaker.
+func (q RecordQuery) orderBy() ([]sortColumn, []bool, error) {
+	order := q.Sort
+	if len(order) == 0 {
+		order = records.SortOrder{{Name: "id"}}
+	}
+
+	var cols []sortColumn
+	var desc []bool
+	for _, f := range order {
+		col, ok := sortColumns[f.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, f.Name)
+		}
+		cols, desc = append(cols, col), append(desc, f.Desc)
+	}
+	if order[len(order)-1].Name != "id" {
+		cols, desc = append(cols, sortColumns["id"]), append(desc, desc[len(desc)-1])
+	}
+	return cols, desc, nil
+}
+
+// after adds the keyset condition selecting rows which sort after the cursor
+// position. As directions may be mixed this is expanded to
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func (w *whereClause) after(cols []sortColumn, desc []bool, values []string) {
+	var ors []string
+	for i := range cols {
+		var ands []string
+		for j := 0; j < i; j++ {
+			w.args = append(w.args, values[j])
+			ands = append(ands, fmt.Sprintf("%s = $%d::%s", cols[j].expr, len(w.args), cols[j].sqlType))
+		}
+		cmp := ">"
+		if desc[i] {
+			cmp = "<"
+		}
+		w.args = append(w.args, values[i])
+		ands = append(ands, fmt.Sprintf("%s %s $%d::%s", cols[i].expr, cmp, len(w.args), cols[i].sqlType))
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	w.conds = append(w.conds, "("+strings.Join(ors, " OR ")+")")
+}
+
+// GetRecordPage gets a page of record summaries matching q along with the
+// total number of matching records. The filtering, sorting and pagination
+// are all performed by postgres.
+func (pg *PgInstance) GetRecordPage(q RecordQuery) (*RecordPage, error) {
+	cols, desc, err := q.orderBy()
+	if err != nil {
+		return nil, err
+	}
+	if q.Limit < 0 {
+		return nil, fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+
+	basis := q.Basis
+	if basis == "" {
+		basis = records.BasisHeadline
+	}
+	if _, ok := basisColumns[basis]; !ok {
+		return nil, fmt.Errorf("%w: unknown price basis %q", ErrInvalidQuery, basis)
+	}
+
+	w := q.filters()
+	page := &RecordPage{}
+	countQuery := fmt.Sprintf(`WITH summary AS (%s) SELECT COUNT(*) FROM summary %s;`, summaryQuery(basis), w)
+	if err := pg.db.QueryRow(countQuery, w.args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("GetRecordPage() count query failed: %w", err)
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		// the id is the last value of every cursor.
+		if len(c.Values) != len(cols)-1 {
+			return nil, fmt.Errorf("%w: cursor does not match sort order", ErrInvalidQuery)
+		}
+		w.after(cols, desc, append(c.Values, fmt.Sprint(c.Id)))
+	}
+
+	var keys, order []string
+	for i, col := range cols {
+		keys = append(keys, fmt.Sprintf("(%s)::text", col.expr))
+		dir := "ASC"
+		if desc[i] {
+			dir = "DESC"
+		}
+		order = append(order, col.expr+" "+dir)
+	}
+
+	pageQuery := fmt.Sprintf(`
+		WITH summary AS (%s)
+		SELECT id, artist, album, tags, target_price, offer_type, date, price, shipping, retailer,
+			available, offer_count, previous_value, min_value, max_value, ARRAY[%s]
+		FROM summary
+		%s
+		ORDER BY %s`,
+		summaryQuery(basis), strings.Join(keys, ", "), w, strings.Join(order, ", "))
+	if q.Limit > 0 {
+		// fetch one extra row to know whether there is a next page.
+		w.args = append(w.args, q.Limit+1)
+		pageQuery += fmt.Sprintf(" LIMIT $%d", len(w.args))
+	}
+
+	rows, err := pg.db.Query(pageQuery+";", w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("GetRecordPage() query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var sortValues [][]string
+	for rows.Next() {
+		var date time.Time
+		var values []string
+		var target *float32
+		rs := &records.RecordSummary{}
+		if err := rows.Scan(&rs.Id, &rs.Artist, &rs.Album, pq.Array(&rs.Tags), &target, &rs.OfferType,
+			&date, &rs.Price, &rs.Shipping, &rs.Retailer, &rs.Available, &rs.OfferCount,
+			&rs.PreviousPrice, &rs.LowestPrice, &rs.HighestPrice, pq.Array(&values)); err != nil {
+			return nil, fmt.Errorf("GetRecordPage() row scan failed: %w", err)
+		}
+		rs.Date = date.Format("2006-01-02")
+		rs.TargetPrice = target
+		rs.Basis = basis
+		page.Records = append(page.Records, rs)
+		sortValues = append(sortValues, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetRecordPage() failed: %w", err)
+	}
+
+	if q.Limit > 0 && len(page.Records) > q.Limit {
+		page.Records = page.Records[:q.Limit]
+		last := page.Records[q.Limit-1]
+		values := sortValues[q.Limit-1]
+		page.NextCursor = encodeCursor(cursor{Values: values[:len(values)-1], Id: last.Id})
+	}
+	return page, nil
+}
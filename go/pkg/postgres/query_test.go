@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+func TestRecordQueryFilters(t *testing.T) {
+	min, yes := float32(10), true
+	q := RecordQuery{
+		Artist:      "100%_misch",
+		MinPrice:    &min,
+		Available:   &yes,
+		Tag:         "jazz",
+		BelowTarget: true,
+	}
+
+	w := q.filters()
+	expected := "WHERE artist ILIKE $1 AND value >= $2 AND available = $3 AND $4 = ANY(tags) AND " +
+		"target_price IS NOT NULL AND value <= target_price"
+	if w.String() != expected {
+		t.Errorf("filters()\nExpected: %s\nGot: %s", expected, w.String())
+	}
+
+	args := []any{`%100\%\_misch%`, min, yes, "jazz"}
+	if !reflect.DeepEqual(w.args, args) {
+		t.Errorf("filters() args\nExpected: %v\nGot: %v", args, w.args)
+	}
+
+	if (RecordQuery{}).filters().String() != "" {
+		t.Errorf("expected empty where clause for empty query")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := cursor{Values: []string{"tom misch", "25.00"}, Id: 12}
+	got, err := decodeCursor(encodeCursor(c))
+	if err != nil || !reflect.DeepEqual(got, c) {
+		t.Errorf("decodeCursor(encodeCursor(%v)) = %v, %v", c, got, err)
+	}
+
+	if _, err := decodeCursor("not a cursor!"); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("decodeCursor() err = %v, expected ErrInvalidQuery", err)
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	q := RecordQuery{Sort: records.SortOrder{{Name: "artist"}, {Name: "price", Desc: true}}}
+	cols, desc, err := q.orderBy()
+	if err != nil {
+		t.Fatalf("orderBy() returned an error: %s", err)
+	}
+	if len(cols) != 3 || cols[2].expr != "id" || !desc[2] {
+		t.Fatalf("expected id tie breaker in direction of last field, got %v %v", cols, desc)
+	}
+
+	w := &whereClause{}
+	w.after(cols, desc, []string{"tom misch", "25.00", "12"})
+	expected := `WHERE ((artist_sort COLLATE "C" > $1::text) OR ` +
+		`(artist_sort COLLATE "C" = $2::text AND value < $3::numeric) OR ` +
+		`(artist_sort COLLATE "C" = $4::text AND value = $5::numeric AND id < $6::int))`
+	if w.String() != expected {
+		t.Errorf("after()\nExpected: %s\nGot: %s", expected, w.String())
+	}
+
+	if _, _, err := (RecordQuery{Sort: records.SortOrder{{Name: "tags"}}}).orderBy(); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("orderBy() err = %v, expected ErrInvalidQuery", err)
+	}
+}
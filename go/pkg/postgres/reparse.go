@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// PriceCorrection is a retailer's price of a record on a date found by
+// re-parsing an archived page. Old is nil if the date had no price from the
+// retailer.
+type PriceCorrection struct {
+	RecordID int
+	Artist   string
+	Album    string
+	Retailer string
+	Date     time.Time
+	Old      *float32
+	New      float32
+}
+
+// CorrectPrices writes the tracked prices of records re-parsed from the pages
+// scraped on date, inserting those missing and correcting those which
+// differ. Prices are compared with and written to the record's last snapshot
+// of the date, the first snapshot of the date if it has none. Records not
+// yet in the database are created. Each record is written in its own
+// transaction as InsertCheckedRecord does, so anomalous prices are held for
+// review rather than corrected. The prices written are returned, nothing is
+// written if dryRun.
+func (pg *PgInstance) CorrectPrices(rs records.Records, date time.Time, rules records.AnomalyRules, dryRun bool) ([]PriceCorrection, error) {
+	var corrections []PriceCorrection
+	for _, rec := range rs {
+		changed, err := pg.correctRecord(rec, date, rules, dryRun)
+		if err != nil {
+			return corrections, fmt.Errorf("CorrectPrices() of %s - %s failed: %w", rec.GetArtist(), rec.GetAlbum(), err)
+		}
+		corrections = append(corrections, changed...)
+	}
+	return corrections, nil
+}
+
+// correctRecord corrects the prices of a single record re-parsed from the
+// pages scraped on date, see CorrectPrices.
+func (pg *PgInstance) correctRecord(rec *records.Record, date time.Time, rules records.AnomalyRules, dryRun bool) ([]PriceCorrection, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	recordID, err := findRecord(tx, rec)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("finding record failed: %w", err)
+	}
+	w := snapshotWriter{q: tx, at: pg.snapshot(date)}
+	offerType := records.DefaultOfferType
+	if exists {
+		if offerType, err = w.offerType(recordID); err != nil {
+			return nil, err
+		}
+		var last sql.NullTime
+		if err := tx.QueryRow(`
+			SELECT MAX(captured_at)
+			FROM prices
+			WHERE record_id = $1 AND date = $2;`, recordID, date).Scan(&last); err != nil {
+			return nil, fmt.Errorf("reading snapshot failed: %w", err)
+		}
+		if last.Valid {
+			w.at = last.Time
+		}
+	}
+
+	var changed []PriceCorrection
+	for _, o := range rec.GetOffers().Tracked(offerType) {
+		c := PriceCorrection{RecordID: recordID, Artist: rec.GetArtist(), Album: rec.GetAlbum(),
+			Retailer: o.Retailer, Date: date, New: o.Price}
+
+		var old, shipping float32
+		var available bool
+		var source string
+		err := tx.QueryRow(`
+			SELECT price, shipping, available, source
+			FROM prices
+			WHERE captured_at = $1 AND record_id = $2 AND retailer = $3
+			FOR UPDATE;`,
+			w.at, recordID, o.Retailer).Scan(&old, &shipping, &available, &source)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return nil, fmt.Errorf("reading price failed: %w", err)
+		case source != SourceScraped:
+			// prices entered by hand are not corrected by re-parsing.
+			continue
+		case old == o.Price && shipping == o.Shipping && available == o.Available:
+			continue
+		default:
+			c.Old = &old
+		}
+		changed = append(changed, c)
+	}
+	if len(changed) == 0 || dryRun {
+		return changed, nil
+	}
+
+	recordID, held, err := w.checkedRecord(rec, rules)
+	if err != nil {
+		return nil, err
+	}
+	// held prices are corrected only once they are approved.
+	heldBy := make(map[string]bool)
+	for _, p := range held {
+		heldBy[p.Offer.Retailer] = true
+	}
+	var written []PriceCorrection
+	for _, c := range changed {
+		if !heldBy[c.Retailer] {
+			c.RecordID = recordID
+			written = append(written, c)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %w", err)
+	}
+	return written, nil
+}
@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy decides how long price snapshots are kept at full
+// resolution before they are compacted, first to daily and then to weekly
+// summaries.
+type RetentionPolicy struct {
+	// RawDays is how many days of snapshots are kept as they were scraped.
+	RawDays int
+	// DailyDays is how many days, beyond RawDays, snapshots are kept as
+	// daily summaries before they are compacted to weekly summaries.
+	DailyDays int
+}
+
+// DefaultRetentionPolicy keeps a month of snapshots at full resolution and a
+// year of daily summaries, and weekly summaries thereafter.
+var DefaultRetentionPolicy = RetentionPolicy{
+	RawDays:   30,
+	DailyDays: 365,
+}
+
+// ErrInvalidPolicy is returned when compacting prices under a policy which
+// keeps no snapshots at full resolution, or a negative number of days of
+// daily summaries.
+var ErrInvalidPolicy = errors.New("retention policy must keep at least a day of snapshots")
+
+// Validate returns ErrInvalidPolicy if the policy keeps no snapshots at full
+// resolution or a negative number of days of daily summaries.
+func (p RetentionPolicy) Validate() error {
+	if p.RawDays < 1 || p.DailyDays < 0 {
+		return ErrInvalidPolicy
+	}
+	return nil
+}
+
+// CompactionReport is the outcome of compacting the price history, or what
+// it would be if DryRun.
+type CompactionReport struct {
+	RawBefore    string `json:"raw_before"`    // date before which snapshots are compacted to daily summaries
+	WeeklyBefore string `json:"weekly_before"` // date before which daily summaries are compacted to weekly summaries
+	Daily        int    `json:"daily"`         // snapshots removed compacting to daily summaries
+	Weekly       int    `json:"weekly"`        // snapshots removed compacting to weekly summaries
+	Records      int    `json:"records"`       // records whose snapshots were removed
+	ExtremesKept int    `json:"extremes_kept"` // snapshots kept only as they are an all-time low or high
+	SellerOffers int    `json:"seller_offers"` // seller offers removed with the snapshot they were scraped in
+	DryRun       bool   `json:"dry_run"`
+}
+
+// compactionCandidates selects the scraped snapshots dated before $1 which
+// are not the close, low or high of their period, by headline or landed
+// price, where the period is their date, or their week if dated before $2.
+// extreme is whether the snapshot is an all-time low or high of its record
+// from its retailer, and so must be kept.
+const compactionCandidates = `
+	SELECT id, record_id, retailer, captured_at, weekly, extreme
+	FROM (
+		SELECT id, record_id, retailer, captured_at, date, source, weekly,
+			row_number() OVER (span ORDER BY captured_at DESC) AS close_rank,
+			row_number() OVER (span ORDER BY price, captured_at) AS low_rank,
+			row_number() OVER (span ORDER BY price DESC, captured_at) AS high_rank,
+			row_number() OVER (span ORDER BY landed_price, captured_at) AS landed_low_rank,
+			row_number() OVER (span ORDER BY landed_price DESC, captured_at) AS landed_high_rank,
+			price IN (MIN(price) OVER all_time, MAX(price) OVER all_time)
+				OR landed_price IN (MIN(landed_price) OVER all_time, MAX(landed_price) OVER all_time) AS extreme
+		FROM (
+			SELECT *, date < $2::date AS weekly,
+				CASE WHEN date < $2::date THEN date_trunc('week', date)::date ELSE date END AS period_start
+			FROM prices
+		) p
+		WINDOW span AS (PARTITION BY record_id, retailer, period_start),
+			all_time AS (PARTITION BY record_id, retailer)
+	) ranked
+	WHERE date < $1::date AND source = 'scraped' AND close_rank > 1 AND low_rank > 1 AND high_rank > 1
+		AND landed_low_rank > 1 AND landed_high_rank > 1`
+
+// orphanedOffers matches the seller offers whose retailer's snapshot is
+// compacted. Offers of a snapshot with no price, as its tracked offer was
+// held or of another type, are kept.
+const orphanedOffers = `
+	EXISTS (
+		SELECT 1
+		FROM compacted c
+		WHERE c.record_id = s.record_id AND c.retailer = s.retailer AND c.captured_at = s.captured_at
+	)`
+
+// CompactPrices compacts the price history under policy. Snapshots older
+// than RawDays are reduced to those holding the close, low and high price,
+// headline and landed, of each retailer on their date, and those older than
+// a further DailyDays to those of their week. The daily view of compacted
+// dates keeps their exact close, low and high but its open and number of
+// snapshots are of the snapshots kept. Snapshots which are an all-time low
+// or high are never removed, nor are prices entered by hand or imported.
+// Seller offers are removed with the snapshot they were scraped in. Nothing
+// is removed if dryRun.
+func (pg *PgInstance) CompactPrices(policy RetentionPolicy, dryRun bool) (CompactionReport, error) {
+	if err := policy.Validate(); err != nil {
+		return CompactionReport{}, fmt.Errorf("CompactPrices() failed: %w", err)
+	}
+	y, m, d := time.Now().Date()
+	rawBefore := time.Date(y, m, d-policy.RawDays, 0, 0, 0, 0, time.Local)
+	weeklyBefore := rawBefore.AddDate(0, 0, -policy.DailyDays)
+	report := CompactionReport{
+		RawBefore:    rawBefore.Format(time.DateOnly),
+		WeeklyBefore: weeklyBefore.Format(time.DateOnly),
+		DryRun:       dryRun,
+	}
+
+	removed := `SELECT record_id, weekly FROM compacted`
+	offers := `SELECT s.id FROM seller_offers s WHERE ` + orphanedOffers
+	if !dryRun {
+		removed = `DELETE FROM prices p USING compacted c WHERE p.id = c.id RETURNING c.record_id, c.weekly`
+		offers = `DELETE FROM seller_offers s WHERE ` + orphanedOffers + ` RETURNING s.id`
+	}
+	err := pg.db.QueryRow(fmt.Sprintf(`
+		WITH candidates AS (%s),
+		compacted AS (SELECT id, record_id, retailer, captured_at, weekly FROM candidates WHERE NOT extreme),
+		removed AS (%s),
+		offers AS (%s)
+		SELECT
+			(SELECT COUNT(*) FILTER (WHERE NOT weekly) FROM removed),
+			(SELECT COUNT(*) FILTER (WHERE weekly) FROM removed),
+			(SELECT COUNT(DISTINCT record_id) FROM removed),
+			(SELECT COUNT(*) FROM candidates WHERE extreme),
+			(SELECT COUNT(*) FROM offers);`, compactionCandidates, removed, offers),
+		report.RawBefore, report.WeeklyBefore).Scan(&report.Daily, &report.Weekly, &report.Records,
+		&report.ExtremesKept, &report.SellerOffers)
+	if err != nil {
+		return report, fmt.Errorf("CompactPrices() failed: %w", err)
+	}
+	return report, nil
+}
+
+// ScheduleCompaction compacts the price history under policy every interval
+// until ctx is done, logging the report of each run. A run which fails is
+// logged and retried at the next interval.
+func (pg *PgInstance) ScheduleCompaction(ctx context.Context, policy RetentionPolicy, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		start := time.Now()
+		report, err := pg.CompactPrices(policy, false)
+		if err != nil {
+			logger().Error("compacting prices failed", "err", err)
+			continue
+		}
+		logger().Info("prices compacted", "raw_before", report.RawBefore, "weekly_before", report.WeeklyBefore,
+			"daily", report.Daily, "weekly", report.Weekly, "records", report.Records,
+			"extremes_kept", report.ExtremesKept, "seller_offers", report.SellerOffers,
+			"duration", time.Since(start))
+	}
+}
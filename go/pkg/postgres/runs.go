@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// statuses of a ScrapeRun.
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// ScrapeRun is a refresh of the prices of every record, whose snapshots are
+// written in a single transaction and linked to the run.
+type ScrapeRun struct {
+	Id         int        `json:"id"`
+	CapturedAt time.Time  `json:"captured_at"` // snapshot the run's prices were written to
+	Status     string     `json:"status"`
+	Records    int        `json:"records"` // records written
+	Failed     int        `json:"failed"`  // records whose writes failed and were rolled back
+	Prices     int        `json:"prices"`  // prices written
+	Held       int        `json:"held"`    // prices held for review
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Written is the records written, those whose writes failed are left out.
+	Written records.Records `json:"-"`
+}
+
+// StaleRunAge is how long after it started a run still recorded as running
+// is taken to have been interrupted. Runs write for seconds, so a run this
+// old was left by a process which exited before it ended.
+const StaleRunAge = time.Hour
+
+// UpsertRecords writes the records scraped by a refresh as a scrape run, in a
+// single transaction so that a run which fails or is interrupted writes
+// nothing. Each record is written as InsertCheckedRecord does, prices are
+// upserted into the current snapshot so concurrent runs update rather than
+// conflict. A record whose writes fail is rolled back alone, logged and
+// counted as failed. The run is recorded as running before the transaction
+// begins and as completed or failed once it ends.
+func (pg *PgInstance) UpsertRecords(rs records.Records, rules records.AnomalyRules) (*ScrapeRun, error) {
+	run := &ScrapeRun{CapturedAt: pg.snapshot(time.Now()), Status: RunRunning}
+	err := pg.db.QueryRow(`
+		INSERT INTO scrape_runs (captured_at)
+		VALUES ($1)
+		RETURNING id, started_at;`, run.CapturedAt).Scan(&run.Id, &run.StartedAt)
+	if err != nil {
+		return nil, fmt.Errorf("UpsertRecords() starting run failed: %w", err)
+	}
+
+	if err := pg.upsertRun(run, rs, rules); err != nil {
+		run.Status, run.Error = RunFailed, err.Error()
+		if _, ferr := pg.db.Exec(`
+			UPDATE scrape_runs
+			SET status = $2, error = $3, finished_at = now()
+			WHERE id = $1;`, run.Id, run.Status, run.Error); ferr != nil {
+			logger().Error("recording failed run failed", "run_id", run.Id, "err", ferr)
+		}
+		return run, fmt.Errorf("UpsertRecords() run %d failed: %w", run.Id, err)
+	}
+	return run, nil
+}
+
+// upsertRun writes the records of run in a transaction, completing the run
+// in the same transaction. Records are written in order of their identity so
+// concurrent runs lock the rows of the records they share in the same order
+// rather than deadlocking.
+func (pg *PgInstance) upsertRun(run *ScrapeRun, rs records.Records, rules records.AnomalyRules) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	w := snapshotWriter{q: tx, at: run.CapturedAt, run: &run.Id}
+	for _, rec := range sortedByIdentity(rs) {
+		if _, err := tx.Exec(`SAVEPOINT record;`); err != nil {
+			return fmt.Errorf("savepoint failed: %w", err)
+		}
+		recordID, _, err := w.checkedRecord(rec, rules)
+		if err != nil {
+			logger().Error("writing record failed", "run_id", run.Id, "record_id", recordID,
+				"artist", rec.GetArtist(), "album", rec.GetAlbum(), "err", err)
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT record;`); err != nil {
+				return fmt.Errorf("rolling back record failed: %w", err)
+			}
+			run.Failed++
+			continue
+		}
+		if _, err := tx.Exec(`RELEASE SAVEPOINT record;`); err != nil {
+			return fmt.Errorf("releasing savepoint failed: %w", err)
+		}
+		run.Records++
+		run.Written = append(run.Written, rec)
+	}
+
+	run.Status = RunCompleted
+	err = tx.QueryRow(`
+		UPDATE scrape_runs
+		SET status = $2, records = $3, failed = $4, finished_at = now(),
+			prices = (SELECT COUNT(*) FROM prices WHERE run_id = $1),
+			held = (SELECT COUNT(*) FROM pending_prices WHERE run_id = $1)
+		WHERE id = $1
+		RETURNING prices, held, finished_at;`, run.Id, run.Status, run.Records, run.Failed).
+		Scan(&run.Prices, &run.Held, &run.FinishedAt)
+	if err != nil {
+		return fmt.Errorf("completing run failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
+	return nil
+}
+
+// sortedByIdentity returns a copy of rs sorted by the identity keys of their
+// artist and album, then by url.
+func sortedByIdentity(rs records.Records) records.Records {
+	type keyed struct {
+		artist, album, url string
+		rec                *records.Record
+	}
+	ks := make([]keyed, len(rs))
+	for i, rec := range rs {
+		n := records.Normalise(rec.GetArtist(), rec.GetAlbum())
+		ks[i] = keyed{n.ArtistKey, n.AlbumKey, rec.GetUrl(), rec}
+	}
+	sort.SliceStable(ks, func(i, j int) bool {
+		if ks[i].artist != ks[j].artist {
+			return ks[i].artist < ks[j].artist
+		}
+		if ks[i].album != ks[j].album {
+			return ks[i].album < ks[j].album
+		}
+		return ks[i].url < ks[j].url
+	})
+	sorted := make(records.Records, len(ks))
+	for i, k := range ks {
+		sorted[i] = k.rec
+	}
+	return sorted
+}
+
+// FailStaleRuns records runs which started more than age ago and are still
+// running as failed, returning the number updated. A run is only left
+// running when the process writing it exits before the run ends, its
+// transaction having been rolled back. Runs younger than age may still be
+// written by another instance so are left running.
+func (pg *PgInstance) FailStaleRuns(age time.Duration) (int, error) {
+	res, err := pg.db.Exec(`
+		UPDATE scrape_runs
+		SET status = $1, error = 'interrupted', finished_at = now()
+		WHERE status = $2 AND started_at < $3;`, RunFailed, RunRunning, time.Now().Add(-age))
+	if err != nil {
+		return 0, fmt.Errorf("FailStaleRuns() failed: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
@@ -0,0 +1,24 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+func TestSortedByIdentity(t *testing.T) {
+	misch := records.NewRecord("Tom Misch", "What Kinda Music", "https://www.amazon.co.uk/dp/B084P38346", 25)
+	carner := records.NewRecord("Loyle Carner", "Hugo", "https://www.amazon.co.uk/dp/B0B5Q7Z5Y1", 22)
+	theXX := records.NewRecord("The xx", "xx", "https://www.amazon.co.uk/dp/B002BWJ3J8", 20)
+	mischHMV := records.NewRecord("TOM MISCH", "What Kinda Music", "https://hmv.com/tom-misch", 24)
+
+	rs := records.Records{misch, theXX, mischHMV, carner}
+	expected := records.Records{carner, mischHMV, misch, theXX}
+	if got := sortedByIdentity(rs); !reflect.DeepEqual(got, expected) {
+		t.Errorf("sortedByIdentity() = %v, Expected: %v", got, expected)
+	}
+	if rs[0] != misch {
+		t.Errorf("sortedByIdentity() reordered its argument")
+	}
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// DefaultSnapshotInterval is the granularity of price snapshots unless set,
+// a record scraped more than once an hour keeps only the last price of each
+// hour.
+const DefaultSnapshotInterval = time.Hour
+
+// ErrInvalidInterval is returned when setting a snapshot interval which does
+// not divide a day into whole snapshots of at least a minute.
+var ErrInvalidInterval = errors.New("snapshot interval must be at least a minute and divide a day evenly")
+
+// SetSnapshotInterval sets the granularity at which prices are recorded. A
+// retailer's price is kept once per snapshot, scraping a record again within
+// one updates its price rather than adding another. 24h records a single
+// price a day.
+func (pg *PgInstance) SetSnapshotInterval(d time.Duration) error {
+	if d < time.Minute || (24*time.Hour)%d != 0 {
+		return fmt.Errorf("SetSnapshotInterval(%s) failed: %w", d, ErrInvalidInterval)
+	}
+	pg.interval = d
+	return nil
+}
+
+// snapshot returns the start of the snapshot t falls in. Snapshots are
+// counted from local midnight so each lies within a single date, t is read
+// in local time as times read back from the database may not be.
+func (pg *PgInstance) snapshot(t time.Time) time.Time {
+	d := pg.interval
+	if d == 0 {
+		d = DefaultSnapshotInterval
+	}
+	t = t.In(time.Local)
+	y, m, day := t.Date()
+	midnight := time.Date(y, m, day, 0, 0, 0, 0, time.Local)
+	return midnight.Add(t.Sub(midnight) / d * d)
+}
+
+// GetDailyPrices gets the open, close, low and high price of a record from
+// each retailer on every date it was scraped, oldest first.
+func (pg *PgInstance) GetDailyPrices(recordID int) ([]*records.DailyPrice, error) {
+	rows, err := pg.db.Query(`
+		SELECT date, retailer, open, close, low, high, snapshots
+		FROM daily_prices
+		WHERE record_id = $1
+		ORDER BY date, retailer;`, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDailyPrices() query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var daily []*records.DailyPrice
+	for rows.Next() {
+		var date time.Time
+		d := &records.DailyPrice{}
+		if err := rows.Scan(&date, &d.Retailer, &d.Open, &d.Close, &d.Low, &d.High, &d.Snapshots); err != nil {
+			return nil, fmt.Errorf("GetDailyPrices() row scan failed: %w", err)
+		}
+		d.Date = date.Format(time.DateOnly)
+		daily = append(daily, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetDailyPrices() failed: %w", err)
+	}
+	return daily, nil
+}
@@ -0,0 +1,95 @@
+package records
+
+import (
+	"math"
+	"sort"
+)
+
+// reasons a price is flagged as anomalous.
+const (
+	AnomalyZScore   = "z_score"
+	AnomalyNearZero = "near_zero"
+	AnomalyCurrency = "currency_mismatch"
+)
+
+// AnomalyRules decide which scraped prices are held for review rather than
+// written, judged against the prices previously recorded from the same
+// retailer.
+type AnomalyRules struct {
+	// MaxZScore is how many standard deviations a price may lie from the
+	// mean of its history.
+	MaxZScore float64
+	// MinHistory is how many previous prices are needed to judge a z-score.
+	MinHistory int
+	// MinStdDev is the least standard deviation a price is judged against,
+	// as a fraction of the mean, so that a price which has never moved may
+	// still go on sale without every move being an outlier.
+	MinStdDev float64
+	// NearZero is the fraction of the median of its history below which a
+	// price is a drop to near zero.
+	NearZero float64
+}
+
+// DefaultAnomalyRules flag prices more than 4 standard deviations from the
+// mean of at least 5 previous prices, or below a tenth of their median. A
+// price which has never moved is judged against a standard deviation of 15%
+// of it, so sales of up to 60% off are written.
+var DefaultAnomalyRules = AnomalyRules{
+	MaxZScore:  4,
+	MinHistory: 5,
+	MinStdDev:  0.15,
+	NearZero:   0.1,
+}
+
+// PriceHistory is the prices previously recorded from a retailer, most
+// recent first, and the currency they were last recorded in, empty if
+// unknown.
+type PriceHistory struct {
+	Prices   []float32
+	Currency string
+}
+
+// Check returns the reasons the price of o is anomalous against its
+// history, nil if it is not.
+func (r AnomalyRules) Check(o Offer, h PriceHistory) []string {
+	var reasons []string
+	if o.Currency != "" && h.Currency != "" && o.Currency != h.Currency {
+		reasons = append(reasons, AnomalyCurrency)
+	}
+	if len(h.Prices) == 0 {
+		return reasons
+	}
+	if float64(o.Price) < r.NearZero*median(h.Prices) {
+		reasons = append(reasons, AnomalyNearZero)
+	} else if len(h.Prices) >= r.MinHistory && math.Abs(r.zScore(o.Price, h.Prices)) > r.MaxZScore {
+		reasons = append(reasons, AnomalyZScore)
+	}
+	return reasons
+}
+
+// zScore is how many standard deviations price lies from the mean of
+// prices.
+func (r AnomalyRules) zScore(price float32, prices []float32) float64 {
+	var mean float64
+	for _, p := range prices {
+		mean += float64(p) / float64(len(prices))
+	}
+	var variance float64
+	for _, p := range prices {
+		variance += math.Pow(float64(p)-mean, 2) / float64(len(prices))
+	}
+	sd := math.Max(math.Sqrt(variance), r.MinStdDev*mean)
+	if sd == 0 {
+		return 0
+	}
+	return (float64(price) - mean) / sd
+}
+
+func median(prices []float32) float64 {
+	s := append([]float32{}, prices...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	if len(s)%2 == 1 {
+		return float64(s[len(s)/2])
+	}
+	return float64(s[len(s)/2-1]+s[len(s)/2]) / 2
+}
@@ -0,0 +1,38 @@
+package records
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnomalyRules(t *testing.T) {
+	steady := []float32{24.99, 22.5, 24.99, 23, 24.99, 21.99}
+
+	tests := []struct {
+		name     string
+		offer    Offer
+		history  PriceHistory
+		expected []string
+	}{
+		{"no history", Offer{Price: 0.99}, PriceHistory{}, nil},
+		{"within history", Offer{Price: 19.99}, PriceHistory{Prices: steady}, nil},
+		{"outlier", Offer{Price: 79.99}, PriceHistory{Prices: steady}, []string{AnomalyZScore}},
+		{"too little history for a z-score", Offer{Price: 79.99}, PriceHistory{Prices: steady[:3]}, nil},
+		{"small move from a price which never moved", Offer{Price: 26}, PriceHistory{Prices: []float32{25, 25, 25, 25, 25}}, nil},
+		{"sale from a price which never moved", Offer{Price: 17.5}, PriceHistory{Prices: []float32{25, 25, 25, 25, 25}}, nil},
+		{"lightning deal from a price which never moved", Offer{Price: 12.5}, PriceHistory{Prices: []float32{25, 25, 25, 25, 25}}, nil},
+		{"doubled from a price which never moved", Offer{Price: 50}, PriceHistory{Prices: []float32{25, 25, 25, 25, 25}}, []string{AnomalyZScore}},
+		{"near zero", Offer{Price: 2}, PriceHistory{Prices: steady[:2]}, []string{AnomalyNearZero}},
+		{"zero", Offer{Price: 0}, PriceHistory{Prices: steady}, []string{AnomalyNearZero}},
+		{"currency", Offer{Price: 24.99, Currency: "EUR"}, PriceHistory{Prices: steady, Currency: "GBP"}, []string{AnomalyCurrency}},
+		{"currency unknown", Offer{Price: 24.99}, PriceHistory{Prices: steady, Currency: "GBP"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultAnomalyRules.Check(tt.offer, tt.history); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Check() = %v, Expected: %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package records
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MatchThreshold is the score at or above which two releases are suggested
+// as the same.
+const MatchThreshold = 0.85
+
+// reasons a pair of releases were scored as a match.
+const (
+	ReasonBarcode   = "barcode"
+	ReasonCatalogue = "catalogue_number"
+	ReasonName      = "name"
+)
+
+// Release is a record and the identifiers of the retailer listings grouped
+// under it, as compared when matching.
+type Release struct {
+	Id               int
+	Artist           string
+	Album            string
+	Barcodes         []string
+	CatalogueNumbers []string
+}
+
+// MatchScore is the likelihood, between 0 and 1, that two releases are the
+// same along with the evidence for it.
+type MatchScore struct {
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// Candidate is a pair of releases scored as a possible match, A is always
+// the lower id.
+type Candidate struct {
+	A, B int
+	MatchScore
+}
+
+// NormaliseBarcode reduces a scraped barcode to its digits, converting a
+// 12 digit UPC-A to the equivalent EAN-13. An empty string is returned if
+// the result is not a valid EAN-13.
+func NormaliseBarcode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	d := b.String()
+	if len(d) == 12 {
+		d = "0" + d
+	}
+	if len(d) != 13 {
+		return ""
+	}
+
+	sum := 0
+	for i, r := range d[:12] {
+		n := int(r - '0')
+		if i%2 == 1 {
+			n *= 3
+		}
+		sum += n
+	}
+	if (10-sum%10)%10 != int(d[12]-'0') {
+		return ""
+	}
+	return d
+}
+
+// NormaliseCatalogueNumber upper cases a catalogue number and removes
+// separators, so "XL-1234" and "xl 1234" compare equal.
+func NormaliseCatalogueNumber(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// ratio is the similarity of a and b from their edit distance.
+func ratio(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	n := max(len(ra), len(rb))
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(n)
+}
+
+// sortTokens orders the words of s so word order does not affect ratio.
+func sortTokens(s string) string {
+	f := strings.Fields(s)
+	sort.Strings(f)
+	return strings.Join(f, " ")
+}
+
+// Similarity scores how alike two names are between 0 and 1, comparing
+// their identity keys so case, accents and punctuation are ignored and
+// tolerating typos and reordered words.
+func Similarity(a, b string) float64 {
+	a, b = IdentityKey(a), IdentityKey(b)
+	return max(ratio(a, b), ratio(sortTokens(a), sortTokens(b)))
+}
+
+// intersects reports whether a and b share a non empty value.
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x != "" && x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ScoreMatch scores whether a and b are the same release. A shared barcode
+// is conclusive and differing barcodes rule a match out as they identify
+// different pressings. Otherwise the score is the similarity of the
+// normalised artist and album, raised by a shared catalogue number and
+// lowered by differing ones.
+func ScoreMatch(a, b Release) MatchScore {
+	if intersects(a.Barcodes, b.Barcodes) {
+		return MatchScore{Score: 1, Reasons: []string{ReasonBarcode}}
+	}
+	if len(a.Barcodes) > 0 && len(b.Barcodes) > 0 {
+		return MatchScore{Score: 0}
+	}
+
+	na, nb := Normalise(a.Artist, a.Album), Normalise(b.Artist, b.Album)
+	score := 0.4*Similarity(na.ArtistKey, nb.ArtistKey) + 0.6*Similarity(na.Album, nb.Album)
+	reasons := []string{ReasonName}
+
+	switch {
+	case intersects(a.CatalogueNumbers, b.CatalogueNumbers):
+		score = 0.5 + score/2
+		reasons = append(reasons, ReasonCatalogue)
+	case len(a.CatalogueNumbers) > 0 && len(b.CatalogueNumbers) > 0:
+		score *= 0.8
+	}
+	return MatchScore{Score: score, Reasons: reasons}
+}
+
+// FindMatches scores every pair of releases, returning the pairs scoring at
+// least threshold ordered from most to least likely.
+func FindMatches(releases []Release, threshold float64) []Candidate {
+	var cs []Candidate
+	for i := range releases {
+		for j := i + 1; j < len(releases); j++ {
+			a, b := releases[i], releases[j]
+			if a.Id > b.Id {
+				a, b = b, a
+			}
+			if s := ScoreMatch(a, b); s.Score >= threshold {
+				cs = append(cs, Candidate{A: a.Id, B: b.Id, MatchScore: s})
+			}
+		}
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].Score > cs[j].Score })
+	return cs
+}
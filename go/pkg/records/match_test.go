@@ -0,0 +1,115 @@
+package records
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormaliseBarcode(t *testing.T) {
+	tests := []struct{ in, expected string }{
+		{"0634904078126", "0634904078126"},
+		{"634904078126", "0634904078126"},
+		{"0 634904 078126", "0634904078126"},
+		{"0634904078127", ""},
+		{"12345", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormaliseBarcode(tt.in); got != tt.expected {
+			t.Errorf("NormaliseBarcode(%q) = %q, Expected: %q", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestNormaliseCatalogueNumber(t *testing.T) {
+	for _, in := range []string{"XLLP781", "xl-lp 781", " XL LP-781 "} {
+		if got := NormaliseCatalogueNumber(in); got != "XLLP781" {
+			t.Errorf("NormaliseCatalogueNumber(%q) = %q, Expected: XLLP781", in, got)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		min, max float64
+	}{
+		{"Not Waving, But Drowning", "NOT WAVING BUT DROWNING", 1, 1},
+		{"Not Waving But Drowning", "Not Waving But Drowing", 0.9, 1},
+		{"Tom Misch", "Misch Tom", 1, 1},
+		{"Bon Iver", "Bon Iver, Bon Iver", 0, 0.6},
+		{"What Kinda Music", "Lost & Found", 0, 0.3},
+	}
+
+	for _, tt := range tests {
+		if got := Similarity(tt.a, tt.b); got < tt.min || got > tt.max {
+			t.Errorf("Similarity(%q, %q) = %.3f, Expected: [%v, %v]", tt.a, tt.b, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestScoreMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     Release
+		match    bool
+		expected []string
+	}{
+		{
+			"typo",
+			Release{Artist: "Loyle Carner", Album: "Not Waving, But Drowning [VINYL]"},
+			Release{Artist: "Loyle Carner", Album: "Not Waving But Drowing"},
+			true, []string{ReasonName},
+		},
+		{
+			"different album",
+			Release{Artist: "Bon Iver", Album: "Bon Iver"},
+			Release{Artist: "Bon Iver", Album: "Bon Iver, Bon Iver"},
+			false, []string{ReasonName},
+		},
+		{
+			"barcode",
+			Release{Artist: "Arctic Monkeys", Album: "AM", Barcodes: []string{"0887828031719"}},
+			Release{Artist: "The Arctic Monkeys", Album: "A.M. (Limited Edition)", Barcodes: []string{"0887828031719"}},
+			true, []string{ReasonBarcode},
+		},
+		{
+			"different barcodes",
+			Release{Artist: "Arctic Monkeys", Album: "AM", Barcodes: []string{"0887828031719"}},
+			Release{Artist: "Arctic Monkeys", Album: "AM", Barcodes: []string{"0634904078126"}},
+			false, nil,
+		},
+		{
+			"catalogue number",
+			Release{Artist: "Tom Misch", Album: "Geography", CatalogueNumbers: []string{"BTR001LP"}},
+			Release{Artist: "Tom Misch & Friends", Album: "Geography (Bonus Tracks)", CatalogueNumbers: []string{"BTR001LP"}},
+			true, []string{ReasonName, ReasonCatalogue},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ScoreMatch(tt.a, tt.b)
+			if match := got.Score >= MatchThreshold; match != tt.match {
+				t.Errorf("ScoreMatch() = %.3f, Expected match: %t", got.Score, tt.match)
+			}
+			if !reflect.DeepEqual(got.Reasons, tt.expected) {
+				t.Errorf("ScoreMatch() reasons = %v, Expected: %v", got.Reasons, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFindMatches(t *testing.T) {
+	releases := []Release{
+		{Id: 3, Artist: "Loyle Carner", Album: "Not Waving But Drowing"},
+		{Id: 1, Artist: "Loyle Carner", Album: "Not Waving, But Drowning"},
+		{Id: 2, Artist: "Bon Iver", Album: "Bon Iver"},
+	}
+
+	got := FindMatches(releases, MatchThreshold)
+	if len(got) != 1 || got[0].A != 1 || got[0].B != 3 {
+		t.Errorf("FindMatches() = %+v, Expected a single match of 1 and 3", got)
+	}
+}
@@ -0,0 +1,140 @@
+package records
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalised is the canonical form of a scraped artist and album. Format and
+// edition suffixes (e.g. "[VINYL]", "(Deluxe Edition)") are split out of the
+// album title and featured artists out of the artist's identity so that
+// listings of the same release share an identity. The artist is displayed as
+// credited, only its key drops the featured artists.
+type Normalised struct {
+	Artist   string   // artist as credited, for display
+	Featured []string // artists credited with feat., ft. or featuring
+	Album    string   // album title for display without any suffixes
+	Edition  string   // e.g. "Deluxe Edition"
+	Format   string   // e.g. "VINYL"
+
+	ArtistKey string // identity key of the primary artist
+	AlbumKey  string // identity key of the album title
+}
+
+var (
+	// featuredSeparator splits an artist credit on its featured artists.
+	// Names are never split on "&", "," or "with" as they are part of many
+	// artists' names, e.g. "Earth, Wind & Fire".
+	featuredSeparator = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s+`)
+
+	// trailingSuffix matches a bracketed suffix at the end of an album title.
+	trailingSuffix = regexp.MustCompile(`\s*[\[(]([^\[\]()]*)[\])]\s*$`)
+
+	// formatSuffix and editionSuffix classify a bracketed album suffix.
+	formatSuffix = regexp.MustCompile(`(?i)\b(?:vinyl|lp|2lp|3lp|cd|cassette|180 ?g(?:ram)?|gatefold|colou?red|picture disc)\b|\b(?:7|10|12)(?:"|''|\s?inch)`)
+
+	editionSuffix = regexp.MustCompile(`(?i)\b(edition|deluxe|remaster(ed)?|anniversary|expanded|reissue|limited|special|bonus)\b`)
+
+	// featuredInAlbum matches a featured artist credit placed in the title.
+	featuredInAlbum = regexp.MustCompile(`(?i)^(feat\.?|ft\.?|featuring)\s+`)
+)
+
+// clean applies unicode NFC normalisation and collapses whitespace.
+func clean(s string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(s)), " ")
+}
+
+// IdentityKey reduces s to case folded, accent free letters and digits
+// separated by single spaces, so punctuation differences do not create
+// distinct identities.
+func IdentityKey(s string) string {
+	k := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, SortKey(s))
+	return strings.Join(strings.Fields(k), " ")
+}
+
+// splitNames splits a credit of several artists on their featured credits.
+func splitNames(s string) []string {
+	var names []string
+	for _, n := range featuredSeparator.Split(s, -1) {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// splitArtists splits a credited artist string into its primary artist and
+// any featured artists.
+func splitArtists(artist string) (string, []string) {
+	names := splitNames(artist)
+	if len(names) == 0 {
+		return artist, nil
+	}
+	return names[0], names[1:]
+}
+
+// splitSuffixes strips trailing bracketed format and edition suffixes from an
+// album title. Bracketed suffixes which are neither are left in the title.
+func splitSuffixes(album string) (title string, editions, formats, featured []string) {
+	title = album
+	for {
+		m := trailingSuffix.FindStringSubmatchIndex(title)
+		if m == nil {
+			break
+		}
+		inner := strings.TrimSpace(title[m[2]:m[3]])
+		switch {
+		case inner == "":
+		case featuredInAlbum.MatchString(inner):
+			featured = append(splitNames(featuredInAlbum.ReplaceAllString(inner, "")), featured...)
+		case formatSuffix.MatchString(inner):
+			formats = append([]string{inner}, formats...)
+		case editionSuffix.MatchString(inner):
+			editions = append([]string{inner}, editions...)
+		default:
+			return title, editions, formats, featured
+		}
+		title = strings.TrimSpace(title[:m[0]])
+	}
+	return title, editions, formats, featured
+}
+
+// Normalise converts a scraped artist and album into their canonical form.
+func Normalise(artist, album string) Normalised {
+	artist, album = clean(artist), clean(album)
+
+	primary, featured := splitArtists(artist)
+	title, editions, formats, albumFeatured := splitSuffixes(album)
+	if title == "" {
+		title = album
+	}
+
+	featured = append(featured, albumFeatured...)
+	if len(featured) == 0 {
+		featured = nil
+	}
+
+	n := Normalised{
+		Artist:   artist,
+		Featured: featured,
+		Album:    title,
+		Edition:  strings.Join(editions, ", "),
+		Format:   strings.Join(formats, ", "),
+	}
+	n.ArtistKey = IdentityKey(ArtistSortKey(primary))
+	n.AlbumKey = IdentityKey(title)
+	return n
+}
+
+// Normalise returns the canonical form of the record's artist and album.
+func (r *Record) Normalise() Normalised {
+	return Normalise(r.artist, r.album)
+}
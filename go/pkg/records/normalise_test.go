@@ -0,0 +1,93 @@
+package records
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalise(t *testing.T) {
+	tests := []struct {
+		artist, album string
+		expected      Normalised
+	}{
+		{
+			"Tom Misch", "What Kinda Music [VINYL]",
+			Normalised{Artist: "Tom Misch", Album: "What Kinda Music", Format: "VINYL",
+				ArtistKey: "tom misch", AlbumKey: "what kinda music"},
+		},
+		{
+			"TOM  MISCH", "WHAT KINDA MUSIC",
+			Normalised{Artist: "TOM MISCH", Album: "WHAT KINDA MUSIC",
+				ArtistKey: "tom misch", AlbumKey: "what kinda music"},
+		},
+		{
+			"Tom Misch ft. Yussef Dayes", "What Kinda Music (Deluxe Edition) [VINYL]",
+			Normalised{Artist: "Tom Misch ft. Yussef Dayes", Featured: []string{"Yussef Dayes"}, Album: "What Kinda Music",
+				Edition: "Deluxe Edition", Format: "VINYL", ArtistKey: "tom misch", AlbumKey: "what kinda music"},
+		},
+		{
+			"Earth, Wind & Fire", "I Am",
+			Normalised{Artist: "Earth, Wind & Fire", Album: "I Am", ArtistKey: "earth wind fire", AlbumKey: "i am"},
+		},
+		{
+			"Simon & Garfunkel", "Bookends",
+			Normalised{Artist: "Simon & Garfunkel", Album: "Bookends", ArtistKey: "simon garfunkel", AlbumKey: "bookends"},
+		},
+		{
+			"Loyle Carner", "Not Waving, But Drowning",
+			Normalised{Artist: "Loyle Carner", Album: "Not Waving, But Drowning",
+				ArtistKey: "loyle carner", AlbumKey: "not waving but drowning"},
+		},
+		{
+			"The Beatles", "Abbey Road (2019 Remastered) [180g] [Gatefold]",
+			Normalised{Artist: "The Beatles", Album: "Abbey Road", Edition: "2019 Remastered",
+				Format: "180g, Gatefold", ArtistKey: "beatles", AlbumKey: "abbey road"},
+		},
+		{
+			"Gorillaz feat. Tame Impala, Bootie Brown", "New Gold (Live at the Roundhouse)",
+			Normalised{Artist: "Gorillaz feat. Tame Impala, Bootie Brown", Featured: []string{"Tame Impala, Bootie Brown"},
+				Album: "New Gold (Live at the Roundhouse)", ArtistKey: "gorillaz", AlbumKey: "new gold live at the roundhouse"},
+		},
+		{
+			"Kendrick Lamar", "Alright (feat. Pharrell) [12\"]",
+			Normalised{Artist: "Kendrick Lamar", Featured: []string{"Pharrell"}, Album: "Alright", Format: "12\"",
+				ArtistKey: "kendrick lamar", AlbumKey: "alright"},
+		},
+		{
+			"Sigur Rós", "Ágætis byrjun",
+			Normalised{Artist: "Sigur Rós", Album: "Ágætis byrjun",
+				ArtistKey: "sigur ros", AlbumKey: "agætis byrjun"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.artist+" - "+tt.album, func(t *testing.T) {
+			got := Normalise(tt.artist, tt.album)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Normalise(%q, %q)\nExpected: %+v\nGot: %+v", tt.artist, tt.album, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNormaliseIdentity(t *testing.T) {
+	same := [][2]string{
+		{"Tom Misch", "What Kinda Music"},
+		{"TOM MISCH", "WHAT KINDA MUSIC"},
+		{"Tom Misch feat. Yussef Dayes", "What Kinda Music [VINYL]"},
+		{"tom misch", "What Kinda Music (Deluxe Edition)"},
+	}
+
+	first := Normalise(same[0][0], same[0][1])
+	for _, s := range same[1:] {
+		n := Normalise(s[0], s[1])
+		if n.ArtistKey != first.ArtistKey || n.AlbumKey != first.AlbumKey {
+			t.Errorf("Normalise(%q, %q) identity = (%q, %q), Expected: (%q, %q)",
+				s[0], s[1], n.ArtistKey, n.AlbumKey, first.ArtistKey, first.AlbumKey)
+		}
+	}
+
+	if n := Normalise("Tom Misch & Yussef Dayes", "What Kinda Music"); n.ArtistKey == first.ArtistKey {
+		t.Errorf("Normalise() of a duo = %q, Expected: an identity apart from %q", n.ArtistKey, first.ArtistKey)
+	}
+}
@@ -0,0 +1,167 @@
+package records
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// DefaultRetailer is the retailer of records scraped before retailers were
+// tracked.
+const DefaultRetailer = "amazon"
+
+// Conditions of an offer, used grades follow amazon's. An offer without a
+// condition is new.
+const (
+	ConditionNew            = "new"
+	ConditionUsedLikeNew    = "used_like_new"
+	ConditionUsedVeryGood   = "used_very_good"
+	ConditionUsedGood       = "used_good"
+	ConditionUsedAcceptable = "used_acceptable"
+	ConditionCollectible    = "collectible"
+)
+
+// ParseCondition converts a condition as listed by a retailer, e.g.
+// "Used - Very Good", to one of the Condition constants. An empty string is
+// returned if the condition is not recognised.
+func ParseCondition(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(s)), " "))
+	switch {
+	case s == "new" || strings.HasPrefix(s, "brand new"):
+		return ConditionNew
+	case strings.HasPrefix(s, "collectible"):
+		return ConditionCollectible
+	case strings.HasPrefix(s, "used"), strings.HasPrefix(s, "pre owned"):
+		for _, c := range []string{ConditionUsedLikeNew, ConditionUsedVeryGood, ConditionUsedGood, ConditionUsedAcceptable} {
+			if strings.HasSuffix(s, strings.ReplaceAll(strings.TrimPrefix(c, "used_"), "_", " ")) {
+				return c
+			}
+		}
+		return ConditionUsedGood
+	}
+	return ""
+}
+
+// Offer is the current price and availability of a record at a retailer.
+// Retailers with a marketplace list an offer for each seller, Fulfilled is
+// set if the retailer itself dispatches the offer. Offers without a seller are
+// sold by the retailer.
+type Offer struct {
+	Retailer  string  `json:"retailer"`
+	Url       string  `json:"url,omitempty"`
+	Price     float32 `json:"price"`
+	Shipping  float32 `json:"shipping"`
+	Available bool    `json:"available"`
+	Seller    string  `json:"seller,omitempty"`
+	Fulfilled bool    `json:"fulfilled,omitempty"`
+	Condition string  `json:"condition,omitempty"`
+	// Currency is the ISO 4217 code of Price, empty if the listing does not
+	// state it.
+	Currency string `json:"currency,omitempty"`
+}
+
+// IsNew reports whether the offer is for a new copy.
+func (o Offer) IsNew() bool {
+	return o.Condition == "" || o.Condition == ConditionNew
+}
+
+// Total is the landed price of the offer including shipping.
+func (o Offer) Total() float32 {
+	return o.Price + o.Shipping
+}
+
+// MarshalJSON includes the total of the offer alongside its fields.
+func (o Offer) MarshalJSON() ([]byte, error) {
+	type offer Offer
+	return json.Marshal(struct {
+		offer
+		Total float32 `json:"total"`
+	}{offer(o), o.Total()})
+}
+
+// Offers are the offers of a record across retailers.
+type Offers []Offer
+
+// Cheapest returns the available offer with the lowest total, or the
+// cheapest unavailable offer if none are available. nil is returned if there
+// are no offers, ties are won by the earlier offer.
+func (o Offers) Cheapest() *Offer {
+	var best *Offer
+	for i := range o {
+		c := &o[i]
+		switch {
+		case best == nil:
+			best = c
+		case c.Available != best.Available:
+			if c.Available {
+				best = c
+			}
+		case c.Total() < best.Total():
+			best = c
+		}
+	}
+	return best
+}
+
+// OfferType selects which of a retailer's offers is tracked as the price of a
+// record.
+type OfferType string
+
+const (
+	// OfferAny tracks the cheapest offer whatever its seller or condition.
+	OfferAny OfferType = "any"
+	// OfferNew tracks the cheapest new offer from any seller.
+	OfferNew OfferType = "new"
+	// OfferRetailer tracks the cheapest new offer fulfilled by the retailer.
+	OfferRetailer OfferType = "retailer"
+	// OfferUsed tracks the cheapest used offer.
+	OfferUsed OfferType = "used"
+)
+
+// DefaultOfferType is the offer type tracked by records which have not chosen
+// one.
+const DefaultOfferType = OfferNew
+
+// Valid reports whether t is one of the offer types.
+func (t OfferType) Valid() bool {
+	switch t {
+	case OfferAny, OfferNew, OfferRetailer, OfferUsed:
+		return true
+	}
+	return false
+}
+
+// Matches reports whether the offer is of type t.
+func (t OfferType) Matches(o Offer) bool {
+	switch t {
+	case OfferAny:
+		return true
+	case OfferRetailer:
+		return o.IsNew() && (o.Fulfilled || o.Seller == "")
+	case OfferUsed:
+		return !o.IsNew() && o.Condition != ConditionCollectible
+	}
+	return o.IsNew()
+}
+
+// Tracked returns the cheapest offer of type t from each retailer, in the
+// order each retailer first appears. Retailers with no offer of type t are
+// left out.
+func (o Offers) Tracked(t OfferType) Offers {
+	var tracked Offers
+	index := make(map[string]int)
+	for _, c := range o {
+		if !t.Matches(c) {
+			continue
+		}
+		i, ok := index[c.Retailer]
+		if !ok {
+			index[c.Retailer] = len(tracked)
+			tracked = append(tracked, c)
+			continue
+		}
+		if best := (Offers{tracked[i], c}).Cheapest(); *best == c {
+			tracked[i] = c
+		}
+	}
+	return tracked
+}
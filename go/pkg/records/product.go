@@ -0,0 +1,89 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidURL is returned for urls which are not a retailer's product page.
+var ErrInvalidURL = errors.New("invalid product url")
+
+// ProductID is the stable identifier a retailer gives a product, e.g. the
+// ASIN of an amazon listing.
+type ProductID struct {
+	Retailer string `json:"retailer"`
+	Id       string `json:"product_id"`
+}
+
+func (p ProductID) String() string {
+	return p.Retailer + ":" + p.Id
+}
+
+// asinPath matches the ASIN in the path of the various forms of amazon
+// product url.
+var asinPath = regexp.MustCompile(`(?i)/(?:dp|gp/product|gp/aw/d|exec/obidos/asin|o/asin)/([a-z0-9]{10})(?:[/?]|$)`)
+
+// trackingParams are query parameters added by retailers and referrers which
+// do not change the product a url refers to.
+var trackingParams = map[string]bool{
+	"ref": true, "ref_": true, "qid": true, "sr": true, "crid": true, "keywords": true,
+	"sprefix": true, "dchild": true, "_encoding": true, "psc": true, "th": true,
+	"tag": true, "gclid": true, "fbclid": true,
+}
+
+func isTrackingParam(k string) bool {
+	k = strings.ToLower(k)
+	return trackingParams[k] || strings.HasPrefix(k, "utm_") ||
+		strings.HasPrefix(k, "pd_rd_") || strings.HasPrefix(k, "pf_rd_")
+}
+
+// RetailerOf names the retailer of a host by its first label after any www,
+// e.g. "www.amazon.co.uk" is "amazon".
+func RetailerOf(host string) string {
+	host = strings.ToLower(host)
+	return strings.SplitN(strings.TrimPrefix(host, "www."), ".", 2)[0]
+}
+
+// ParseProductURL extracts the product identifier from a retailer's product
+// url and returns it along with the canonical form of the url, stripped of
+// tracking parameters. Amazon urls are identified by their ASIN and
+// canonicalised to /dp/<ASIN>, other retailers by their path and any
+// remaining query.
+func ParseProductURL(raw string) (ProductID, string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ProductID{}, "", fmt.Errorf("%w: %q", ErrInvalidURL, raw)
+	}
+	host := strings.ToLower(u.Hostname())
+	retailer := RetailerOf(host)
+
+	if retailer == "amazon" {
+		m := asinPath.FindStringSubmatch(u.EscapedPath())
+		if m == nil {
+			return ProductID{}, "", fmt.Errorf("%w: no ASIN in %q", ErrInvalidURL, raw)
+		}
+		asin := strings.ToUpper(m[1])
+		return ProductID{Retailer: retailer, Id: asin}, "https://" + host + "/dp/" + asin, nil
+	}
+
+	path := strings.TrimSuffix(u.EscapedPath(), "/")
+	if path == "" {
+		return ProductID{}, "", fmt.Errorf("%w: no product path in %q", ErrInvalidURL, raw)
+	}
+	q := u.Query()
+	for k := range q {
+		if isTrackingParam(k) {
+			q.Del(k)
+		}
+	}
+
+	id := path
+	if len(q) > 0 {
+		// Encode sorts by key so equivalent queries are identical.
+		id += "?" + q.Encode()
+	}
+	return ProductID{Retailer: retailer, Id: id}, "https://" + host + id, nil
+}
@@ -0,0 +1,48 @@
+package records
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseProductURL(t *testing.T) {
+	tests := []struct {
+		raw, retailer, id, canonical string
+	}{
+		{
+			"https://www.amazon.co.uk/AM-VINYL-Arctic-Monkeys/dp/B00DKY4NBA/ref=sr_1_4?crid=EIQTUGWC5AAR&keywords=vinyl&qid=1645263030&sprefix=vinyl%2Caps%2C83&sr=8-4",
+			"amazon", "B00DKY4NBA", "https://www.amazon.co.uk/dp/B00DKY4NBA",
+		},
+		{
+			"https://www.amazon.co.uk/dp/b00dky4nba?pd_rd_w=abc&pf_rd_p=def&pd_rd_r=ghi",
+			"amazon", "B00DKY4NBA", "https://www.amazon.co.uk/dp/B00DKY4NBA",
+		},
+		{
+			"http://WWW.AMAZON.CO.UK/gp/product/B08BWBQZ42/",
+			"amazon", "B08BWBQZ42", "https://www.amazon.co.uk/dp/B08BWBQZ42",
+		},
+		{
+			"https://www.roughtrade.com/gb/product/tom-misch/what-kinda-music/?utm_source=news&variant=lp#reviews",
+			"roughtrade", "/gb/product/tom-misch/what-kinda-music?variant=lp",
+			"https://www.roughtrade.com/gb/product/tom-misch/what-kinda-music?variant=lp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.canonical, func(t *testing.T) {
+			id, canonical, err := ParseProductURL(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseProductURL() returned an error: %s", err)
+			}
+			if id.Retailer != tt.retailer || id.Id != tt.id || canonical != tt.canonical {
+				t.Errorf("ParseProductURL(%q)\nExpected: %s:%s %s\nGot: %s %s", tt.raw, tt.retailer, tt.id, tt.canonical, id, canonical)
+			}
+		})
+	}
+
+	for _, raw := range []string{"", "not a url", "ftp://amazon.co.uk/dp/B00DKY4NBA", "https://www.amazon.co.uk/s?k=vinyl", "https://www.roughtrade.com/"} {
+		if _, _, err := ParseProductURL(raw); !errors.Is(err, ErrInvalidURL) {
+			t.Errorf("ParseProductURL(%q) = %v, Expected: %v", raw, err, ErrInvalidURL)
+		}
+	}
+}
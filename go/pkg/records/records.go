@@ -3,30 +3,63 @@ package records
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"sort"
+	"strconv"
 	"text/tabwriter"
 )
 
 type Record struct {
-	artist      string
-	album       string
-	amazonUrl   string
-	amazonPrice float32
+	artist string
+	album  string
+	offers Offers
+	// offerType selects which offers are tracked as the price of the record.
+	offerType OfferType
+
+	// identifiers of the release scraped from the listing, if any.
+	barcode         string
+	catalogueNumber string
+
+	// held are the reasons the tracked prices of the record are held for
+	// review when written, found while scraping it.
+	held []string
 }
 
+// RecordJSON is the json form of a Record. AmazonUrl and AmazonPrice predate
+// offers and are kept for existing clients, they summarise the cheapest offer
+// whichever retailer it is from by its headline price, excluding shipping.
 type RecordJSON struct {
 	Artist      string  `json:"artist"`
 	Album       string  `json:"album"`
 	AmazonUrl   string  `json:"amazon_url"`
 	AmazonPrice float32 `json:"amazon_price"`
+	Offers      Offers  `json:"offers"`
 }
 
 type PriceHist struct {
-	Date  string  `json:"date"`
-	Price float32 `json:"price"`
+	Date      string  `json:"date"`
+	Price     float32 `json:"price"`
+	Shipping  float32 `json:"shipping,omitempty"`
+	Retailer  string  `json:"retailer,omitempty"`
+	Seller    string  `json:"seller,omitempty"`
+	Condition string  `json:"condition,omitempty"`
+}
+
+// DailyPrice is the price of a record from a retailer on a date, derived
+// from every snapshot of the date: the first and last price scraped and the
+// lowest and highest between.
+type DailyPrice struct {
+	Date      string  `json:"date"`
+	Retailer  string  `json:"retailer"`
+	Open      float32 `json:"open"`
+	Close     float32 `json:"close"`
+	Low       float32 `json:"low"`
+	High      float32 `json:"high"`
+	Snapshots int     `json:"snapshots"`
 }
 
 type RecordPriceHistory struct {
@@ -34,15 +67,206 @@ type RecordPriceHistory struct {
 	Artist       string       `json:"artist"`
 	Album        string       `json:"album"`
 	AmazonUrl    string       `json:"amazon_url"`
+	OfferType    OfferType    `json:"offer_type"`
+	Offers       Offers       `json:"offers"`
+	SellerOffers Offers       `json:"seller_offers"`
+	Basis        PriceBasis   `json:"basis,omitempty"`
 	PriceHistory []*PriceHist `json:"price_history"`
 }
 
+// Landed returns a copy of the price history in which each price is the
+// landed price including shipping, so that its statistics and charts are of
+// the landed price.
+func (r *RecordPriceHistory) Landed() *RecordPriceHistory {
+	landed := *r
+	landed.Basis = BasisLanded
+	landed.PriceHistory = make([]*PriceHist, len(r.PriceHistory))
+	for i, p := range r.PriceHistory {
+		lp := *p
+		lp.Price += p.Shipping
+		landed.PriceHistory[i] = &lp
+	}
+	return &landed
+}
+
+// WriteTable writes the current offer of each retailer followed by the date,
+// price and retailer of each entry in the price history to w as tab written
+// tables.
+func (r *RecordPriceHistory) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "%s - %s\n\n", r.Artist, r.Album)
+
+	if len(r.Offers) > 0 {
+		const format = "%v\t%v\t%v\t%v\t%v\n"
+		tw := new(tabwriter.Writer).Init(w, 0, 8, 4, ' ', 0)
+		fmt.Fprintf(tw, format, "RETAILER", "PRICE", "SHIPPING", "TOTAL", "AVAILABLE")
+		fmt.Fprintf(tw, format, "--------", "-----", "--------", "-----", "---------")
+		for _, o := range r.Offers {
+			fmt.Fprintf(tw, format, o.Retailer, o.Price, o.Shipping, o.Total(), o.Available)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+
+	const format = "%v\t%v\t%v\n"
+	tw := new(tabwriter.Writer).Init(w, 0, 8, 4, ' ', 0)
+	fmt.Fprintf(tw, format, "DATE", "PRICE", "RETAILER")
+	fmt.Fprintf(tw, format, "----", "-----", "--------")
+	for _, p := range r.PriceHistory {
+		fmt.Fprintf(tw, format, p.Date, p.Price, p.Retailer)
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes the price history to w as csv with a header row.
+func (r *RecordPriceHistory) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "artist", "album", "date", "price", "retailer"})
+	for _, p := range r.PriceHistory {
+		cw.Write([]string{
+			strconv.Itoa(r.Id),
+			r.Artist,
+			r.Album,
+			p.Date,
+			strconv.FormatFloat(float64(p.Price), 'f', 2, 32),
+			p.Retailer,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Lowest returns the all-time low of the price history, nil if it is empty.
+func (r *RecordPriceHistory) Lowest() *PriceHist {
+	var low *PriceHist
+	for _, p := range r.PriceHistory {
+		if low == nil || p.Price < low.Price {
+			low = p
+		}
+	}
+	return low
+}
+
+// Highest returns the all-time high of the price history, nil if it is empty.
+func (r *RecordPriceHistory) Highest() *PriceHist {
+	var high *PriceHist
+	for _, p := range r.PriceHistory {
+		if high == nil || p.Price > high.Price {
+			high = p
+		}
+	}
+	return high
+}
+
+// Current returns the most recent price of the history, nil if it is empty.
+func (r *RecordPriceHistory) Current() *PriceHist {
+	if len(r.PriceHistory) == 0 {
+		return nil
+	}
+	return r.PriceHistory[len(r.PriceHistory)-1]
+}
+
+// CheapestByDate returns the lowest price of each date in the price history,
+// which is ordered by date, so retailers scraped in the same snapshot form a
+// single series.
+func (r *RecordPriceHistory) CheapestByDate() []*PriceHist {
+	var cheapest []*PriceHist
+	for _, p := range r.PriceHistory {
+		n := len(cheapest)
+		switch {
+		case n == 0 || cheapest[n-1].Date != p.Date:
+			cheapest = append(cheapest, p)
+		case p.Price < cheapest[n-1].Price:
+			cheapest[n-1] = p
+		}
+	}
+	return cheapest
+}
+
+// RecordSummary is the cheapest current offer of a record across retailers
+// alongside statistics over its full price history. The previous, lowest and
+// highest prices, and the choice of cheapest offer, are of the headline or
+// landed price depending on Basis.
+type RecordSummary struct {
+	Id            int        `json:"id"`
+	Artist        string     `json:"artist"`
+	Album         string     `json:"album"`
+	Date          string     `json:"date"`
+	Price         float32    `json:"price"`
+	PreviousPrice float32    `json:"previous_price"`
+	LowestPrice   float32    `json:"lowest_price"`
+	HighestPrice  float32    `json:"highest_price"`
+	Shipping      float32    `json:"shipping"`
+	Retailer      string     `json:"retailer"`
+	Available     bool       `json:"available"`
+	OfferCount    int        `json:"offer_count"`
+	Tags          []string   `json:"tags"`
+	TargetPrice   *float32   `json:"target_price"`
+	OfferType     OfferType  `json:"offer_type"`
+	Basis         PriceBasis `json:"basis"`
+}
+
+// Current is the current price in the basis of the summary.
+func (r *RecordSummary) Current() float32 {
+	if r.Basis == BasisLanded {
+		return r.Total()
+	}
+	return r.Price
+}
+
+// Change is the difference between the current and previous price.
+func (r *RecordSummary) Change() float32 {
+	return r.Current() - r.PreviousPrice
+}
+
+// IsAllTimeLow reports whether the current price is the lowest seen.
+func (r *RecordSummary) IsAllTimeLow() bool {
+	return r.Current() <= r.LowestPrice
+}
+
+// Discount is the fraction the current price is below the all-time high.
+func (r *RecordSummary) Discount() float32 {
+	if r.HighestPrice == 0 {
+		return 0
+	}
+	return (r.HighestPrice - r.Current()) / r.HighestPrice
+}
+
+// IsBelowTarget reports whether the current price is at or below the target
+// price set for the record.
+func (r *RecordSummary) IsBelowTarget() bool {
+	return r.TargetPrice != nil && r.Current() <= *r.TargetPrice
+}
+
+// Total is the current price including shipping.
+func (r *RecordSummary) Total() float32 {
+	return r.Price + r.Shipping
+}
+
+// ToRecord converts the summary to a Record with its cheapest offer, which is
+// already the tracked offer so is tracked whatever its type.
+func (r *RecordSummary) ToRecord() *Record {
+	return NewRecord(r.Artist, r.Album, "", 0).WithOffers(Offers{
+		{Retailer: r.Retailer, Price: r.Price, Shipping: r.Shipping, Available: r.Available},
+	}).WithOfferType(OfferAny)
+}
+
+// RecordSettings are the user editable fields of a record, nil fields are
+// left unchanged by an update.
+type RecordSettings struct {
+	Tags        []string   `json:"tags"`
+	TargetPrice *float32   `json:"target_price"`
+	OfferType   *OfferType `json:"offer_type"`
+}
+
+// NewRecord creates a record with a single offer from the DefaultRetailer,
+// which is unavailable if it has no price.
 func NewRecord(artist, album, url string, price float32) *Record {
 	return &Record{
-		artist:      artist,
-		album:       album,
-		amazonUrl:   url,
-		amazonPrice: price,
+		artist: artist,
+		album:  album,
+		offers: Offers{{Retailer: DefaultRetailer, Url: url, Price: price, Available: price > 0}},
 	}
 }
 
@@ -54,17 +278,119 @@ func (r *Record) GetAlbum() string {
 	return r.album
 }
 
+// GetPrice returns the headline price of the cheapest tracked offer of the
+// record, its price including shipping is listed in its offers.
 func (r *Record) GetPrice() float32 {
-	return r.amazonPrice
+	if o := r.GetTrackedOffers().Cheapest(); o != nil {
+		return o.Price
+	}
+	return 0
 }
 
-func (r *Record) MarshalJSON() ([]byte, error) {
-	return json.Marshal(RecordJSON{
+// GetUrl returns the url of the cheapest tracked offer of the record.
+func (r *Record) GetUrl() string {
+	if o := r.GetTrackedOffers().Cheapest(); o != nil {
+		return o.Url
+	}
+	return ""
+}
+
+// GetOffers returns every offer of the record, including those of each
+// marketplace seller.
+func (r *Record) GetOffers() Offers {
+	return r.offers
+}
+
+// GetTrackedOffers returns the offer of each retailer tracked as the price of
+// the record.
+func (r *Record) GetTrackedOffers() Offers {
+	return r.offers.Tracked(r.GetOfferType())
+}
+
+// GetOfferType returns the offer type tracked as the price of the record.
+func (r *Record) GetOfferType() OfferType {
+	if r.offerType == "" {
+		return DefaultOfferType
+	}
+	return r.offerType
+}
+
+// WithOfferType sets the offer type tracked as the price of the record.
+func (r *Record) WithOfferType(t OfferType) *Record {
+	r.offerType = t
+	return r
+}
+
+// ProductIDs returns the product ids of the record's offers which have a
+// product url.
+func (r *Record) ProductIDs() []ProductID {
+	var ids []ProductID
+	for _, o := range r.offers {
+		if id, _, err := ParseProductURL(o.Url); err == nil && id.Retailer == o.Retailer {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// WithOffers replaces the offers of the record.
+func (r *Record) WithOffers(offers Offers) *Record {
+	r.offers = offers
+	return r
+}
+
+func (r *Record) toJSON() *RecordJSON {
+	return &RecordJSON{
 		Artist:      r.artist,
 		Album:       r.album,
-		AmazonUrl:   r.amazonUrl,
-		AmazonPrice: r.amazonPrice,
-	})
+		AmazonUrl:   r.GetUrl(),
+		AmazonPrice: r.GetPrice(),
+		Offers:      r.offers,
+	}
+}
+
+// fromJSON sets the fields of r from rj, a single offer is created from the
+// summary fields of json written before offers existed.
+func (r *Record) fromJSON(rj *RecordJSON) {
+	r.artist = rj.Artist
+	r.album = rj.Album
+	r.offers = rj.Offers
+	if r.offers == nil {
+		r.offers = NewRecord(rj.Artist, rj.Album, rj.AmazonUrl, rj.AmazonPrice).offers
+	}
+}
+
+// WithIdentifiers sets the barcode and catalogue number scraped from the
+// record's listing, normalising both.
+func (r *Record) WithIdentifiers(barcode, catalogueNumber string) *Record {
+	r.barcode = NormaliseBarcode(barcode)
+	r.catalogueNumber = NormaliseCatalogueNumber(catalogueNumber)
+	return r
+}
+
+// Hold marks the tracked prices of the record to be held for review for
+// reasons when it is written, rather than recorded.
+func (r *Record) Hold(reasons ...string) *Record {
+	r.held = append(r.held, reasons...)
+	return r
+}
+
+// GetHeld returns the reasons the tracked prices of the record are held for
+// review, nil if they are not.
+func (r *Record) GetHeld() []string {
+	return r.held
+}
+
+func (r *Record) GetBarcode() string {
+	return r.barcode
+}
+
+func (r *Record) GetCatalogueNumber() string {
+	return r.catalogueNumber
+}
+
+func (r *Record) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.toJSON())
 }
 
 func (r *Record) UnmarshalJSON(b []byte) error {
@@ -72,10 +398,7 @@ func (r *Record) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &tmp); err != nil {
 		return err
 	}
-	r.artist = tmp.Artist
-	r.album = tmp.Album
-	r.amazonUrl = tmp.AmazonUrl
-	r.amazonPrice = tmp.AmazonPrice
+	r.fromJSON(tmp)
 	return nil
 }
 
@@ -84,12 +407,7 @@ type Records []*Record
 func (r Records) MarshalJSON() ([]byte, error) {
 	var rJson []*RecordJSON
 	for _, rr := range r {
-		rJson = append(rJson, &RecordJSON{
-			Artist:      rr.artist,
-			Album:       rr.album,
-			AmazonUrl:   rr.amazonUrl,
-			AmazonPrice: rr.amazonPrice,
-		})
+		rJson = append(rJson, rr.toJSON())
 	}
 
 	data, err := json.Marshal(rJson)
@@ -107,32 +425,54 @@ func (r *Records) UnmarshalJSON(b []byte) error {
 	}
 
 	for _, rr := range recordJsons {
-		*r = append(*r, NewRecord(
-			rr.Artist,
-			rr.Album,
-			rr.AmazonUrl,
-			rr.AmazonPrice,
-		))
+		rec := &Record{}
+		rec.fromJSON(rr)
+		*r = append(*r, rec)
 	}
 
 	return nil
 }
 
+// Print writes the records as a tab written table to stdout, the table is
+// also returned as a string.
 func (r Records) Print() string {
+	var b bytes.Buffer
+	r.WriteTable(&b)
+
+	fmt.Println(b.String())
+	return b.String()
+}
+
+// WriteTable writes the artist, album and price of each record to w as a tab
+// written table.
+func (r Records) WriteTable(w io.Writer) error {
 	const format = "%v\t%v\t%v\n"
 
-	var b bytes.Buffer
-	tw := new(tabwriter.Writer).Init(&b, 0, 8, 4, ' ', 0)
+	tw := new(tabwriter.Writer).Init(w, 0, 8, 4, ' ', 0)
 
 	fmt.Fprintf(tw, format, "ARTIST", "ALBUM", "CURRENT PRICE")
 	fmt.Fprintf(tw, format, "------", "-----", "-------------")
 	for _, rr := range r {
-		fmt.Fprintf(tw, format, rr.artist, rr.album, rr.amazonPrice)
+		fmt.Fprintf(tw, format, rr.artist, rr.album, rr.GetPrice())
 	}
-	tw.Flush()
+	return tw.Flush()
+}
 
-	fmt.Println(b.String())
-	return b.String()
+// WriteCSV writes the records to w as csv with a header row, columns match
+// the fields of RecordJSON.
+func (r Records) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"artist", "album", "amazon_url", "amazon_price"})
+	for _, rr := range r {
+		cw.Write([]string{
+			rr.artist,
+			rr.album,
+			rr.GetUrl(),
+			strconv.FormatFloat(float64(rr.GetPrice()), 'f', 2, 32),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
 }
 
 type RecordsSort struct {
@@ -144,10 +484,12 @@ func (r RecordsSort) Len() int           { return len(r.r) }
 func (r RecordsSort) Swap(i, j int)      { r.r[i], r.r[j] = r.r[j], r.r[i] }
 func (r RecordsSort) Less(i, j int) bool { return r.less(r.r[i], r.r[j]) }
 
-func ByArtist(i, j *Record) bool { return i.artist < j.artist }
-func ByAlbum(i, j *Record) bool  { return i.album < j.album }
-func ByPrice(i, j *Record) bool  { return i.amazonPrice < j.amazonPrice }
+func ByArtist(i, j *Record) bool { return CompareArtist(i, j) < 0 }
+func ByAlbum(i, j *Record) bool  { return CompareAlbum(i, j) < 0 }
+func ByPrice(i, j *Record) bool  { return ComparePrice(i, j) < 0 }
 
+// Sort stable sorts the records by a single less function, use SortBy to
+// sort on multiple keys.
 func (r Records) Sort(ByField func(*Record, *Record) bool) {
-	sort.Sort(RecordsSort{r, ByField})
+	sort.Stable(RecordsSort{r, ByField})
 }
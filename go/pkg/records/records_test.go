@@ -72,7 +72,8 @@ func TestRecordMarshalJSON(t *testing.T) {
 	}
 
 	t.Run("Record.MarshalJSON()", func(t *testing.T) {
-		expected := []byte(`{"artist":"Tom Misch","album":"What Kinda Music","amazon_url":"","amazon_price":30}`)
+		expected := []byte(`{"artist":"Tom Misch","album":"What Kinda Music","amazon_url":"","amazon_price":30,` +
+			`"offers":[{"retailer":"amazon","price":30,"shipping":0,"available":true,"total":30}]}`)
 		res := bytes.Compare(marshalled, expected)
 		if res != 0 {
 			t.Fatalf("Expected: %v\nGot: %v\n", expected, marshalled)
@@ -89,6 +90,92 @@ func TestRecordMarshalJSON(t *testing.T) {
 
 }
 
+func TestRecordUnmarshalJSONWithoutOffers(t *testing.T) {
+	unmarshalled := &Record{}
+	if err := unmarshalled.UnmarshalJSON([]byte(`{"artist":"Tom Misch","album":"What Kinda Music","amazon_url":"","amazon_price":30}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(WKM, unmarshalled) {
+		t.Fatalf("Expected: %v\nGot: %v\n", WKM, unmarshalled)
+	}
+}
+
+func TestRecordOffers(t *testing.T) {
+	rec := NewRecord("Tom Misch", "What Kinda Music", "", 0).WithOffers(Offers{
+		{Retailer: "amazon", Url: "https://amazon.co.uk/dp/1", Price: 19.99, Shipping: 4.49, Available: true},
+		{Retailer: "roughtrade", Url: "https://roughtrade.com/1", Price: 22, Available: true},
+		{Retailer: "hmv", Price: 15, Available: false},
+	})
+
+	if got := rec.GetPrice(); got != 22 {
+		t.Errorf("GetPrice() = %v, Expected: %v", got, 22)
+	}
+	if got := rec.GetUrl(); got != "https://roughtrade.com/1" {
+		t.Errorf("GetUrl() = %v, Expected: %v", got, "https://roughtrade.com/1")
+	}
+	if got := (Offers{{Retailer: "hmv", Price: 15}}).Cheapest(); got == nil || got.Retailer != "hmv" {
+		t.Errorf("Cheapest() of unavailable offers = %v, Expected: hmv", got)
+	}
+	if (Offers{}).Cheapest() != nil {
+		t.Errorf("Cheapest() of no offers, Expected: nil")
+	}
+}
+
+func TestRecordTrackedOffers(t *testing.T) {
+	offers := Offers{
+		{Retailer: "amazon", Price: 24.99, Available: true, Seller: "Amazon", Fulfilled: true, Condition: ConditionNew},
+		{Retailer: "amazon", Price: 19.5, Shipping: 2.99, Available: true, Seller: "Vinyl Vault", Condition: ConditionNew},
+		{Retailer: "amazon", Price: 12, Shipping: 2.99, Available: true, Seller: "Second Spin", Condition: ConditionUsedVeryGood},
+		{Retailer: "hmv", Price: 23, Available: true},
+	}
+
+	tests := []struct {
+		offerType OfferType
+		expected  float32
+		sellers   []string
+	}{
+		{"", 19.5, []string{"Vinyl Vault", ""}},
+		{OfferAny, 12, []string{"Second Spin", ""}},
+		{OfferRetailer, 23, []string{"Amazon", ""}},
+		{OfferUsed, 12, []string{"Second Spin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.offerType), func(t *testing.T) {
+			rec := NewRecord("Tom Misch", "Geography", "", 0).WithOffers(offers).WithOfferType(tt.offerType)
+			if got := rec.GetPrice(); got != tt.expected {
+				t.Errorf("GetPrice() = %v, Expected: %v", got, tt.expected)
+			}
+			var sellers []string
+			for _, o := range rec.GetTrackedOffers() {
+				sellers = append(sellers, o.Seller)
+			}
+			if !reflect.DeepEqual(sellers, tt.sellers) {
+				t.Errorf("GetTrackedOffers() sellers = %q, Expected: %q", sellers, tt.sellers)
+			}
+		})
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := map[string]string{
+		"New":                    ConditionNew,
+		"Used - Like New":        ConditionUsedLikeNew,
+		"Used - Very Good":       ConditionUsedVeryGood,
+		"  used -  good ":        ConditionUsedGood,
+		"Used - Acceptable":      ConditionUsedAcceptable,
+		"Used":                   ConditionUsedGood,
+		"Collectible - Like New": ConditionCollectible,
+		"Refurbished":            "",
+	}
+
+	for in, expected := range tests {
+		if got := ParseCondition(in); got != expected {
+			t.Errorf("ParseCondition(%q) = %v, Expected: %v", in, got, expected)
+		}
+	}
+}
+
 func TestRecordsMarshalJSON(t *testing.T) {
 	original := Records{
 		WKM,
@@ -101,7 +188,10 @@ func TestRecordsMarshalJSON(t *testing.T) {
 	}
 
 	t.Run("Records.MarshalJSON()", func(t *testing.T) {
-		expected := []byte(`[{"artist":"Tom Misch","album":"What Kinda Music","amazon_url":"","amazon_price":30},{"artist":"Jorja Smith","album":"Lost \u0026 Found","amazon_url":"","amazon_price":100}]`)
+		expected := []byte(`[{"artist":"Tom Misch","album":"What Kinda Music","amazon_url":"","amazon_price":30,` +
+			`"offers":[{"retailer":"amazon","price":30,"shipping":0,"available":true,"total":30}]},` +
+			`{"artist":"Jorja Smith","album":"Lost \u0026 Found","amazon_url":"","amazon_price":100,` +
+			`"offers":[{"retailer":"amazon","price":100,"shipping":0,"available":true,"total":100}]}]`)
 		res := bytes.Compare(marshalled, expected)
 		if res != 0 {
 			t.Fatalf("Expected: %v\nGot: %v\n", expected, marshalled)
@@ -118,3 +208,141 @@ func TestRecordsMarshalJSON(t *testing.T) {
 	})
 
 }
+
+func TestRecordPriceHistoryStats(t *testing.T) {
+	rph := &RecordPriceHistory{
+		PriceHistory: []*PriceHist{
+			{Date: "2022-04-14", Price: 25},
+			{Date: "2022-04-15", Price: 19.99},
+			{Date: "2022-04-16", Price: 30},
+			{Date: "2022-04-17", Price: 22.5},
+		},
+	}
+
+	if got := rph.Lowest(); got.Price != 19.99 {
+		t.Errorf("Lowest() = %v, Expected: %v", got.Price, 19.99)
+	}
+	if got := rph.Highest(); got.Price != 30 {
+		t.Errorf("Highest() = %v, Expected: %v", got.Price, 30)
+	}
+	if got := rph.Current(); got.Date != "2022-04-17" {
+		t.Errorf("Current() = %v, Expected: %v", got.Date, "2022-04-17")
+	}
+
+	empty := &RecordPriceHistory{}
+	if empty.Lowest() != nil || empty.Highest() != nil || empty.Current() != nil {
+		t.Errorf("expected nil stats for empty price history")
+	}
+}
+
+func TestRecordSummary(t *testing.T) {
+	rs := &RecordSummary{Price: 20, PreviousPrice: 22.5, LowestPrice: 20, HighestPrice: 30}
+	if rs.Change() != -2.5 {
+		t.Errorf("Change() = %v, Expected: %v", rs.Change(), -2.5)
+	}
+	if !rs.IsAllTimeLow() {
+		t.Errorf("IsAllTimeLow() = false, Expected: true")
+	}
+	if d := rs.Discount(); d < 0.333 || d > 0.334 {
+		t.Errorf("Discount() = %v, Expected: %v", d, float32(1)/3)
+	}
+	if rs.IsBelowTarget() {
+		t.Errorf("IsBelowTarget() = true with no target, Expected: false")
+	}
+	target := float32(20)
+	rs.TargetPrice = &target
+	if !rs.IsBelowTarget() {
+		t.Errorf("IsBelowTarget() = false, Expected: true")
+	}
+}
+
+func TestRecordSummaryLanded(t *testing.T) {
+	target := float32(22)
+	rs := &RecordSummary{Price: 19.99, Shipping: 4.49, PreviousPrice: 22, LowestPrice: 22, HighestPrice: 30,
+		TargetPrice: &target, Basis: BasisLanded}
+	if got := rs.Current(); got != 24.48 {
+		t.Errorf("Current() = %v, Expected: %v", got, 24.48)
+	}
+	if rs.IsAllTimeLow() || rs.IsBelowTarget() {
+		t.Errorf("IsAllTimeLow(), IsBelowTarget() = %v, %v, Expected: false, false", rs.IsAllTimeLow(), rs.IsBelowTarget())
+	}
+
+	rs.Basis = BasisHeadline
+	if !rs.IsAllTimeLow() || !rs.IsBelowTarget() {
+		t.Errorf("headline IsAllTimeLow(), IsBelowTarget() = %v, %v, Expected: true, true", rs.IsAllTimeLow(), rs.IsBelowTarget())
+	}
+}
+
+func TestRecordPriceHistoryLanded(t *testing.T) {
+	rph := &RecordPriceHistory{
+		PriceHistory: []*PriceHist{
+			{Date: "2022-04-14", Price: 19.99, Shipping: 4.49},
+			{Date: "2022-04-15", Price: 22},
+		},
+	}
+
+	landed := rph.Landed()
+	if got := landed.Lowest(); got.Date != "2022-04-15" {
+		t.Errorf("Landed().Lowest() = %v, Expected: %v", got.Date, "2022-04-15")
+	}
+	if landed.Basis != BasisLanded || landed.PriceHistory[0].Price != 24.48 {
+		t.Errorf("Landed() = %v, %v, Expected: landed, 24.48", landed.Basis, landed.PriceHistory[0].Price)
+	}
+	if rph.PriceHistory[0].Price != 19.99 {
+		t.Errorf("Landed() modified the original price history")
+	}
+}
+
+func TestRecordsWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := (Records{WKM, LF}).WriteCSV(&b); err != nil {
+		t.Fatalf("Records.WriteCSV() returned an error: %s", err)
+	}
+
+	expected := "artist,album,amazon_url,amazon_price\n" +
+		"Tom Misch,What Kinda Music,,30.00\n" +
+		"Jorja Smith,Lost & Found,,100.00\n"
+	if b.String() != expected {
+		t.Fatalf("Expected: %q\nGot: %q\n", expected, b.String())
+	}
+}
+
+func TestRecordPriceHistoryWriteCSV(t *testing.T) {
+	rph := &RecordPriceHistory{
+		Id:     1,
+		Artist: "Loyle Carner",
+		Album:  "Not Waving, But Drowning",
+		PriceHistory: []*PriceHist{
+			{Date: "2022-04-14", Price: 25, Retailer: "amazon"},
+			{Date: "2022-04-15", Price: 19.99, Retailer: "amazon"},
+		},
+	}
+
+	var b bytes.Buffer
+	if err := rph.WriteCSV(&b); err != nil {
+		t.Fatalf("RecordPriceHistory.WriteCSV() returned an error: %s", err)
+	}
+
+	expected := "id,artist,album,date,price,retailer\n" +
+		"1,Loyle Carner,\"Not Waving, But Drowning\",2022-04-14,25.00,amazon\n" +
+		"1,Loyle Carner,\"Not Waving, But Drowning\",2022-04-15,19.99,amazon\n"
+	if b.String() != expected {
+		t.Fatalf("Expected: %q\nGot: %q\n", expected, b.String())
+	}
+}
+
+func TestRecordPriceHistoryCheapestByDate(t *testing.T) {
+	rph := &RecordPriceHistory{
+		PriceHistory: []*PriceHist{
+			{Date: "2022-04-14", Price: 25, Retailer: "amazon"},
+			{Date: "2022-04-14", Price: 22, Retailer: "roughtrade"},
+			{Date: "2022-04-15", Price: 19.99, Retailer: "amazon"},
+			{Date: "2022-04-15", Price: 23, Retailer: "roughtrade"},
+		},
+	}
+
+	expected := []*PriceHist{rph.PriceHistory[1], rph.PriceHistory[2]}
+	if got := rph.CheapestByDate(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("CheapestByDate() = %v, Expected: %v", got, expected)
+	}
+}
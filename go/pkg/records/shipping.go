@@ -0,0 +1,82 @@
+package records
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ShippingRule is a retailer's delivery charge, a flat rate which is waived
+// on orders at or above a threshold.
+type ShippingRule struct {
+	Flat     float32 `json:"flat"`
+	FreeOver float32 `json:"free_over"` // 0 if delivery is never free
+}
+
+// Shipping returns the delivery charge on an order of price.
+func (r ShippingRule) Shipping(price float32) float32 {
+	if r.FreeOver > 0 && price >= r.FreeOver {
+		return 0
+	}
+	return r.Flat
+}
+
+// ShippingRules are the shipping rules of each retailer, keyed by retailer.
+type ShippingRules map[string]ShippingRule
+
+// LoadShippingRules reads shipping rules from a json file of the form
+// {"amazon": {"flat": 4.49, "free_over": 25}}. A missing file has no rules.
+func LoadShippingRules(filename string) (ShippingRules, error) {
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return ShippingRules{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoadShippingRules() reading %s failed: %w", filename, err)
+	}
+
+	var rules ShippingRules
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("LoadShippingRules() parsing %s failed: %w", filename, err)
+	}
+	for retailer, r := range rules {
+		if r.Flat < 0 || r.FreeOver < 0 {
+			return nil, fmt.Errorf("LoadShippingRules() rule of %s has a negative price", retailer)
+		}
+	}
+	return rules, nil
+}
+
+// Apply sets the shipping of an offer sold by the retailer from its rule,
+// returning the offer unchanged if the retailer has no rule. Marketplace
+// sellers set their own delivery charges so are left unchanged.
+func (r ShippingRules) Apply(o Offer) Offer {
+	rule, ok := r[o.Retailer]
+	if !ok || (o.Seller != "" && !o.Fulfilled) {
+		return o
+	}
+	o.Shipping = rule.Shipping(o.Price)
+	return o
+}
+
+// PriceBasis is the price records are compared on, either the headline price
+// or the landed price including shipping.
+type PriceBasis string
+
+const (
+	BasisHeadline PriceBasis = "headline"
+	BasisLanded   PriceBasis = "landed"
+)
+
+// ParsePriceBasis parses a price basis, an empty string is the headline
+// price.
+func ParsePriceBasis(s string) (PriceBasis, error) {
+	switch b := PriceBasis(s); b {
+	case "":
+		return BasisHeadline, nil
+	case BasisHeadline, BasisLanded:
+		return b, nil
+	}
+	return "", fmt.Errorf("basis must be headline or landed")
+}
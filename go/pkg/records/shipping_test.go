@@ -0,0 +1,64 @@
+package records
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestShippingRules(t *testing.T) {
+	rules := ShippingRules{
+		"amazon":     {Flat: 4.49, FreeOver: 25},
+		"roughtrade": {Flat: 3.5},
+	}
+
+	tests := []struct {
+		name     string
+		offer    Offer
+		expected float32
+	}{
+		{"below threshold", Offer{Retailer: "amazon", Price: 19.99}, 4.49},
+		{"at threshold", Offer{Retailer: "amazon", Price: 25}, 0},
+		{"never free", Offer{Retailer: "roughtrade", Price: 60}, 3.5},
+		{"fulfilled by retailer", Offer{Retailer: "amazon", Price: 19.99, Seller: "Vinyl Vault", Fulfilled: true}, 4.49},
+		{"marketplace seller", Offer{Retailer: "amazon", Price: 19.99, Shipping: 2.99, Seller: "Second Spin"}, 2.99},
+		{"no rule", Offer{Retailer: "hmv", Price: 19.99, Shipping: 1}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Apply(tt.offer).Shipping; got != tt.expected {
+				t.Errorf("Apply().Shipping = %v, Expected: %v", got, tt.expected)
+			}
+		})
+	}
+
+	// £19.99 with £4.49 delivery is dearer than £22.00 delivered free.
+	a := rules.Apply(Offer{Retailer: "amazon", Price: 19.99, Available: true})
+	b := Offer{Retailer: "hmv", Price: 22, Available: true}
+	if got := (Offers{a, b}).Cheapest(); got.Retailer != "hmv" {
+		t.Errorf("Cheapest() = %v, Expected: hmv", got.Retailer)
+	}
+}
+
+func TestLoadShippingRules(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "shipping.json")
+	os.WriteFile(filename, []byte(`{"amazon": {"flat": 4.49, "free_over": 25}}`), 0o644)
+
+	rules, err := LoadShippingRules(filename)
+	expected := ShippingRules{"amazon": {Flat: 4.49, FreeOver: 25}}
+	if err != nil || !reflect.DeepEqual(rules, expected) {
+		t.Errorf("LoadShippingRules() = %v, %v, Expected: %v", rules, err, expected)
+	}
+
+	if rules, err := LoadShippingRules(filepath.Join(dir, "missing.json")); err != nil || len(rules) != 0 {
+		t.Errorf("LoadShippingRules() of a missing file = %v, %v, Expected no rules", rules, err)
+	}
+
+	os.WriteFile(filename, []byte(`{"amazon": {"flat": -1}}`), 0o644)
+	if _, err := LoadShippingRules(filename); err == nil {
+		t.Errorf("LoadShippingRules() of a negative rate, Expected an error")
+	}
+}
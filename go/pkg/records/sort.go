@@ -0,0 +1,175 @@
+package records
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// collator folds case and strips accents so that "Björk", "BJORK" and "bjork"
+// collate together.
+var collator = cases.Fold()
+
+// SortKey returns the collation key of s: case folded with accents removed
+// and surrounding whitespace trimmed.
+func SortKey(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(t, s)
+	if err != nil {
+		stripped = s
+	}
+	return strings.TrimSpace(collator.String(stripped))
+}
+
+// ArtistSortKey returns the collation key of an artist name, a leading "The "
+// is ignored so that "The Beatles" sorts under B.
+func ArtistSortKey(s string) string {
+	k := SortKey(s)
+	if t := strings.TrimPrefix(k, "the "); t != "" {
+		return t
+	}
+	return k
+}
+
+// Comparator orders two records, returning a negative number if a sorts
+// before b, a positive number if a sorts after b and 0 if they are equal.
+type Comparator func(a, b *Record) int
+
+func compareStrings(a, b string) int { return strings.Compare(a, b) }
+
+func CompareArtist(a, b *Record) int {
+	return compareStrings(ArtistSortKey(a.artist), ArtistSortKey(b.artist))
+}
+
+func CompareAlbum(a, b *Record) int {
+	return compareStrings(SortKey(a.album), SortKey(b.album))
+}
+
+func ComparePrice(a, b *Record) int {
+	switch {
+	case a.GetPrice() < b.GetPrice():
+		return -1
+	case a.GetPrice() > b.GetPrice():
+		return 1
+	}
+	return 0
+}
+
+// Reverse returns a comparator sorting in the opposite order to c.
+func (c Comparator) Reverse() Comparator {
+	return func(a, b *Record) int { return c(b, a) }
+}
+
+// Then returns a comparator which orders by c, breaking ties with next.
+func (c Comparator) Then(next Comparator) Comparator {
+	return func(a, b *Record) int {
+		if n := c(a, b); n != 0 {
+			return n
+		}
+		return next(a, b)
+	}
+}
+
+// SortBy stable sorts the records by each comparator in turn, so records
+// equal under the first are ordered by the second and so on. Records equal
+// under all comparators keep their existing order.
+func (r Records) SortBy(cmps ...Comparator) {
+	if len(cmps) == 0 {
+		return
+	}
+	cmp := cmps[0]
+	for _, next := range cmps[1:] {
+		cmp = cmp.Then(next)
+	}
+	sort.SliceStable(r, func(i, j int) bool { return cmp(r[i], r[j]) < 0 })
+}
+
+// SortField is a single key of a SortOrder.
+type SortField struct {
+	Name string
+	Desc bool
+}
+
+// SortOrder is an ordered list of fields to sort by, e.g. artist then album
+// then price descending.
+type SortOrder []SortField
+
+// DefaultSortOrder sorts by artist, then album, then price.
+var DefaultSortOrder = SortOrder{{Name: "artist"}, {Name: "album"}, {Name: "price"}}
+
+// comparators are the fields of a Record which a SortOrder can refer to.
+var comparators = map[string]Comparator{
+	"artist": CompareArtist,
+	"album":  CompareAlbum,
+	"price":  ComparePrice,
+}
+
+// ParseSortOrder parses a comma separated list of field names, each
+// optionally prefixed with '-' to sort descending, e.g. "artist,-price".
+// Field names are not validated as different consumers support different
+// fields.
+func ParseSortOrder(s string) (SortOrder, error) {
+	var o SortOrder
+	if strings.TrimSpace(s) == "" {
+		return o, nil
+	}
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		sf := SortField{Name: strings.TrimPrefix(f, "-"), Desc: strings.HasPrefix(f, "-")}
+		if sf.Name == "" {
+			return nil, fmt.Errorf("empty sort field in %q", s)
+		}
+		if seen[sf.Name] {
+			return nil, fmt.Errorf("sort field %q repeated", sf.Name)
+		}
+		seen[sf.Name] = true
+		o = append(o, sf)
+	}
+	return o, nil
+}
+
+// String formats the order in the form read by ParseSortOrder.
+func (o SortOrder) String() string {
+	var fs []string
+	for _, f := range o {
+		if f.Desc {
+			fs = append(fs, "-"+f.Name)
+		} else {
+			fs = append(fs, f.Name)
+		}
+	}
+	return strings.Join(fs, ",")
+}
+
+// Reverse returns the order with the direction of every field flipped.
+func (o SortOrder) Reverse() SortOrder {
+	r := make(SortOrder, len(o))
+	for i, f := range o {
+		r[i] = SortField{Name: f.Name, Desc: !f.Desc}
+	}
+	return r
+}
+
+// Comparators converts the order into record comparators, an error is
+// returned for fields Records cannot be sorted by.
+func (o SortOrder) Comparators() ([]Comparator, error) {
+	var cmps []Comparator
+	for _, f := range o {
+		c, ok := comparators[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("records cannot be sorted by %q", f.Name)
+		}
+		if f.Desc {
+			c = c.Reverse()
+		}
+		cmps = append(cmps, c)
+	}
+	return cmps, nil
+}
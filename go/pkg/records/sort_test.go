@@ -0,0 +1,125 @@
+package records
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortKey(t *testing.T) {
+	tests := []struct{ in, key, artistKey string }{
+		{"Björk", "bjork", "bjork"},
+		{"BJORK", "bjork", "bjork"},
+		{" Sigur Rós ", "sigur ros", "sigur ros"},
+		{"The Beatles", "the beatles", "beatles"},
+		{"THE WEEKND", "the weeknd", "weeknd"},
+		{"Theo Parrish", "theo parrish", "theo parrish"},
+		{"The", "the", "the"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := SortKey(tt.in); got != tt.key {
+				t.Errorf("SortKey(%q) = %q, Expected: %q", tt.in, got, tt.key)
+			}
+			if got := ArtistSortKey(tt.in); got != tt.artistKey {
+				t.Errorf("ArtistSortKey(%q) = %q, Expected: %q", tt.in, got, tt.artistKey)
+			}
+		})
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	geography := NewRecord("Tom Misch", "Geography", "", 20)
+	beatTape := NewRecord("TOM MISCH", "Beat Tape 2", "", 20)
+	wkmCheap := NewRecord("Tom Misch", "What Kinda Music", "", 25)
+	abbeyRoad := NewRecord("The Beatles", "Abbey Road", "", 30)
+	vespertine := NewRecord("Björk", "Vespertine", "", 40)
+
+	tests := []struct {
+		name     string
+		cmps     []Comparator
+		in       Records
+		expected Records
+	}{
+		{
+			"artist ignores case, accents and the prefix",
+			[]Comparator{CompareArtist},
+			Records{geography, abbeyRoad, vespertine},
+			Records{abbeyRoad, vespertine, geography},
+		},
+		{
+			"artist then album",
+			[]Comparator{CompareArtist, CompareAlbum},
+			Records{wkmCheap, geography, beatTape, abbeyRoad},
+			Records{abbeyRoad, beatTape, geography, wkmCheap},
+		},
+		{
+			"price descending then album",
+			[]Comparator{Comparator(ComparePrice).Reverse(), CompareAlbum},
+			Records{geography, vespertine, beatTape, wkmCheap},
+			Records{vespertine, wkmCheap, beatTape, geography},
+		},
+		{
+			"ties keep existing order",
+			[]Comparator{ComparePrice},
+			Records{geography, beatTape, wkmCheap},
+			Records{geography, beatTape, wkmCheap},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := append(Records(nil), tt.in...)
+			sorted.SortBy(tt.cmps...)
+			if !reflect.DeepEqual(sorted, tt.expected) {
+				t.Fatalf("Expected: %v\nGot: %v", tt.expected, sorted)
+			}
+		})
+	}
+}
+
+func TestParseSortOrder(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected SortOrder
+		wantErr  bool
+	}{
+		{"", nil, false},
+		{"artist", SortOrder{{Name: "artist"}}, false},
+		{"artist, album,-price", SortOrder{{Name: "artist"}, {Name: "album"}, {Name: "price", Desc: true}}, false},
+		{"artist,,price", nil, true},
+		{"price,-price", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSortOrder(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSortOrder(%q) err = %v, wantErr %t", tt.in, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseSortOrder(%q) = %v, Expected: %v", tt.in, got, tt.expected)
+			}
+		})
+	}
+
+	if s := (SortOrder{{Name: "artist"}, {Name: "price", Desc: true}}).Reverse().String(); s != "-artist,price" {
+		t.Errorf("SortOrder.Reverse().String() = %q, Expected: %q", s, "-artist,price")
+	}
+}
+
+func TestSortOrderComparators(t *testing.T) {
+	if _, err := (SortOrder{{Name: "discount"}}).Comparators(); err == nil {
+		t.Errorf("expected error for field records cannot be sorted by")
+	}
+
+	recs := Records{WKM, LF, NWBD}
+	cmps, err := (SortOrder{{Name: "price", Desc: true}}).Comparators()
+	if err != nil {
+		t.Fatalf("Comparators() returned an error: %s", err)
+	}
+	recs.SortBy(cmps...)
+	if !reflect.DeepEqual(recs, Records{LF, WKM, NWBD}) {
+		t.Errorf("Expected: %v\nGot: %v", Records{LF, WKM, NWBD}, recs)
+	}
+}
@@ -0,0 +1,146 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// chart dimensions in svg user units.
+const (
+	chartWidth   = 640
+	chartHeight  = 260
+	chartPadding = 48
+	chartYTicks  = 5
+)
+
+// chartPoint is a single price plotted on a chart.
+type chartPoint struct {
+	X, Y  float64
+	Date  string
+	Price float32
+	IsLow bool
+}
+
+// chartTick is an axis label positioned on a chart.
+type chartTick struct {
+	X, Y  float64
+	Label string
+}
+
+// priceChart holds the geometry of a server side rendered svg line chart of a
+// record's price history.
+type priceChart struct {
+	Width, Height int
+	Left, Right   float64
+	Top, Bottom   float64
+	Line          string
+	Points        []chartPoint
+	YTicks        []chartTick
+	XTicks        []chartTick
+}
+
+// parseDate reads a price history date which is either a plain date or a
+// full timestamp.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// pointLabel is the date of a price, with the time of its snapshot if it was
+// not taken at midnight.
+func pointLabel(d time.Time) string {
+	if d.Hour() == 0 && d.Minute() == 0 {
+		return d.Format("2006-01-02")
+	}
+	return d.Format("2006-01-02 15:04")
+}
+
+// newPriceChart scales the price history onto the chart area, the x axis is
+// time and the y axis price. nil is returned for an empty history.
+func newPriceChart(hist []*records.PriceHist) *priceChart {
+	if len(hist) == 0 {
+		return nil
+	}
+
+	c := &priceChart{
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   chartPadding,
+		Right:  chartWidth - chartPadding/2,
+		Top:    chartPadding / 2,
+		Bottom: chartHeight - chartPadding,
+	}
+
+	dates := make([]time.Time, len(hist))
+	minP, maxP := float64(hist[0].Price), float64(hist[0].Price)
+	for i, p := range hist {
+		d, err := parseDate(p.Date)
+		if err != nil {
+			// fall back to evenly spacing unparseable dates
+			d = time.Unix(int64(i)*86400, 0)
+		}
+		dates[i] = d
+		minP = math.Min(minP, float64(p.Price))
+		maxP = math.Max(maxP, float64(p.Price))
+	}
+
+	// pad the price range so flat histories and extremes are not drawn on the
+	// edge of the chart.
+	pad := (maxP - minP) * 0.1
+	if pad == 0 {
+		pad = 1
+	}
+	lo, hi := math.Max(0, minP-pad), maxP+pad
+	first, last := dates[0], dates[0]
+	for _, d := range dates {
+		if d.Before(first) {
+			first = d
+		}
+		if d.After(last) {
+			last = d
+		}
+	}
+	span := last.Sub(first).Seconds()
+
+	x := func(d time.Time) float64 {
+		if span == 0 {
+			return (c.Left + c.Right) / 2
+		}
+		return c.Left + d.Sub(first).Seconds()/span*(c.Right-c.Left)
+	}
+	y := func(p float64) float64 {
+		return c.Bottom - (p-lo)/(hi-lo)*(c.Bottom-c.Top)
+	}
+
+	var line []string
+	for i, p := range hist {
+		pt := chartPoint{
+			X:     x(dates[i]),
+			Y:     y(float64(p.Price)),
+			Date:  pointLabel(dates[i]),
+			Price: p.Price,
+			IsLow: float64(p.Price) == minP,
+		}
+		c.Points = append(c.Points, pt)
+		line = append(line, fmt.Sprintf("%.1f,%.1f", pt.X, pt.Y))
+	}
+	c.Line = strings.Join(line, " ")
+
+	for i := 0; i < chartYTicks; i++ {
+		p := lo + (hi-lo)*float64(i)/float64(chartYTicks-1)
+		c.YTicks = append(c.YTicks, chartTick{X: c.Left, Y: y(p), Label: fmt.Sprintf("£%.2f", p)})
+	}
+
+	c.XTicks = append(c.XTicks, chartTick{X: x(first), Y: c.Bottom, Label: first.Format("2 Jan 06")})
+	if span > 0 {
+		c.XTicks = append(c.XTicks, chartTick{X: x(last), Y: c.Bottom, Label: last.Format("2 Jan 06")})
+	}
+	return c
+}
@@ -0,0 +1,196 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/gorilla/mux"
+)
+
+//go:embed templates static
+var assets embed.FS
+
+var templates = template.Must(
+	template.New("").Funcs(template.FuncMap{
+		"price":   formatPrice,
+		"change":  formatChange,
+		"percent": formatPercent,
+	}).ParseFS(assets, "templates/*.html"),
+)
+
+// staticFiles serves the embedded css under /static/.
+func staticFiles() http.Handler {
+	return http.FileServer(http.FS(assets))
+}
+
+func formatPrice(p float32) string {
+	return fmt.Sprintf("£%.2f", p)
+}
+
+func formatPercent(f float32) string {
+	return fmt.Sprintf("%.0f%%", f*100)
+}
+
+func formatChange(c float32) string {
+	switch {
+	case c > 0:
+		return fmt.Sprintf("+£%.2f", c)
+	case c < 0:
+		return fmt.Sprintf("-£%.2f", -c)
+	}
+	return "-"
+}
+
+// column is a sortable header of the dashboard list view.
+type column struct {
+	Title     string
+	SortURL   string
+	Indicator string
+}
+
+type dashboardPage struct {
+	Columns []column
+	Records []*records.RecordSummary
+	Total   int
+	NextURL string
+}
+
+type recordPage struct {
+	Record  *records.RecordPriceHistory
+	Current *records.PriceHist
+	Lowest  *records.PriceHist
+	Highest *records.PriceHist
+	Chart   *priceChart
+}
+
+// columns builds the list view headers from the current query, clicking the
+// column the list is sorted by reverses its order. Filters are kept but the
+// page is reset.
+func columns(current url.Values) []column {
+	var sorted records.SortField
+	if o, err := records.ParseSortOrder(current.Get("sort")); err == nil && len(o) > 0 {
+		sorted = o[0]
+		if current.Get("order") == "desc" {
+			sorted.Desc = !sorted.Desc
+		}
+	}
+
+	var cols []column
+	for _, c := range []struct{ field, title string }{
+		{"artist", "Artist"},
+		{"album", "Album"},
+		{"price", "Price"},
+		{"change", "Change"},
+		{"discount", "Off High"},
+		{"low", "All-Time Low"},
+	} {
+		q := url.Values{}
+		for k, v := range current {
+			q[k] = v
+		}
+		q.Del("cursor")
+		q.Del("order")
+
+		// secondary keys keep the order stable for equal values.
+		order := records.SortOrder{{Name: c.field}}
+		col := column{Title: c.title}
+		if c.field == sorted.Name {
+			col.Indicator = "▲"
+			if sorted.Desc {
+				col.Indicator = "▼"
+			} else {
+				order[0].Desc = true
+			}
+		}
+		for _, f := range records.DefaultSortOrder {
+			if f.Name != c.field {
+				order = append(order, f)
+			}
+		}
+		q.Set("sort", order.String())
+		col.SortURL = "?" + q.Encode()
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+// render executes the named template into a buffer so that template errors
+// can still be reported with a 500.
+func render(w http.ResponseWriter, r *http.Request, name string, status int, data any) {
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, name, data); err != nil {
+		logging.FromContext(r.Context()).Error("rendering template failed", "template", name, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+}
+
+// Dashboard renders a html table of records with their current price, most
+// recent change and all-time low. The table is filtered, sorted and paginated
+// by the same query parameters as GetRecords.
+func (s *Server) Dashboard(w http.ResponseWriter, r *http.Request) {
+	page, ok := s.queryRecords(w, r)
+	if !ok {
+		return
+	}
+
+	data := dashboardPage{
+		Columns: columns(r.URL.Query()),
+		Records: page.Records,
+		Total:   page.Total,
+	}
+	if page.NextCursor != "" {
+		q := r.URL.Query()
+		q.Set("cursor", page.NextCursor)
+		data.NextURL = "?" + q.Encode()
+	}
+	render(w, r, "records.html", http.StatusOK, data)
+}
+
+// DashboardRecord renders a html page of a single record's price statistics
+// and a chart of its full price history, of the landed price if
+// ?basis=landed.
+func (s *Server) DashboardRecord(w http.ResponseWriter, r *http.Request) {
+	rId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		render(w, r, "notfound.html", http.StatusNotFound, nil)
+		return
+	}
+	basis, err := records.ParsePriceBasis(r.URL.Query().Get("basis"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rph := s.pg.GetRecordPriceHistory(rId)
+	if rph == nil {
+		render(w, r, "notfound.html", http.StatusNotFound, nil)
+		return
+	}
+	if basis == records.BasisLanded {
+		rph = rph.Landed()
+	}
+	renderRecord(w, r, rph)
+}
+
+// renderRecord renders the html page of a record's price history.
+func renderRecord(w http.ResponseWriter, r *http.Request, rph *records.RecordPriceHistory) {
+	render(w, r, "record.html", http.StatusOK, recordPage{
+		Record:  rph,
+		Current: rph.Current(),
+		Lowest:  rph.Lowest(),
+		Highest: rph.Highest(),
+		Chart:   newPriceChart(rph.CheapestByDate()),
+	})
+}
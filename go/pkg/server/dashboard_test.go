@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+var summaries = []*records.RecordSummary{
+	{Id: 1, Artist: "Tom Misch", Album: "What Kinda Music", Price: 25, PreviousPrice: 30, LowestPrice: 25, HighestPrice: 30},
+	{Id: 2, Artist: "Jorja Smith", Album: "Lost & Found", Price: 20, PreviousPrice: 18, LowestPrice: 18, HighestPrice: 22},
+	{Id: 3, Artist: "Loyle Carner", Album: "Not Waving, But Drowning", Price: 22, PreviousPrice: 22, LowestPrice: 19, HighestPrice: 22},
+}
+
+func TestRenderRecordsTemplate(t *testing.T) {
+	var b bytes.Buffer
+	err := templates.ExecuteTemplate(&b, "records.html", dashboardPage{
+		Columns: columns(url.Values{"sort": {"price"}, "tag": {"soul"}, "cursor": {"abc"}}),
+		Records: summaries,
+		Total:   10,
+		NextURL: "?cursor=next",
+	})
+	if err != nil {
+		t.Fatalf("rendering records.html failed: %s", err)
+	}
+
+	for _, want := range []string{
+		`href="/dashboard/2"`,
+		"Lost &amp; Found",
+		"£25.00",
+		"-£5.00",
+		"&#43;£2.00",
+		"All-time low",
+		`href="?sort=-price%2Cartist%2Calbum&amp;tag=soul"`,
+		`href="?sort=artist%2Calbum%2Cprice&amp;tag=soul"`,
+		"Showing 3 of 10 records.",
+		`href="?cursor=next"`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("rendered records.html does not contain %q", want)
+		}
+	}
+}
+
+func TestRenderRecordTemplate(t *testing.T) {
+	rph := &records.RecordPriceHistory{
+		Id:     1,
+		Artist: "Tom Misch",
+		Album:  "What Kinda Music",
+		PriceHistory: []*records.PriceHist{
+			{Date: "2022-04-14T00:00:00Z", Price: 30},
+			{Date: "2022-04-15T00:00:00Z", Price: 25},
+		},
+		Offers: records.Offers{
+			{Retailer: "amazon", Price: 25, Available: true},
+			{Retailer: "roughtrade", Price: 24, Shipping: 3.5, Available: true},
+		},
+		OfferType: records.OfferNew,
+		SellerOffers: records.Offers{
+			{Retailer: "amazon", Price: 25, Available: true, Seller: "Amazon", Fulfilled: true, Condition: records.ConditionNew},
+			{Retailer: "amazon", Price: 18, Shipping: 2.99, Available: true, Seller: "Second Spin", Condition: records.ConditionUsedGood},
+		},
+	}
+
+	var b bytes.Buffer
+	err := templates.ExecuteTemplate(&b, "record.html", recordPage{
+		Record:  rph,
+		Current: rph.Current(),
+		Lowest:  rph.Lowest(),
+		Highest: rph.Highest(),
+		Chart:   newPriceChart(rph.PriceHistory),
+	})
+	if err != nil {
+		t.Fatalf("rendering record.html failed: %s", err)
+	}
+
+	for _, want := range []string{"<svg", "<polyline", "2022-04-15: £25.00", "All-time low", "£30.00",
+		"Current offers", "roughtrade", "£3.50", "£27.50", "Tracking the cheapest new offer",
+		"All sellers", "Second Spin", "used_good", "Fulfilled by amazon"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("rendered record.html does not contain %q", want)
+		}
+	}
+}
+
+func TestNewPriceChart(t *testing.T) {
+	if newPriceChart(nil) != nil {
+		t.Errorf("expected nil chart for empty history")
+	}
+
+	c := newPriceChart([]*records.PriceHist{
+		{Date: "2022-04-14", Price: 30},
+		{Date: "2022-04-16", Price: 20},
+		{Date: "2022-04-15", Price: 25},
+	})
+
+	if len(c.Points) != 3 {
+		t.Fatalf("expected 3 points, got %d", len(c.Points))
+	}
+	first, last := c.Points[0], c.Points[1] // points are in history order
+	if first.X != c.Left || last.X != c.Right {
+		t.Errorf("expected history to span chart, got x = %v..%v", first.X, last.X)
+	}
+	if !(last.Y > first.Y) || !last.IsLow {
+		t.Errorf("expected lowest price to be plotted lowest and flagged, got %+v", last)
+	}
+	for _, p := range c.Points {
+		if p.Y < c.Top || p.Y > c.Bottom {
+			t.Errorf("point %+v plotted outside of chart", p)
+		}
+	}
+}
+
+func TestStaticFiles(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/static/css/records.css", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), ".page-header") {
+		t.Errorf("GET /static/css/records.css = %d, expected embedded stylesheet", rr.Code)
+	}
+}
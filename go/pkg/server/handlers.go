@@ -2,73 +2,146 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/1602077/webscraper/go/pkg/logging"
 	"github.com/1602077/webscraper/go/pkg/postgres"
 	"github.com/1602077/webscraper/go/pkg/records"
 	"github.com/1602077/webscraper/go/pkg/webscraper"
 	"github.com/gorilla/mux"
 )
 
-var ENV_FILEPATH string
+// listFormats are the formats the list and history endpoints can respond
+// with, in order of preference.
+var listFormats = []string{formatJSON, formatHTML, formatCSV, formatText}
 
-func init() {
-	flag.StringVar(&ENV_FILEPATH, "env", "../../.env", "sets environment config (.env) filepath")
-	flag.Parse()
-	fmt.Printf("runtime config filepath: '%s'\n", ENV_FILEPATH)
+// writeBody writes the output of f to w as contentType. f writes into a buffer
+// so that a failure can still be reported with a 500.
+func writeBody(w http.ResponseWriter, r *http.Request, contentType string, f func(io.Writer) error) {
+	var b bytes.Buffer
+	if err := f(&b); err != nil {
+		logging.FromContext(r.Context()).Error("writing response body failed", "content_type", contentType, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b.Bytes())
 }
 
-// GetRecords queries the Record information and their current prices for all
-// records currently in the postgres database.
-func GetRecords(w http.ResponseWriter, r *http.Request) {
-	pg := postgres.GetPgInstance().Connect(ENV_FILEPATH)
-	defer pg.Close()
+// writeJSON writes v to w as json.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	writeBody(w, r, "application/json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(v)
+	})
+}
 
-	recs := pg.GetCurrentRecordPrices()
+// GetRecords queries the Record information and their current prices for
+// records currently in the postgres database, filtered, sorted and paginated
+// by the url query parameters documented on parseRecordQuery. Every record is
+// returned unless a limit or cursor is given, the total count and next page
+// are returned in the X-Total-Count and Link headers.
+//
+// The response is json of each record's summary, with its previous, lowest
+// and highest price, or html, csv or a plain text table depending on the
+// Accept header or ?format= parameter.
+func (s *Server) GetRecords(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	format, ok := negotiate(r, listFormats...)
+	if !ok {
+		notAcceptable(w, listFormats...)
+		return
+	}
+	if format == formatHTML {
+		s.Dashboard(w, r)
+		return
+	}
 
-	/* HTML Rendered Site
-	t, err := template.ParseFiles("../templates/records.html")
-	if err != nil {
-		log.Fatal("HomePage handler: ", err)
+	page, ok := s.queryRecords(w, r)
+	if !ok {
+		return
 	}
-	t.Execute(w, recs)
-	*/
+	setPageHeaders(w, r, page)
 
-	recsJson, err := recs.MarshalJSON()
-	if err != nil {
-		log.Printf("err: HomePage handler: %s\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Disposition", `inline; filename="records.csv"`)
+		writeBody(w, r, "text/csv; charset=utf-8", toRecords(page.Records).WriteCSV)
+		return
+	case formatText:
+		writeBody(w, r, "text/plain; charset=utf-8", toRecords(page.Records).WriteTable)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(recsJson)
+	summaries := page.Records
+	if summaries == nil {
+		summaries = []*records.RecordSummary{}
+	}
+	writeJSON(w, r, summaries)
+}
+
+// toRecords converts record summaries to the records written as csv or text.
+func toRecords(summaries []*records.RecordSummary) records.Records {
+	var recs records.Records
+	for _, rs := range summaries {
+		recs = append(recs, rs.ToRecord())
+	}
+	return recs
 }
 
 // PutRecords gets the current prices for all records in database, by
-// making a calling to webscaper.GetRecords. All prices are written back to
-// database and the record price information written to the http body.
-func PutRecords(w http.ResponseWriter, r *http.Request) {
+// making a calling to webscaper.GetRecords. Prices are written back to
+// database as a single scrape run and the record price information written to
+// the http body, except those anomalous against a record's history which are
+// held for review and records whose writes failed. The id of the run is
+// returned in the X-Scrape-Run header, if its writes fail none are kept and
+// 500 is returned.
+//
+// The scrape runs as a job tracked by the Server so that shutdown waits for
+// its database writes to complete.
+func (s *Server) PutRecords(w http.ResponseWriter, r *http.Request) {
+	defer s.startJob()()
+
+	// the job outlives the request so runs under the server's context, but
+	// keeps the request's logger so its lines carry the request id.
+	ctx := logging.NewContext(s.ctx, logging.FromContext(r.Context()))
+	ctx = webscraper.WithJobID(ctx, logging.NewID())
+
 	var currPrices records.Records
-	urls := webscraper.ReadURLs("../../input.txt")
-	currPrices = webscraper.GetRecords(urls)
+	urls := webscraper.ReadURLs(s.inputFile)
+	currPrices = webscraper.GetRecords(ctx, urls)
 
-	pg := postgres.GetPgInstance().Connect(ENV_FILEPATH)
-	defer pg.Close()
-	for _, rec := range currPrices {
-		pg.InsertRecord(rec)
+	run, err := s.pg.UpsertRecords(currPrices, s.anomalies)
+	if run != nil {
+		w.Header().Set("X-Scrape-Run", strconv.Itoa(run.Id))
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("PutRecords: writing records failed", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(ctx).Info("scrape run written", "run_id", run.Id, "records", run.Records,
+		"failed", run.Failed, "prices", run.Prices)
+	if run.Held > 0 {
+		logging.FromContext(ctx).Warn("prices held for review", "prices", run.Held)
+	}
+	if run.Records > 0 {
+		lastRefresh.SetToCurrentTime()
+		s.suggestMatches(ctx)
 	}
-	pg.PrintCurrentPrices()
+	s.pg.PrintCurrentPrices()
 
-	cpJson, err := currPrices.MarshalJSON()
+	cpJson, err := run.Written.MarshalJSON()
 	if err != nil {
-		log.Printf("err: GetRecordPrices handler: %s\n", err)
+		logging.FromContext(ctx).Error("PutRecords: marshalling records failed", "err", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -79,34 +152,209 @@ func PutRecords(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetRecord takes an input record id and returns the record information (i.e.
-// artist, album) and it's full pricing history.
-func GetRecord(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// artist, album) and it's full pricing history. The response format is
+// negotiated as for GetRecords, ?basis=landed gives the history of the landed
+// price including shipping.
+func (s *Server) GetRecord(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	format, ok := negotiate(r, listFormats...)
+	if !ok {
+		notAcceptable(w, listFormats...)
+		return
+	}
+
 	urlVars := mux.Vars(r)
 	rId, err := strconv.Atoi(urlVars["id"])
 	if err != nil {
-		log.Printf("GetRecord Handler: %s\n", err)
-		w.WriteHeader(http.StatusNotFound)
+		logging.FromContext(r.Context()).Warn("GetRecord: invalid record id", "id", urlVars["id"], "err", err)
+		s.recordNotFound(w, r, format)
 		return
 	}
 
-	pg := postgres.GetPgInstance().Connect(ENV_FILEPATH)
-	defer pg.Close()
+	basis, err := records.ParsePriceBasis(r.URL.Query().Get("basis"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var rph *records.RecordPriceHistory
-	rph = pg.GetRecordPriceHistory(rId)
+	rph = s.pg.GetRecordPriceHistory(rId)
 	if rph == nil {
-		w.WriteHeader(http.StatusNotFound)
+		s.recordNotFound(w, r, format)
+		return
+	}
+	if basis == records.BasisLanded {
+		rph = rph.Landed()
+	}
+
+	switch format {
+	case formatHTML:
+		renderRecord(w, r, rph)
+		return
+	case formatCSV:
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="record-%d.csv"`, rId))
+		writeBody(w, r, "text/csv; charset=utf-8", rph.WriteCSV)
+		return
+	case formatText:
+		writeBody(w, r, "text/plain; charset=utf-8", rph.WriteTable)
 		return
 	}
 
 	rphJson, err := json.Marshal(rph)
 	if err != nil {
-		log.Printf("err: GetRecord: %s\n", err)
+		logging.FromContext(r.Context()).Error("GetRecord: marshalling price history failed", "err", err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(rphJson)
 }
+
+// queryRecords runs the record query described by r's url parameters,
+// responding with an error and returning false if it fails.
+func (s *Server) queryRecords(w http.ResponseWriter, r *http.Request) (*postgres.RecordPage, bool) {
+	q, err := parseRecordQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	page, err := s.pg.GetRecordPage(q)
+	if errors.Is(err, postgres.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("querying records failed", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, false
+	}
+	return page, true
+}
+
+// UpdateRecord sets the tags and target price of a record from a json body
+// of records.RecordSettings, fields which are omitted are left unchanged.
+func (s *Server) UpdateRecord(w http.ResponseWriter, r *http.Request) {
+	rId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var settings records.RecordSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid json body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if settings.TargetPrice != nil && *settings.TargetPrice < 0 {
+		http.Error(w, "target_price must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+	if settings.OfferType != nil && !settings.OfferType.Valid() {
+		http.Error(w, "offer_type must be one of any, new, retailer or used", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.pg.UpdateRecordSettings(rId, settings)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("UpdateRecord: updating settings failed", "record_id", rId, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordNotFound responds with a 404, rendering the not found page for html.
+func (s *Server) recordNotFound(w http.ResponseWriter, r *http.Request, format string) {
+	if format == formatHTML {
+		render(w, r, "notfound.html", http.StatusNotFound, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// Healthz reports that the server is up and able to handle requests.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the server is ready to serve traffic, i.e. whether
+// the postgres database can be reached.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := s.pg.Ping(ctx); err != nil {
+		logging.FromContext(r.Context()).Error("Readyz: database ping failed", "err", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("database unavailable\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// ScraperHealth returns as json how reliably the fields of each retailer's
+// pages are extracted, flagging those degraded against their baseline, and
+// the scraped records whose price was held for review as it looks bogus.
+func (s *Server) ScraperHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, webscraper.Health())
+}
+
+// userHeader names who is making a change, releasing suppressed records or
+// deciding, entering, editing or deleting a price.
+const userHeader = "X-User"
+
+// requireUser returns who made the request, responding with 400 if they are
+// not named. action describes the change in the response, e.g. "releasing
+// the suppressed records".
+func requireUser(w http.ResponseWriter, r *http.Request, action string) (string, bool) {
+	user := r.Header.Get(userHeader)
+	if user == "" {
+		http.Error(w, userHeader+" header must name who is "+action, http.StatusBadRequest)
+		return "", false
+	}
+	return user, true
+}
+
+// ReleaseSuppressed releases the records suppressed from the url query
+// parameter, so that its last held price is taken as its price from then on.
+// 404 is returned if the url has no suppressed records.
+func (s *Server) ReleaseSuppressed(w http.ResponseWriter, r *http.Request) {
+	u := r.URL.Query().Get("url")
+	if u == "" {
+		http.Error(w, "url query parameter must name the product to release", http.StatusBadRequest)
+		return
+	}
+	user, ok := requireUser(w, r, "releasing the suppressed records")
+	if !ok {
+		return
+	}
+
+	if err := webscraper.ReleaseSuppressed(u); err != nil {
+		if errors.Is(err, webscraper.ErrNotSuppressed) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("ReleaseSuppressed: release failed", "url", u, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("suppressed records released", "url", u, "user", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Metrics writes the application's metrics in the prometheus text format.
+func (s *Server) Metrics(w http.ResponseWriter, r *http.Request) {
+	s.metrics.ServeHTTP(w, r)
+}
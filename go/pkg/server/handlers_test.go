@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateRecordValidation(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	tests := []struct {
+		name, body string
+	}{
+		{"negative target", `{"target_price": -1}`},
+		{"unknown offer type", `{"offer_type": "refurbished"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("PATCH", "/Record/1", strings.NewReader(tt.body)))
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("PATCH /Record/1 %s = %v, expected %v", tt.body, rr.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
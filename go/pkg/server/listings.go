@@ -0,0 +1,77 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/1602077/webscraper/go/pkg/webscraper"
+)
+
+// listingRequest is the body of a request to add a listing to be scraped.
+type listingRequest struct {
+	Url string `json:"url"`
+}
+
+// listingResponse is the listing added to be scraped.
+type listingResponse struct {
+	records.ProductID
+	Url string `json:"url"`
+}
+
+// AddListing adds a retailer's product url to those scraped. The url is
+// canonicalised before it is added and rejected with 409 if the product is
+// already listed or scraped, or 403 if the retailer's robots.txt disallows
+// it. A robots.txt which cannot be fetched is only warned of, as it is
+// checked again on every scrape.
+func (s *Server) AddListing(w http.ResponseWriter, r *http.Request) {
+	var req listingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "body must be json of the form {\"url\": \"...\"}", http.StatusBadRequest)
+		return
+	}
+	id, canonical, err := records.ParseProductURL(req.Url)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recordID, ok, err := s.pg.GetListing(id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("AddListing: query failed", "product_id", id, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		logging.FromContext(r.Context()).Info("listing already scraped", "product_id", id, "record_id", recordID)
+		http.Error(w, id.String()+" is already listed", http.StatusConflict)
+		return
+	}
+
+	if err := webscraper.CheckURL(r.Context(), canonical); err != nil {
+		if errors.Is(err, webscraper.ErrDisallowed) {
+			logging.FromContext(r.Context()).Info("listing disallowed", "product_id", id, "err", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		logging.FromContext(r.Context()).Warn("AddListing: robots.txt not checked, adding listing", "product_id", id, "err", err)
+	}
+
+	if _, _, err := webscraper.AddURL(s.inputFile, canonical); err != nil {
+		if errors.Is(err, webscraper.ErrDuplicateURL) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logging.FromContext(r.Context()).Error("AddListing: writing url failed", "product_id", id, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Info("listing added", "product_id", id, "url", canonical)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(listingResponse{id, canonical})
+}
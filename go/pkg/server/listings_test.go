@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddListingValidation(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	tests := []struct {
+		name, body string
+		expected   int
+	}{
+		{"not json", "https://www.amazon.co.uk/dp/B000002UAL", http.StatusBadRequest},
+		{"no url", `{}`, http.StatusBadRequest},
+		{"not a product", `{"url": "https://www.amazon.co.uk/s?k=vinyl"}`, http.StatusBadRequest},
+		{"not http", `{"url": "ftp://www.amazon.co.uk/dp/B000002UAL"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/listings", strings.NewReader(tt.body)))
+			if rr.Code != tt.expected {
+				t.Errorf("POST /listings %s = %v, expected %v", tt.body, rr.Code, tt.expected)
+			}
+		})
+	}
+}
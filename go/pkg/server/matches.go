@@ -0,0 +1,102 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/gorilla/mux"
+)
+
+// suggestMatches suggests records which are likely the same release for the
+// user to confirm or reject, logging the outcome.
+func (s *Server) suggestMatches(ctx context.Context) {
+	n, err := s.pg.SuggestMatches(records.MatchThreshold)
+	if err != nil {
+		logging.FromContext(ctx).Error("suggesting record matches failed", "err", err)
+		return
+	}
+	if n > 0 {
+		logging.FromContext(ctx).Info("record matches suggested", "matches", n)
+	}
+}
+
+// GetMatches returns the suggested matches between records as json. The
+// status query parameter selects confirmed, rejected or all matches instead.
+func (s *Server) GetMatches(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = postgres.MatchSuggested
+	case "all":
+		status = ""
+	case postgres.MatchSuggested, postgres.MatchConfirmed, postgres.MatchRejected:
+	default:
+		http.Error(w, "status must be one of suggested, confirmed, rejected or all", http.StatusBadRequest)
+		return
+	}
+
+	matches, err := s.pg.GetMatches(status)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("GetMatches: query failed", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if matches == nil {
+		matches = []*postgres.RecordMatch{}
+	}
+	writeJSON(w, r, matches)
+}
+
+// ConfirmMatch confirms a suggested match, merging the candidate record into
+// the matched record, and returns the merge as json.
+func (s *Server) ConfirmMatch(w http.ResponseWriter, r *http.Request) {
+	mId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	merge, err := s.pg.ConfirmMatch(mId)
+	if err != nil {
+		matchError(w, r, mId, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("record match confirmed", "match_id", mId,
+		"record_id", merge.Canonical, "merged", merge.Duplicates)
+	writeJSON(w, r, merge)
+}
+
+// RejectMatch rejects a suggested match so it is not suggested again.
+func (s *Server) RejectMatch(w http.ResponseWriter, r *http.Request) {
+	mId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := s.pg.RejectMatch(mId); err != nil {
+		matchError(w, r, mId, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// matchError responds to a failure to decide a match, 404 if it does not
+// exist and 409 if it has already been decided.
+func matchError(w http.ResponseWriter, r *http.Request, id int, err error) {
+	switch {
+	case errors.Is(err, postgres.ErrMatchNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, postgres.ErrMatchDecided):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logging.FromContext(r.Context()).Error("deciding match failed", "match_id", id, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
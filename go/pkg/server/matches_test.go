@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchRequestValidation(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	tests := []struct {
+		method, target string
+		expected       int
+	}{
+		{"GET", "/matches?status=pending", http.StatusBadRequest},
+		{"POST", "/matches/abc/confirm", http.StatusNotFound},
+		{"POST", "/matches/abc/reject", http.StatusNotFound},
+		{"GET", "/matches/1/confirm", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, nil))
+			if rr.Code != tt.expected {
+				t.Errorf("%s %s = %v, expected %v", tt.method, tt.target, rr.Code, tt.expected)
+			}
+		})
+	}
+}
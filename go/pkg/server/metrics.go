@@ -0,0 +1,71 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of http requests handled by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle a http request by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+	lastRefresh = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webscraper_last_successful_refresh_timestamp_seconds",
+		Help: "Unix time of the last refresh which wrote prices to the database.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, lastRefresh)
+}
+
+// dbMetrics creates a registry exposing the connection pool statistics of the
+// Server's database.
+func (s *Server) dbMetrics() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	gauge := func(name, help string, f func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "db_pool_" + name, Help: help}, f)
+	}
+	counter := func(name, help string, f func() float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "db_pool_" + name, Help: help}, f)
+	}
+	reg.MustRegister(
+		gauge("open_connections", "Number of established connections, both in use and idle.",
+			func() float64 { return float64(s.pg.Stats().OpenConnections) }),
+		gauge("in_use_connections", "Number of connections currently in use.",
+			func() float64 { return float64(s.pg.Stats().InUse) }),
+		gauge("idle_connections", "Number of idle connections.",
+			func() float64 { return float64(s.pg.Stats().Idle) }),
+		counter("wait_count_total", "Total number of connections waited for.",
+			func() float64 { return float64(s.pg.Stats().WaitCount) }),
+		counter("wait_duration_seconds_total", "Total time blocked waiting for a new connection.",
+			func() float64 { return s.pg.Stats().WaitDuration.Seconds() }),
+		gauge("max_open_connections", "Maximum number of open connections to the database.",
+			func() float64 { return float64(s.pg.Stats().MaxOpenConnections) }),
+	)
+	return reg
+}
+
+// instrument is a mux middleware recording the count and latency of requests
+// against the name of the matched route.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := routeName(r)
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,70 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// routeName returns the name of the mux route matched by r.
+func routeName(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil && cr.GetName() != "" {
+		return cr.GetName()
+	}
+	return "unknown"
+}
+
+// requestID is a mux middleware which tags the request's context logger with
+// a request id, taken from the X-Request-ID header if the client set one. The
+// id is echoed back in the response headers.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = logging.NewID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := logging.With(r.Context(), "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// accessLog is a mux middleware which writes a log line for every request
+// handled.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logging.FromContext(r.Context()).Log(r.Context(), level, "request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", routeName(r),
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
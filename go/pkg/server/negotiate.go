@@ -0,0 +1,117 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// response formats which list endpoints can be rendered in.
+const (
+	formatJSON = "json"
+	formatHTML = "html"
+	formatCSV  = "csv"
+	formatText = "text"
+)
+
+var formatMediaTypes = map[string]string{
+	formatJSON: "application/json",
+	formatHTML: "text/html",
+	formatCSV:  "text/csv",
+	formatText: "text/plain",
+}
+
+// acceptRange is a single media range of an Accept header.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges of an Accept header.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mt, q: q})
+	}
+	return ranges
+}
+
+// specificity ranks how closely the range matches a media type, -1 if it
+// does not match at all.
+func (a acceptRange) specificity(mediaType string) int {
+	switch {
+	case a.mediaType == mediaType:
+		return 2
+	case a.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(a.mediaType, "/*") &&
+		strings.HasPrefix(mediaType, strings.TrimSuffix(a.mediaType, "*")):
+		return 1
+	}
+	return -1
+}
+
+// quality returns the q value given to mediaType by the most specific
+// matching range, along with that range's specificity.
+func quality(ranges []acceptRange, mediaType string) (float64, int) {
+	q, best := 0.0, -1
+	for _, ar := range ranges {
+		if s := ar.specificity(mediaType); s > best {
+			q, best = ar.q, s
+		}
+	}
+	return q, best
+}
+
+// negotiate picks the response format for r from offers, which are in order
+// of the server's preference. A ?format= query parameter overrides the
+// Accept header, a missing Accept header selects the first offer. false is
+// returned if none of the offers are acceptable.
+func negotiate(r *http.Request, offers ...string) (string, bool) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		for _, o := range offers {
+			if o == f {
+				return o, true
+			}
+		}
+		return "", false
+	}
+
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return offers[0], true
+	}
+
+	ranges := parseAccept(header)
+	// the highest q wins, ties go to the offer the client named explicitly
+	// and then to the server's order of preference.
+	format, bestQ, bestS := "", 0.0, -1
+	for _, o := range offers {
+		q, s := quality(ranges, formatMediaTypes[o])
+		if q > bestQ || (q == bestQ && q > 0 && s > bestS) {
+			format, bestQ, bestS = o, q, s
+		}
+	}
+	return format, format != ""
+}
+
+// notAcceptable responds with a 406 listing the formats that are available.
+func notAcceptable(w http.ResponseWriter, offers ...string) {
+	var types []string
+	for _, o := range offers {
+		types = append(types, formatMediaTypes[o])
+	}
+	http.Error(w, "supported formats: "+strings.Join(types, ", "), http.StatusNotAcceptable)
+}
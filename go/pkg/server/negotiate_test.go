@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+		format string
+		ok     bool
+	}{
+		{"no accept header defaults to json", "/", "", formatJSON, true},
+		{"curl wildcard defaults to json", "/", "*/*", formatJSON, true},
+		{"browser gets html", "/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", formatHTML, true},
+		{"csv", "/", "text/csv", formatCSV, true},
+		{"plain text", "/", "text/plain", formatText, true},
+		{"q values order preference", "/", "application/json;q=0.5, text/csv", formatCSV, true},
+		{"specific beats wildcard", "/", "*/*, text/plain", formatText, true},
+		{"text wildcard prefers html", "/", "text/*", formatHTML, true},
+		{"q=0 excludes format", "/", "application/json;q=0, */*;q=0.1", formatHTML, true},
+		{"unsupported type", "/", "image/png", "", false},
+		{"format overrides accept", "/?format=csv", "text/html", formatCSV, true},
+		{"unknown format", "/?format=xml", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			format, ok := negotiate(r, listFormats...)
+			if format != tt.format || ok != tt.ok {
+				t.Errorf("negotiate(%q) = (%q, %t), expected (%q, %t)", tt.accept, format, ok, tt.format, tt.ok)
+			}
+		})
+	}
+}
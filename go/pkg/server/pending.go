@@ -0,0 +1,136 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/gorilla/mux"
+)
+
+// correctionRequest is the body of a request to correct a pending price.
+type correctionRequest struct {
+	Price *float32 `json:"price"`
+	Note  string   `json:"note"`
+}
+
+// GetPendingPrices returns the scraped prices held for review as json, oldest
+// first. The status query parameter selects approved, corrected, discarded
+// or all prices instead.
+func (s *Server) GetPendingPrices(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = postgres.PendingHeld
+	case "all":
+		status = ""
+	case postgres.PendingHeld, postgres.PendingApproved, postgres.PendingCorrected, postgres.PendingDiscarded:
+	default:
+		http.Error(w, "status must be one of pending, approved, corrected, discarded or all", http.StatusBadRequest)
+		return
+	}
+
+	pending, err := s.pg.GetPendingPrices(status)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("GetPendingPrices: query failed", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if pending == nil {
+		pending = []*postgres.PendingPrice{}
+	}
+	writeJSON(w, r, pending)
+}
+
+// ApprovePendingPrice writes a held price to the record's price history as
+// it was scraped, the note query parameter is recorded as the reason.
+func (s *Server) ApprovePendingPrice(w http.ResponseWriter, r *http.Request) {
+	pId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, "approving the price")
+	if !ok {
+		return
+	}
+
+	if err := s.pg.ApprovePendingPrice(pId, user, r.URL.Query().Get("note")); err != nil {
+		pendingError(w, r, pId, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("pending price approved", "pending_id", pId, "user", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CorrectPendingPrice writes the price in the json body, of the form
+// {"price": 19.99, "note": "..."}, to the record's price history in place of
+// a held price.
+func (s *Server) CorrectPendingPrice(w http.ResponseWriter, r *http.Request) {
+	pId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, "correcting the price")
+	if !ok {
+		return
+	}
+	var req correctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Price == nil || *req.Price <= 0 || *req.Price > postgres.MaxPrice {
+		http.Error(w, "body must be json of the form {\"price\": 19.99} with a positive price", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.pg.CorrectPendingPrice(pId, *req.Price, user, req.Note); err != nil {
+		pendingError(w, r, pId, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("pending price corrected", "pending_id", pId, "price", *req.Price,
+		"user", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DiscardPendingPrice discards a held price so it is never written, the note
+// query parameter is recorded as the reason.
+func (s *Server) DiscardPendingPrice(w http.ResponseWriter, r *http.Request) {
+	pId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, "discarding the price")
+	if !ok {
+		return
+	}
+
+	if err := s.pg.DiscardPendingPrice(pId, user, r.URL.Query().Get("note")); err != nil {
+		pendingError(w, r, pId, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("pending price discarded", "pending_id", pId, "user", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingError responds to a failure to decide a pending price, 404 if it
+// does not exist, 409 if it has already been decided and 422 if it is too
+// large to approve.
+func pendingError(w http.ResponseWriter, r *http.Request, id int, err error) {
+	switch {
+	case errors.Is(err, postgres.ErrPendingNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, postgres.ErrPendingDecided):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, postgres.ErrPendingOutOfRange):
+		http.Error(w, fmt.Sprintf("price is larger than %.2f, correct or discard it", postgres.MaxPrice),
+			http.StatusUnprocessableEntity)
+	default:
+		logging.FromContext(r.Context()).Error("deciding pending price failed", "pending_id", id, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPendingPriceRequestValidation(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	tests := []struct {
+		method, target, user, body string
+		expected                   int
+	}{
+		{"GET", "/prices/pending?status=held", "", "", http.StatusBadRequest},
+		{"POST", "/prices/pending/abc/approve", "jack", "", http.StatusNotFound},
+		{"POST", "/prices/pending/abc/discard", "jack", "", http.StatusNotFound},
+		{"POST", "/prices/pending/1/approve", "", "", http.StatusBadRequest},
+		{"POST", "/prices/pending/1/discard", "", "", http.StatusBadRequest},
+		{"POST", "/prices/pending/1/correct", "", `{"price": 19.99}`, http.StatusBadRequest},
+		{"POST", "/prices/pending/1/correct", "jack", `{}`, http.StatusBadRequest},
+		{"POST", "/prices/pending/1/correct", "jack", `{"price": 0}`, http.StatusBadRequest},
+		{"POST", "/prices/pending/1/correct", "jack", `{"price": 10000}`, http.StatusBadRequest},
+		{"GET", "/prices/pending/1/approve", "", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target+" "+tt.body, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.user != "" {
+				req.Header.Set(userHeader, tt.user)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.expected {
+				t.Errorf("%s %s = %v, expected %v", tt.method, tt.target, rr.Code, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/gorilla/mux"
+)
+
+// priceRequest is the body of a request to enter a price by hand.
+type priceRequest struct {
+	Date       string     `json:"date"`
+	CapturedAt *time.Time `json:"captured_at"`
+	Retailer   string     `json:"retailer"`
+	Price      *float32   `json:"price"`
+	Shipping   float32    `json:"shipping"`
+	Available  *bool      `json:"available"`
+	Source     string     `json:"source"`
+	Note       string     `json:"note"`
+}
+
+// priceEdit is the body of a request to edit a price, fields which are
+// omitted are left as they are.
+type priceEdit struct {
+	Price    *float32 `json:"price"`
+	Shipping *float32 `json:"shipping"`
+	Note     string   `json:"note"`
+}
+
+// AddPrice enters a price for a record by hand, from a json body of the form
+// {"date": "2024-05-04", "retailer": "amazon", "price": 19.99}. The price is
+// written to the first snapshot of the date, or that of captured_at if it is
+// given as a timestamp instead, or the current snapshot if neither is. The
+// retailer defaults to amazon and the source to manual; the source may
+// instead be imported. A scraped price for the retailer in the snapshot is
+// replaced and later scrapes never overwrite the price entered, but a price
+// already entered is rejected with 409 and must be edited instead.
+func (s *Server) AddPrice(w http.ResponseWriter, r *http.Request) {
+	rId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, "entering the price")
+	if !ok {
+		return
+	}
+	var req priceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Price == nil || *req.Price <= 0 {
+		http.Error(w, "body must be json of the form {\"price\": 19.99} with a positive price", http.StatusBadRequest)
+		return
+	}
+	if req.Shipping < 0 {
+		http.Error(w, "shipping must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+	e := postgres.PriceEntry{
+		RecordID:   rId,
+		CapturedAt: time.Now(),
+		Retailer:   req.Retailer,
+		Price:      *req.Price,
+		Shipping:   req.Shipping,
+		Available:  req.Available == nil || *req.Available,
+		Source:     req.Source,
+	}
+	switch {
+	case req.CapturedAt != nil:
+		e.CapturedAt = *req.CapturedAt
+	case req.Date != "":
+		if e.CapturedAt, err = time.ParseInLocation(time.DateOnly, req.Date, time.Local); err != nil {
+			http.Error(w, "date must be of the form 2006-01-02", http.StatusBadRequest)
+			return
+		}
+	}
+	if e.Retailer == "" {
+		e.Retailer = records.DefaultRetailer
+	}
+	if e.Source == "" {
+		e.Source = postgres.SourceManual
+	}
+	if e.Source != postgres.SourceManual && e.Source != postgres.SourceImported {
+		http.Error(w, "source must be one of manual or imported", http.StatusBadRequest)
+		return
+	}
+
+	p, err := s.pg.InsertPrice(e, user, req.Note)
+	if err != nil {
+		priceError(w, r, rId, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("price entered", "record_id", rId, "price_id", p.Id,
+		"retailer", p.Retailer, "price", p.Price, "source", p.Source, "user", user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// EditPrice edits the price or shipping of a price from a json body of the
+// form {"price": 19.99, "note": "..."}. The price becomes a manual price, so
+// is never overwritten by later scrapes.
+func (s *Server) EditPrice(w http.ResponseWriter, r *http.Request) {
+	pId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, "editing the price")
+	if !ok {
+		return
+	}
+	var req priceEdit
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Price == nil && req.Shipping == nil) {
+		http.Error(w, "body must be json of the form {\"price\": 19.99, \"shipping\": 2.99}", http.StatusBadRequest)
+		return
+	}
+	if (req.Price != nil && *req.Price <= 0) || (req.Shipping != nil && *req.Shipping < 0) {
+		http.Error(w, "price must be positive and shipping non-negative", http.StatusBadRequest)
+		return
+	}
+
+	p, err := s.pg.UpdatePrice(pId, req.Price, req.Shipping, user, req.Note)
+	if err != nil {
+		priceError(w, r, pId, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("price edited", "price_id", pId, "price", p.Price, "user", user)
+	writeJSON(w, r, p)
+}
+
+// DeletePrice deletes a price from a record's history, the note query
+// parameter is recorded in the audit trail as the reason.
+func (s *Server) DeletePrice(w http.ResponseWriter, r *http.Request) {
+	pId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	user, ok := requireUser(w, r, "deleting the price")
+	if !ok {
+		return
+	}
+
+	if err := s.pg.DeletePrice(pId, user, r.URL.Query().Get("note")); err != nil {
+		priceError(w, r, pId, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("price deleted", "price_id", pId, "user", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPriceChanges returns the audit trail of the prices of a record entered,
+// edited or deleted by hand as json, most recent first.
+func (s *Server) GetPriceChanges(w http.ResponseWriter, r *http.Request) {
+	rId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	changes, err := s.pg.GetPriceChanges(rId)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("GetPriceChanges: query failed", "record_id", rId, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if changes == nil {
+		changes = []*postgres.PriceChange{}
+	}
+	writeJSON(w, r, changes)
+}
+
+// GetDailyPrices returns the open, close, low and high price of a record from
+// each retailer on every date it was scraped as json, oldest first.
+func (s *Server) GetDailyPrices(w http.ResponseWriter, r *http.Request) {
+	rId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	daily, err := s.pg.GetDailyPrices(rId)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("GetDailyPrices: query failed", "record_id", rId, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if daily == nil {
+		daily = []*records.DailyPrice{}
+	}
+	writeJSON(w, r, daily)
+}
+
+// priceError responds to a failure to change a price, 404 if it or its
+// record does not exist and 409 if a price has already been entered.
+func priceError(w http.ResponseWriter, r *http.Request, id int, err error) {
+	switch {
+	case errors.Is(err, postgres.ErrPriceNotFound), errors.Is(err, postgres.ErrRecordNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, postgres.ErrPriceExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, postgres.ErrInvalidSource):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logging.FromContext(r.Context()).Error("changing price failed", "id", id, "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPriceRequestValidation(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	tests := []struct {
+		method, target, user, body string
+		expected                   int
+	}{
+		{"POST", "/Record/abc/prices", "jack", `{"price": 19.99}`, http.StatusNotFound},
+		{"POST", "/Record/1/prices", "", `{"price": 19.99}`, http.StatusBadRequest},
+		{"POST", "/Record/1/prices", "jack", `{}`, http.StatusBadRequest},
+		{"POST", "/Record/1/prices", "jack", `{"price": -1}`, http.StatusBadRequest},
+		{"POST", "/Record/1/prices", "jack", `{"price": 19.99, "shipping": -1}`, http.StatusBadRequest},
+		{"POST", "/Record/1/prices", "jack", `{"price": 19.99, "date": "04/05/2024"}`, http.StatusBadRequest},
+		{"POST", "/Record/1/prices", "jack", `{"price": 19.99, "source": "scraped"}`, http.StatusBadRequest},
+		{"PATCH", "/prices/abc", "jack", `{"price": 19.99}`, http.StatusNotFound},
+		{"PATCH", "/prices/1", "", `{"price": 19.99}`, http.StatusBadRequest},
+		{"PATCH", "/prices/1", "jack", `{"note": "typo"}`, http.StatusBadRequest},
+		{"PATCH", "/prices/1", "jack", `{"price": 0}`, http.StatusBadRequest},
+		{"DELETE", "/prices/abc", "jack", "", http.StatusNotFound},
+		{"DELETE", "/prices/1", "", "", http.StatusBadRequest},
+		{"GET", "/Record/abc/audit", "", "", http.StatusNotFound},
+		{"GET", "/Record/abc/daily", "", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target+" "+tt.body, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.user != "" {
+				req.Header.Set(userHeader, tt.user)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.expected {
+				t.Errorf("%s %s = %v, expected %v", tt.method, tt.target, rr.Code, tt.expected)
+			}
+		})
+	}
+}
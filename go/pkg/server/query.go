@@ -0,0 +1,121 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// page sizes of the list endpoint, which returns every record unless a limit
+// or cursor is given.
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// parseRecordQuery reads the filters, sort order and page of the list
+// endpoint from the url query parameters:
+//
+//	sort         comma separated fields of id, artist, album, price, change,
+//	             discount or low, each prefixed with - to sort descending
+//	order        asc or desc, desc reverses every sort field
+//	artist       artist contains
+//	q            full text search over artist and album
+//	min_price    current price at or above
+//	max_price    current price at or below
+//	available    true or false
+//	tag          tagged with
+//	retailer     current price scraped from
+//	below_target true for records at or below their target price
+//	basis        headline or landed, the price including shipping, which
+//	             price, change, discount, low and the price filters use
+//	limit        page size, up to 500, every record is returned if neither
+//	             limit nor cursor is given
+//	cursor       next_cursor of the previous page, pages of 50 if no limit
+//	             is given
+func parseRecordQuery(v url.Values) (postgres.RecordQuery, error) {
+	q := postgres.RecordQuery{
+		Artist:   v.Get("artist"),
+		Search:   v.Get("q"),
+		Tag:      v.Get("tag"),
+		Retailer: v.Get("retailer"),
+		Cursor:   v.Get("cursor"),
+	}
+	if q.Cursor != "" {
+		q.Limit = defaultLimit
+	}
+
+	var err error
+	if q.Sort, err = records.ParseSortOrder(v.Get("sort")); err != nil {
+		return q, err
+	}
+	switch v.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Sort = q.Sort.Reverse()
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+
+	if q.MinPrice, err = parsePriceParam(v, "min_price"); err != nil {
+		return q, err
+	}
+	if q.MaxPrice, err = parsePriceParam(v, "max_price"); err != nil {
+		return q, err
+	}
+	if s := v.Get("available"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("available must be true or false")
+		}
+		q.Available = &b
+	}
+	if s := v.Get("below_target"); s != "" {
+		if q.BelowTarget, err = strconv.ParseBool(s); err != nil {
+			return q, fmt.Errorf("below_target must be true or false")
+		}
+	}
+	if s := v.Get("basis"); s != "" {
+		if q.Basis, err = records.ParsePriceBasis(s); err != nil {
+			return q, err
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 || q.Limit > maxLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+	}
+	return q, nil
+}
+
+func parsePriceParam(v url.Values, key string) (*float32, error) {
+	s := v.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 32)
+	if err != nil || f < 0 {
+		return nil, fmt.Errorf("%s must be a non-negative number", key)
+	}
+	p := float32(f)
+	return &p, nil
+}
+
+// setPageHeaders exposes the total count and the link to the next page of a
+// list response.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, page *postgres.RecordPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor == "" {
+		return
+	}
+	next := *r.URL
+	q := next.Query()
+	q.Set("cursor", page.NextCursor)
+	next.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+func TestParseRecordQuery(t *testing.T) {
+	f32 := func(f float32) *float32 { return &f }
+	yes := true
+
+	tests := []struct {
+		name     string
+		query    string
+		expected postgres.RecordQuery
+		wantErr  bool
+	}{
+		{"defaults", "", postgres.RecordQuery{}, false},
+		{"cursor without limit", "cursor=abc", postgres.RecordQuery{Cursor: "abc", Limit: defaultLimit}, false},
+		{
+			"all parameters",
+			"sort=discount&order=desc&artist=misch&q=kinda+music&min_price=10&max_price=20.5" +
+				"&available=true&tag=jazz&retailer=amazon&below_target=true&limit=10&cursor=abc",
+			postgres.RecordQuery{
+				Artist:      "misch",
+				Search:      "kinda music",
+				MinPrice:    f32(10),
+				MaxPrice:    f32(20.5),
+				Available:   &yes,
+				Tag:         "jazz",
+				Retailer:    "amazon",
+				BelowTarget: true,
+				Sort:        records.SortOrder{{Name: "discount", Desc: true}},
+				Limit:       10,
+				Cursor:      "abc",
+			},
+			false,
+		},
+		{
+			"multiple sort fields",
+			"sort=artist,-price",
+			postgres.RecordQuery{
+				Sort: records.SortOrder{{Name: "artist"}, {Name: "price", Desc: true}},
+			},
+			false,
+		},
+		{
+			"landed price",
+			"basis=landed&sort=price&max_price=25",
+			postgres.RecordQuery{
+				MaxPrice: f32(25),
+				Basis:    records.BasisLanded,
+				Sort:     records.SortOrder{{Name: "price"}},
+			},
+			false,
+		},
+		{"invalid basis", "basis=net", postgres.RecordQuery{}, true},
+		{"invalid order", "order=up", postgres.RecordQuery{}, true},
+		{"repeated sort field", "sort=price,-price", postgres.RecordQuery{}, true},
+		{"negative price", "min_price=-1", postgres.RecordQuery{}, true},
+		{"invalid availability", "available=maybe", postgres.RecordQuery{}, true},
+		{"limit too large", "limit=501", postgres.RecordQuery{}, true},
+		{"limit zero", "limit=0", postgres.RecordQuery{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, _ := url.ParseQuery(tt.query)
+			got, err := parseRecordQuery(v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRecordQuery(%q) err = %v, wantErr %t", tt.query, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseRecordQuery(%q)\nExpected: %+v\nGot: %+v", tt.query, tt.expected, got)
+			}
+		})
+	}
+}
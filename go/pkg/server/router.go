@@ -5,25 +5,24 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// NewRouter create a gorilla mux Router using the route defined by the variable
-// routes in routes.go.
-func NewRouter() *mux.Router {
+// NewRouter create a gorilla mux Router using the routes defined by
+// Server.routes in routes.go.
+func NewRouter(s *Server) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
-	for _, route := range routes {
+	for _, route := range s.routes() {
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
 			Handler(route.HandlerFunc)
 	}
+	router.Use(requestID, accessLog, instrument)
 
-	/* Serve css files
 	router.
+		Methods("GET").
 		PathPrefix("/static/").
-		Handler(http.StripPrefix("/static/",
-			http.FileServer(http.Dir("../static/")))
-		)
-	*/
+		Name("Static").
+		Handler(staticFiles())
 
 	return router
 }
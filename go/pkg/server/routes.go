@@ -12,23 +12,151 @@ type Route struct {
 
 type Routes []Route
 
-var routes = Routes{
-	Route{
-		"HomePage",
-		"GET",
-		"/",
-		GetRecords,
-	},
-	Route{
-		"Refresh",
-		"GET",
-		"/refresh",
-		PutRecords,
-	},
-	Route{
-		"GetRecord",
-		"GET",
-		"/Record/{id}",
-		GetRecord,
-	},
+func (s *Server) routes() Routes {
+	return Routes{
+		Route{
+			"HomePage",
+			"GET",
+			"/",
+			s.GetRecords,
+		},
+		Route{
+			"Refresh",
+			"GET",
+			"/refresh",
+			s.PutRecords,
+		},
+		Route{
+			"GetRecord",
+			"GET",
+			"/Record/{id}",
+			s.GetRecord,
+		},
+		Route{
+			"UpdateRecord",
+			"PATCH",
+			"/Record/{id}",
+			s.UpdateRecord,
+		},
+		Route{
+			"AddListing",
+			"POST",
+			"/listings",
+			s.AddListing,
+		},
+		Route{
+			"GetMatches",
+			"GET",
+			"/matches",
+			s.GetMatches,
+		},
+		Route{
+			"ConfirmMatch",
+			"POST",
+			"/matches/{id}/confirm",
+			s.ConfirmMatch,
+		},
+		Route{
+			"RejectMatch",
+			"POST",
+			"/matches/{id}/reject",
+			s.RejectMatch,
+		},
+		Route{
+			"GetDailyPrices",
+			"GET",
+			"/Record/{id}/daily",
+			s.GetDailyPrices,
+		},
+		Route{
+			"AddPrice",
+			"POST",
+			"/Record/{id}/prices",
+			s.AddPrice,
+		},
+		Route{
+			"GetPriceChanges",
+			"GET",
+			"/Record/{id}/audit",
+			s.GetPriceChanges,
+		},
+		Route{
+			"EditPrice",
+			"PATCH",
+			"/prices/{id}",
+			s.EditPrice,
+		},
+		Route{
+			"DeletePrice",
+			"DELETE",
+			"/prices/{id}",
+			s.DeletePrice,
+		},
+		Route{
+			"GetPendingPrices",
+			"GET",
+			"/prices/pending",
+			s.GetPendingPrices,
+		},
+		Route{
+			"ApprovePendingPrice",
+			"POST",
+			"/prices/pending/{id}/approve",
+			s.ApprovePendingPrice,
+		},
+		Route{
+			"CorrectPendingPrice",
+			"POST",
+			"/prices/pending/{id}/correct",
+			s.CorrectPendingPrice,
+		},
+		Route{
+			"DiscardPendingPrice",
+			"POST",
+			"/prices/pending/{id}/discard",
+			s.DiscardPendingPrice,
+		},
+		Route{
+			"Dashboard",
+			"GET",
+			"/dashboard",
+			s.Dashboard,
+		},
+		Route{
+			"DashboardRecord",
+			"GET",
+			"/dashboard/{id}",
+			s.DashboardRecord,
+		},
+		Route{
+			"Healthz",
+			"GET",
+			"/healthz",
+			s.Healthz,
+		},
+		Route{
+			"Readyz",
+			"GET",
+			"/readyz",
+			s.Readyz,
+		},
+		Route{
+			"ScraperHealth",
+			"GET",
+			"/admin/health",
+			s.ScraperHealth,
+		},
+		Route{
+			"ReleaseSuppressed",
+			"POST",
+			"/admin/health/release",
+			s.ReleaseSuppressed,
+		},
+		Route{
+			"Metrics",
+			"GET",
+			"/metrics",
+			s.Metrics,
+		},
+	}
 }
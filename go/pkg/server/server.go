@@ -0,0 +1,84 @@
+// server packages api routing and handling for go webscraping app.
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/1602077/webscraper/go/pkg/postgres"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Server holds the state shared by all api handlers: the postgres connection
+// pool, the file of urls to scrape and the context scrape jobs run under.
+type Server struct {
+	pg        *postgres.PgInstance
+	inputFile string
+	ctx       context.Context
+	metrics   http.Handler
+	// anomalies decide which scraped prices are held for review.
+	anomalies records.AnomalyRules
+
+	mu   sync.Mutex
+	jobs int
+	idle chan struct{} // closed once jobs drops to zero
+}
+
+// NewServer creates a Server backed by an open postgres connection. Scrape
+// jobs started by the server are cancelled when ctx is done.
+func NewServer(ctx context.Context, pg *postgres.PgInstance, inputFile string) *Server {
+	s := &Server{
+		pg:        pg,
+		inputFile: inputFile,
+		ctx:       ctx,
+		anomalies: records.DefaultAnomalyRules,
+	}
+	s.metrics = promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, s.dbMetrics()},
+		promhttp.HandlerOpts{})
+	return s
+}
+
+// startJob registers a scrape job, the returned func must be called once the
+// job has finished.
+func (s *Server) startJob() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.jobs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.jobs--
+			if s.jobs == 0 {
+				close(s.idle)
+			}
+		})
+	}
+}
+
+// Wait blocks until all in-flight scrape jobs have finished or ctx is done,
+// in which case ctx.Err() is returned.
+func (s *Server) Wait(ctx context.Context) error {
+	s.mu.Lock()
+	if s.jobs == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	idle := s.idle
+	s.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
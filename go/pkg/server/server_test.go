@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/webscraper"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServerWait(t *testing.T) {
+	s := NewServer(context.Background(), nil, "")
+
+	t.Run("returns immediately with no jobs", func(t *testing.T) {
+		if err := s.Wait(context.Background()); err != nil {
+			t.Errorf("Wait() = %v, expected nil", err)
+		}
+	})
+
+	t.Run("returns ctx error if job outlives deadline", func(t *testing.T) {
+		done := s.startJob()
+		defer done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := s.Wait(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Wait() = %v, expected %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("returns once job has finished", func(t *testing.T) {
+		done := s.startJob()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			done()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := s.Wait(ctx); err != nil {
+			t.Errorf("Wait() = %v, expected nil", err)
+		}
+	})
+}
+
+func TestHealthzIsInstrumented(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("Healthz", "GET", "200"))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("GET /healthz = %v, expected %v", rr.Code, http.StatusOK)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("Healthz", "GET", "200")); got != before+1 {
+		t.Errorf("http_requests_total{route=Healthz} = %v, expected %v", got, before+1)
+	}
+}
+
+func TestScraperHealth(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/health", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("GET /admin/health = %v, expected %v", rr.Code, http.StatusOK)
+	}
+	var report webscraper.HealthReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil || report.Retailers == nil {
+		t.Errorf("GET /admin/health = %s, expected a health report", rr.Body)
+	}
+}
+
+func TestReleaseSuppressed(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	tests := []struct {
+		name, query, user string
+		expected          int
+		message           string
+	}{
+		{"no url", "", "sam", http.StatusBadRequest, "url query parameter"},
+		{"no user", "?url=https://www.amazon.co.uk/dp/B084P38346", "", http.StatusBadRequest, "releasing the suppressed records"},
+		{"nothing suppressed", "?url=https://www.amazon.co.uk/dp/B084P38346", "sam", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/admin/health/release"+tt.query, nil)
+			if tt.user != "" {
+				req.Header.Set(userHeader, tt.user)
+			}
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.expected {
+				t.Errorf("POST /admin/health/release%s = %v, expected %v", tt.query, rr.Code, tt.expected)
+			}
+			if !strings.Contains(rr.Body.String(), tt.message) {
+				t.Errorf("POST /admin/health/release%s body = %q, expected to contain %q", tt.query, rr.Body.String(), tt.message)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router := NewRouter(NewServer(context.Background(), nil, ""))
+
+	t.Run("generated if not set", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+		if id := rr.Header().Get(requestIDHeader); len(id) != 16 {
+			t.Errorf("expected generated request id, got %q", id)
+		}
+	})
+
+	t.Run("echoes client id", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		req.Header.Set(requestIDHeader, "client-id")
+		router.ServeHTTP(rr, req)
+		if id := rr.Header().Get(requestIDHeader); id != "client-id" {
+			t.Errorf("expected request id client-id, got %q", id)
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package webscraper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+)
+
+// ErrNotArchived is returned when re-parsing a page missing from the archive.
+var ErrNotArchived = errors.New("page not archived")
+
+// ArchiveConfig configures the archive of scraped pages, pages are not
+// archived if it has no dir.
+type ArchiveConfig struct {
+	Dir string `json:"dir"`
+	// RetentionDays is how many days pages are kept for, forever if 0.
+	RetentionDays int `json:"retention_days"`
+}
+
+// ArchivedPage is a page fetched by a scrape, whose body is stored in the
+// archive under its hash.
+type ArchivedPage struct {
+	Url       string    `json:"url"`
+	Key       string    `json:"key"`
+	Hash      string    `json:"hash"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// Archive stores the raw pages of every scrape so that they can be parsed
+// again. Bodies are gzipped and stored once under the sha256 of their
+// content, and indexed by the day they were fetched.
+type Archive struct {
+	dir       string
+	retention int
+
+	mu  sync.Mutex // serialises writes to the archive
+	now func() time.Time
+}
+
+// NewArchive opens the archive described by cfg, creating its dir.
+func NewArchive(cfg ArchiveConfig) (*Archive, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("NewArchive() no archive dir")
+	}
+	if cfg.RetentionDays < 0 {
+		return nil, fmt.Errorf("NewArchive() retention_days must not be negative")
+	}
+	a := &Archive{dir: cfg.Dir, retention: cfg.RetentionDays, now: time.Now}
+	for _, d := range []string{a.objectsDir(), a.indexDir()} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("NewArchive() creating %s failed: %w", d, err)
+		}
+	}
+	return a, nil
+}
+
+func (a *Archive) objectsDir() string { return filepath.Join(a.dir, "objects") }
+func (a *Archive) indexDir() string   { return filepath.Join(a.dir, "index") }
+
+func (a *Archive) objectPath(hash string) string {
+	return filepath.Join(a.objectsDir(), hash[:2], hash+".html.gz")
+}
+
+func (a *Archive) indexPath(day time.Time) string {
+	return filepath.Join(a.indexDir(), day.Format(time.DateOnly)+".jsonl")
+}
+
+// Put archives the body of the page at url fetched at t.
+func (a *Archive) Put(url string, body []byte, t time.Time) (ArchivedPage, error) {
+	sum := sha256.Sum256(body)
+	page := ArchivedPage{Url: url, Key: url, Hash: hex.EncodeToString(sum[:]), FetchedAt: t}
+	if _, canonical, err := records.ParseProductURL(url); err == nil {
+		page.Key = canonical
+	}
+
+	line, err := json.Marshal(page)
+	if err != nil {
+		return page, err
+	}
+	// bodies are stored and indexed under the lock so that Prune never sees
+	// one unreferenced.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.putObject(page.Hash, body); err != nil {
+		return page, fmt.Errorf("Archive.Put() storing %s failed: %w", url, err)
+	}
+	f, err := os.OpenFile(a.indexPath(t), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return page, fmt.Errorf("Archive.Put() indexing %s failed: %w", url, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return page, fmt.Errorf("Archive.Put() indexing %s failed: %w", url, err)
+	}
+	return page, nil
+}
+
+// putObject stores body under hash unless it is already stored.
+func (a *Archive) putObject(hash string, body []byte) error {
+	path := a.objectPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(body)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// Get returns the body stored under hash.
+func (a *Archive) Get(hash string) ([]byte, error) {
+	if len(hash) < 2 {
+		return nil, fmt.Errorf("%w: %q", ErrNotArchived, hash)
+	}
+	f, err := os.Open(a.objectPath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotArchived, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("Archive.Get() reading %s failed: %w", hash, err)
+	}
+	return io.ReadAll(zr)
+}
+
+// Pages returns the pages archived on day, in the order they were fetched.
+func (a *Archive) Pages(day time.Time) ([]ArchivedPage, error) {
+	f, err := os.Open(a.indexPath(day))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pages []ArchivedPage
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var p ArchivedPage
+		// a line cut short by a crash is skipped rather than failing the day.
+		if err := json.Unmarshal(s.Bytes(), &p); err != nil {
+			continue
+		}
+		pages = append(pages, p)
+	}
+	return pages, s.Err()
+}
+
+// Prune removes the index of days older than the retention period, then the
+// bodies no remaining page refers to. The number of days and bodies removed
+// is returned.
+func (a *Archive) Prune() (int, int, error) {
+	if a.retention == 0 {
+		return 0, 0, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cutoff := a.now().AddDate(0, 0, -a.retention).Format(time.DateOnly)
+	entries, err := os.ReadDir(a.indexDir())
+	if err != nil {
+		return 0, 0, fmt.Errorf("Archive.Prune() failed: %w", err)
+	}
+	days := 0
+	referenced := make(map[string]bool)
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok {
+			continue
+		}
+		if day < cutoff {
+			if err := os.Remove(filepath.Join(a.indexDir(), e.Name())); err != nil {
+				return days, 0, fmt.Errorf("Archive.Prune() failed: %w", err)
+			}
+			days++
+			continue
+		}
+		t, err := time.ParseInLocation(time.DateOnly, day, time.Local)
+		if err != nil {
+			continue
+		}
+		pages, err := a.Pages(t)
+		if err != nil {
+			return days, 0, fmt.Errorf("Archive.Prune() failed: %w", err)
+		}
+		for _, p := range pages {
+			referenced[p.Hash] = true
+		}
+	}
+
+	if days == 0 {
+		return 0, 0, nil
+	}
+
+	bodies := 0
+	err = filepath.WalkDir(a.objectsDir(), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		hash, ok := strings.CutSuffix(d.Name(), ".html.gz")
+		if !ok || referenced[hash] {
+			return nil
+		}
+		bodies++
+		return os.Remove(path)
+	})
+	if err != nil {
+		return days, bodies, fmt.Errorf("Archive.Prune() failed: %w", err)
+	}
+	return days, bodies, nil
+}
+
+// archiveTransport archives the body of every page fetched.
+type archiveTransport struct {
+	archive *Archive
+	next    http.RoundTripper
+}
+
+func (t *archiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if _, err := t.archive.Put(req.URL.String(), body, t.archive.now()); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// replayArchive serves the pages of one day of the archive, by canonical
+// url, without any network.
+type replayArchive struct {
+	archive *Archive
+	pages   map[string]ArchivedPage
+}
+
+func (t *replayArchive) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, ok := t.pages[cacheKey(req.URL)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotArchived, req.URL)
+	}
+	body, err := t.archive.Get(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	e := &cacheEntry{Url: p.Url, Header: http.Header{"Content-Type": {"text/html; charset=utf-8"}}, Body: body}
+	return e.response(req, cacheHit), nil
+}
+
+// Reparse runs the current extractors over the pages archived on day,
+// returning the records scraped from them. Pages fetched more than once on
+// day are parsed as last fetched.
+func (a *Archive) Reparse(ctx context.Context, day time.Time) (records.Records, error) {
+	pages, err := a.Pages(day)
+	if err != nil {
+		return nil, fmt.Errorf("Archive.Reparse() reading %s failed: %w", day.Format(time.DateOnly), err)
+	}
+	byKey := make(map[string]ArchivedPage)
+	var urls []string
+	for _, p := range pages {
+		if _, ok := byKey[p.Key]; !ok {
+			// only product pages are scraped, offer listings are fetched
+			// from them.
+			if _, _, err := records.ParseProductURL(p.Key); err == nil {
+				urls = append(urls, p.Key)
+			}
+		}
+		byKey[p.Key] = p
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	f := &collectorFactory{transport: &replayArchive{archive: a, pages: byKey}}
+	return GetRecords(withFactory(ctx, f), urls), nil
+}
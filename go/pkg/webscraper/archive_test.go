@@ -0,0 +1,100 @@
+package webscraper
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const productPageFixture = `<html><body>
+<div id="centerCol">
+	<span id="productTitle">What Kinda Music</span>
+	<a class="a-link-normal" href="/Tom-Misch/e/B01">Tom Misch </a>
+	<a class="a-link-normal" href="#reviews">12 ratings</a>
+	<span class="a-offscreen">£22.50</span>
+</div>
+</body></html>`
+
+func TestArchive(t *testing.T) {
+	a, err := NewArchive(ArchiveConfig{Dir: t.TempDir(), RetentionDays: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 10, 9, 0, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+
+	old := now.AddDate(0, 0, -3)
+	a.Put("https://www.amazon.co.uk/dp/B000002UAL", []byte("<html>gone</html>"), old)
+	a.Put("https://www.amazon.co.uk/dp/B084P38346", []byte("<html>same</html>"), old)
+	a.Put("https://www.amazon.co.uk/What-Kinda-Music/dp/B084P38346?ref=sr_1", []byte("<html>same</html>"), now)
+
+	pages, err := a.Pages(now)
+	if err != nil || len(pages) != 1 {
+		t.Fatalf("Pages() = %+v, %v, Expected: one page", pages, err)
+	}
+	if pages[0].Key != "https://www.amazon.co.uk/dp/B084P38346" {
+		t.Errorf("Pages() key = %s, Expected: the canonical url", pages[0].Key)
+	}
+	body, err := a.Get(pages[0].Hash)
+	if err != nil || string(body) != "<html>same</html>" {
+		t.Errorf("Get() = %q, %v", body, err)
+	}
+	if objects(t, a) != 2 {
+		t.Errorf("archive holds %d bodies, Expected: identical bodies stored once", objects(t, a))
+	}
+
+	days, bodies, err := a.Prune()
+	if err != nil || days != 1 || bodies != 1 {
+		t.Errorf("Prune() = %d, %d, %v, Expected: 1 day and 1 body removed", days, bodies, err)
+	}
+	if pages, _ := a.Pages(old); len(pages) != 0 {
+		t.Errorf("Pages() of a pruned day = %+v, Expected: none", pages)
+	}
+	if _, err := a.Get(pages[0].Hash); err != nil {
+		t.Errorf("Get() of a body still referenced failed: %s", err)
+	}
+}
+
+func objects(t *testing.T, a *Archive) int {
+	t.Helper()
+	n := 0
+	filepath.WalkDir(a.objectsDir(), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	return n
+}
+
+func TestArchiveReparse(t *testing.T) {
+	srv, transport := siteStandIn(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dp/B084P38346" {
+			w.Write([]byte(productPageFixture))
+		}
+	})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	f, err := newCollectorFactory(Config{Archive: ArchiveConfig{Dir: dir}}, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := GetRecords(withFactory(context.Background(), f), []string{"http://www.amazon.test/dp/B084P38346"})
+	if len(rs) != 1 {
+		t.Fatalf("GetRecords() = %v, Expected: one record", rs)
+	}
+
+	// re-parsing never reaches the retailer.
+	srv.Close()
+	rs, err = f.archive.Reparse(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("Reparse() failed: %s", err)
+	}
+	if len(rs) != 1 || rs[0].GetAlbum() != "What Kinda Music" || rs[0].GetPrice() != 22.5 {
+		t.Errorf("Reparse() = %v, Expected: What Kinda Music at 22.50", rs)
+	}
+}
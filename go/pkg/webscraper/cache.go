@@ -0,0 +1,245 @@
+package webscraper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNotCached is returned in replay mode for pages missing from the cache.
+var ErrNotCached = errors.New("page not cached")
+
+// defaultCacheTTL is how long cached pages are served without revalidating.
+const defaultCacheTTL = time.Hour
+
+// cache results recorded against cacheRequests, and set as the X-Cache header
+// of responses served from the cache.
+const (
+	cacheHit         = "hit"
+	cacheRevalidated = "revalidated"
+	cacheMiss        = "miss"
+)
+
+var cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "webscraper_cache_requests_total",
+	Help: "Number of page requests by response cache result.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(cacheRequests)
+}
+
+// CacheConfig configures the response cache, pages are not cached if it has
+// no dir.
+type CacheConfig struct {
+	Dir string `json:"dir"`
+	// TTL is how long a cached page is served before it is revalidated.
+	TTL duration `json:"ttl"`
+	// ReplayOnly serves every page from the cache, however old, and fails
+	// those not cached rather than fetching them.
+	ReplayOnly bool `json:"replay_only"`
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	Url      string      `json:"url"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+// response returns a copy of the cached response to req.
+func (e *cacheEntry) response(req *http.Request, result string) *http.Response {
+	h := e.Header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set("X-Cache", result)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// ResponseCache is an http.RoundTripper caching successful GET responses on
+// disk, keyed by the canonical url of the page. Pages are served from the
+// cache for its TTL, then revalidated with a conditional request if they
+// carry an ETag or Last-Modified header.
+type ResponseCache struct {
+	dir    string
+	ttl    time.Duration
+	replay bool
+	next   http.RoundTripper
+	now    func() time.Time
+}
+
+// NewResponseCache creates the response cache described by cfg, fetching
+// pages not cached through next.
+func NewResponseCache(cfg CacheConfig, next http.RoundTripper) (*ResponseCache, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("NewResponseCache() no cache dir")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("NewResponseCache() creating %s failed: %w", cfg.Dir, err)
+	}
+	c := &ResponseCache{
+		dir:    cfg.Dir,
+		ttl:    time.Duration(cfg.TTL),
+		replay: cfg.ReplayOnly,
+		next:   next,
+		now:    time.Now,
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultCacheTTL
+	}
+	return c, nil
+}
+
+// cacheKey is the canonical form of u, so that urls of the same product
+// share an entry.
+func cacheKey(u *url.URL) string {
+	if _, canonical, err := records.ParseProductURL(u.String()); err == nil {
+		return canonical
+	}
+	return u.String()
+}
+
+func (c *ResponseCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns the cached response of key, nil if it is not cached.
+func (c *ResponseCache) load(key string) (*cacheEntry, error) {
+	b, err := os.ReadFile(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("reading cached %s failed: %w", key, err)
+	}
+	return &e, nil
+}
+
+// store writes e to the cache, replacing the file so that readers never see
+// a partial entry.
+func (c *ResponseCache) store(key string, e *cacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(key), b)
+}
+
+// writeFileAtomic writes b to filename through a temporary file in the same
+// directory which replaces it, so that readers and crashes never see it
+// partially written. The file is only readable by its owner.
+func writeFileAtomic(filename string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// RoundTrip serves req from the cache if it holds a fresh copy of the page,
+// fetching or revalidating it otherwise.
+func (c *ResponseCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.next.RoundTrip(req)
+	}
+	key := cacheKey(req.URL)
+	e, err := c.load(key)
+	if err != nil {
+		return nil, fmt.Errorf("ResponseCache: %w", err)
+	}
+
+	if c.replay {
+		if e == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotCached, key)
+		}
+		cacheRequests.WithLabelValues(cacheHit).Inc()
+		return e.response(req, cacheHit), nil
+	}
+	if e != nil && c.now().Sub(e.StoredAt) < c.ttl {
+		cacheRequests.WithLabelValues(cacheHit).Inc()
+		return e.response(req, cacheHit), nil
+	}
+
+	if e != nil {
+		etag, modified := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			req = req.Clone(req.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				req.Header.Set("If-Modified-Since", modified)
+			}
+		}
+	}
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && e != nil:
+		resp.Body.Close()
+		e.StoredAt = c.now()
+		if err := c.store(key, e); err != nil {
+			return nil, fmt.Errorf("ResponseCache: caching %s failed: %w", key, err)
+		}
+		cacheRequests.WithLabelValues(cacheRevalidated).Inc()
+		return e.response(req, cacheRevalidated), nil
+	case resp.StatusCode == http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		h := resp.Header.Clone()
+		h.Del("Set-Cookie")
+		h.Del("Content-Length")
+		e := &cacheEntry{Url: req.URL.String(), Header: h, Body: body, StoredAt: c.now()}
+		if err := c.store(key, e); err != nil {
+			return nil, fmt.Errorf("ResponseCache: caching %s failed: %w", key, err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("X-Cache", cacheMiss)
+	}
+	cacheRequests.WithLabelValues(cacheMiss).Inc()
+	return resp, nil
+}
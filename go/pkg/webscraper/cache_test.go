@@ -0,0 +1,120 @@
+package webscraper
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	var mu sync.Mutex
+	var conditional []string
+	pages := 0
+	srv, transport := siteStandIn(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		pages++
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			conditional = append(conditional, inm)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("<html>" + r.URL.Path + "</html>"))
+	})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c, err := NewResponseCache(CacheConfig{Dir: dir, TTL: duration(time.Hour)}, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	fetch := func(rt http.RoundTripper, u string) (string, string, error) {
+		req, _ := http.NewRequest("GET", u, nil)
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b), resp.Header.Get("X-Cache"), nil
+	}
+
+	tests := []struct {
+		url, body, result string
+		after             time.Duration
+	}{
+		{"http://www.amazon.test/Not-Waving/dp/B07NN37WH3/ref=sr_1?psc=1", "<html>/Not-Waving/dp/B07NN37WH3/ref=sr_1</html>", cacheMiss, 0},
+		// the canonical url of the product shares its entry.
+		{"http://www.amazon.test/dp/B07NN37WH3", "<html>/Not-Waving/dp/B07NN37WH3/ref=sr_1</html>", cacheHit, 0},
+		{"http://www.amazon.test/dp/B07NN37WH3", "<html>/Not-Waving/dp/B07NN37WH3/ref=sr_1</html>", cacheRevalidated, 2 * time.Hour},
+		{"http://www.amazon.test/dp/B07NN37WH3", "<html>/Not-Waving/dp/B07NN37WH3/ref=sr_1</html>", cacheHit, 30 * time.Minute},
+		{"http://www.amazon.test/gp/aod/ajax?asin=B07NN37WH3&pc=dp", "<html>/gp/aod/ajax</html>", cacheMiss, 0},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.after)
+		body, result, err := fetch(c, tt.url)
+		if err != nil {
+			t.Fatalf("RoundTrip(%s) failed: %s", tt.url, err)
+		}
+		if body != tt.body || result != tt.result {
+			t.Errorf("RoundTrip(%s) = %q, %s, Expected: %q, %s", tt.url, body, result, tt.body, tt.result)
+		}
+	}
+	if pages != 3 || len(conditional) != 1 || conditional[0] != `"v1"` {
+		t.Errorf("origin served %d requests, conditional %v, Expected: 3 with one If-None-Match", pages, conditional)
+	}
+
+	// replaying never reaches the origin, however old the cached page.
+	srv.Close()
+	replay, _ := NewResponseCache(CacheConfig{Dir: dir, ReplayOnly: true}, transport)
+	replay.now = func() time.Time { return now.Add(48 * time.Hour) }
+	if _, result, err := fetch(replay, "https://www.amazon.test/dp/B07NN37WH3?tag=affiliate-21"); err != nil || result != cacheHit {
+		t.Errorf("replayed RoundTrip() = %s, %v, Expected: a cache hit", result, err)
+	}
+	if _, _, err := fetch(replay, "http://www.amazon.test/dp/B000002UAL"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("replayed RoundTrip() of an uncached page = %v, Expected: %v", err, ErrNotCached)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct{ url, expected string }{
+		{"https://www.amazon.co.uk/Not-Waving/dp/B07NN37WH3/ref=sr_1?psc=1", "https://www.amazon.co.uk/dp/B07NN37WH3"},
+		{"https://www.roughtrade.com/product/1?utm_source=mail", "https://www.roughtrade.com/product/1"},
+		{"https://www.amazon.co.uk/gp/aod/ajax?asin=B07NN37WH3&pc=dp", "https://www.amazon.co.uk/gp/aod/ajax?asin=B07NN37WH3&pc=dp"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := cacheKey(u); got != tt.expected {
+			t.Errorf("cacheKey(%s) = %s, Expected: %s", tt.url, got, tt.expected)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "state.json")
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(filename, []byte(content)); err != nil {
+			t.Fatalf("writeFileAtomic() failed: %s", err)
+		}
+		if b, _ := os.ReadFile(filename); string(b) != content {
+			t.Errorf("writeFileAtomic() wrote %q, Expected: %q", b, content)
+		}
+	}
+	if fi, _ := os.Stat(filename); fi.Mode().Perm() != 0o600 {
+		t.Errorf("writeFileAtomic() mode = %s, Expected: -rw-------", fi.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("writeFileAtomic() left %d files, Expected: 1", len(entries))
+	}
+}
@@ -0,0 +1,408 @@
+package webscraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/gocolly/colly"
+)
+
+// defaultIdentity is the key of the identities used for retailers without
+// their own.
+const defaultIdentity = "default"
+
+// Config configures how the collectors created for each scrape fetch pages.
+type Config struct {
+	Proxies ProxyConfig `json:"proxies"`
+	// Identities are the user agents and languages presented to each
+	// retailer, keyed by retailer or "default".
+	Identities map[string]IdentityConfig `json:"identities"`
+	// CookieFile persists the cookies retailers set between runs.
+	CookieFile string `json:"cookie_file"`
+	// Crawl is the robots.txt and request rate policy retailers are crawled
+	// under.
+	Crawl   CrawlConfig   `json:"crawl"`
+	Cache   CacheConfig   `json:"cache"`
+	Archive ArchiveConfig `json:"archive"`
+	Health  HealthConfig  `json:"health"`
+}
+
+// ProxyConfig configures the proxy pool requests are sent through, requests
+// are sent directly if it has no urls.
+type ProxyConfig struct {
+	Urls        []string      `json:"urls"`
+	Strategy    ProxyStrategy `json:"strategy"`
+	MaxFailures int           `json:"max_failures"`
+	Cooldown    duration      `json:"cooldown"`
+}
+
+// IdentityConfig are the User-Agent and Accept-Language headers rotated
+// through on each request.
+type IdentityConfig struct {
+	UserAgents      []string `json:"user_agents"`
+	AcceptLanguages []string `json:"accept_languages"`
+}
+
+// duration is a time.Duration written in json as a string, e.g. "5m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+// LoadConfig reads the collector config from a json file, a missing file is
+// the default config.
+func LoadConfig(filename string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("LoadConfig() reading %s failed: %w", filename, err)
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("LoadConfig() parsing %s failed: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// identities rotates the headers presented to each retailer.
+type identities struct {
+	cfg map[string]IdentityConfig
+
+	mu   sync.Mutex
+	next map[string]int
+}
+
+// headers returns the next User-Agent and Accept-Language for a host, empty
+// if none are configured.
+func (i *identities) headers(host string) (string, string) {
+	key := records.RetailerOf(host)
+	cfg, ok := i.cfg[key]
+	if !ok {
+		key = defaultIdentity
+		cfg = i.cfg[key]
+	}
+
+	i.mu.Lock()
+	n := i.next[key]
+	i.next[key]++
+	i.mu.Unlock()
+
+	var ua, lang string
+	if len(cfg.UserAgents) > 0 {
+		ua = cfg.UserAgents[n%len(cfg.UserAgents)]
+	}
+	if len(cfg.AcceptLanguages) > 0 {
+		lang = cfg.AcceptLanguages[n%len(cfg.AcceptLanguages)]
+	}
+	return ua, lang
+}
+
+// headerTransport sets the identity headers of each request, identifying as
+// userAgent to retailers without identities.
+type headerTransport struct {
+	ids       *identities
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ua, lang := t.ids.headers(req.URL.Hostname())
+	if ua == "" {
+		ua = t.userAgent
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", ua)
+	if lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// CookieJar is a cookie jar whose cookies can be saved to and loaded from a
+// file, so that sessions outlive the process.
+type CookieJar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]map[string]*http.Cookie // by host then name
+}
+
+// NewCookieJar creates an empty cookie jar.
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(nil)
+	return &CookieJar{jar: jar, cookies: make(map[string]map[string]*http.Cookie)}
+}
+
+// LoadCookieJar creates a cookie jar holding the unexpired cookies saved to
+// filename, a missing file gives an empty jar.
+func LoadCookieJar(filename string) (*CookieJar, error) {
+	j := NewCookieJar()
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoadCookieJar() reading %s failed: %w", filename, err)
+	}
+
+	var saved map[string][]*http.Cookie
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("LoadCookieJar() parsing %s failed: %w", filename, err)
+	}
+	for host, cookies := range saved {
+		j.SetCookies(&url.URL{Scheme: "https", Host: host, Path: "/"}, cookies)
+	}
+	return j, nil
+}
+
+// SetCookies records the cookies set by a response from u.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	host := u.Hostname()
+	if j.cookies[host] == nil {
+		j.cookies[host] = make(map[string]*http.Cookie)
+	}
+	for _, c := range cookies {
+		j.cookies[host][c.Name] = c
+	}
+}
+
+// Cookies returns the cookies to send in a request to u.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Save writes the unexpired cookies of the jar to filename, replacing the
+// file so that a crash never leaves it partially written.
+func (j *CookieJar) Save(filename string) error {
+	j.mu.Lock()
+	now := time.Now()
+	saved := make(map[string][]*http.Cookie)
+	for host, cookies := range j.cookies {
+		for _, c := range cookies {
+			if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+				continue
+			}
+			saved[host] = append(saved[host], c)
+		}
+	}
+	j.mu.Unlock()
+
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("CookieJar.Save() failed: %w", err)
+	}
+	if err := writeFileAtomic(filename, b); err != nil {
+		return fmt.Errorf("CookieJar.Save() writing %s failed: %w", filename, err)
+	}
+	return nil
+}
+
+// cookieTransport records the cookies set by each response in the jar, so
+// they are saved, as colly's collectors hold their own jar.
+type cookieTransport struct {
+	jar  *CookieJar
+	next http.RoundTripper
+}
+
+func (t *cookieTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		if cookies := resp.Cookies(); len(cookies) > 0 {
+			t.jar.SetCookies(req.URL, cookies)
+		}
+	}
+	return resp, err
+}
+
+// ctxTransport binds every outgoing request to ctx so that cancelling ctx
+// aborts any in-flight scrape.
+type ctxTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t *ctxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(req.WithContext(t.ctx))
+}
+
+// collectorFactory creates the collectors of each scrape, sharing a
+// transport, crawl policy and cookie jar between them.
+type collectorFactory struct {
+	transport  http.RoundTripper
+	proxies    *ProxyPool
+	policy     *CrawlPolicy
+	archive    *Archive
+	health     *HealthMonitor
+	jar        *CookieJar
+	cookieFile string
+}
+
+var (
+	factoryMu  sync.RWMutex
+	factory, _ = newCollectorFactory(Config{}, http.DefaultTransport)
+)
+
+// Configure sets how the collectors of all later scrapes fetch pages.
+func Configure(cfg Config) error {
+	f, err := newCollectorFactory(cfg, http.DefaultTransport)
+	if err != nil {
+		return err
+	}
+	factoryMu.Lock()
+	defer factoryMu.Unlock()
+	factory = f
+	return nil
+}
+
+// newCollectorFactory builds the transport described by cfg on top of base,
+// which sends requests not made through a proxy. The crawl policy fetches
+// robots.txt beneath the identity headers so always identifies as its own
+// user agent. Pages served from the response cache are neither checked
+// against the crawl policy nor fetched.
+func newCollectorFactory(cfg Config, base http.RoundTripper) (*collectorFactory, error) {
+	f := &collectorFactory{transport: base, cookieFile: cfg.CookieFile}
+	if len(cfg.Proxies.Urls) > 0 {
+		pool, err := NewProxyPool(cfg.Proxies.Urls, cfg.Proxies.Strategy, cfg.Proxies.MaxFailures, time.Duration(cfg.Proxies.Cooldown))
+		if err != nil {
+			return nil, err
+		}
+		f.proxies, f.transport = pool, pool
+	}
+	if cfg.CookieFile != "" {
+		jar, err := LoadCookieJar(cfg.CookieFile)
+		if err != nil {
+			return nil, err
+		}
+		f.jar = jar
+		f.transport = &cookieTransport{jar: jar, next: f.transport}
+	}
+	policy, err := NewCrawlPolicy(cfg.Crawl, f.transport)
+	if err != nil {
+		return nil, err
+	}
+	f.policy = policy
+	f.transport = &headerTransport{
+		ids:       &identities{cfg: cfg.Identities, next: make(map[string]int)},
+		userAgent: policy.UserAgent(),
+		next:      policy,
+	}
+	if cfg.Cache.Dir != "" {
+		cache, err := NewResponseCache(cfg.Cache, f.transport)
+		if err != nil {
+			return nil, err
+		}
+		f.transport = cache
+	}
+	// replayed pages were archived when first fetched.
+	if cfg.Archive.Dir != "" && !cfg.Cache.ReplayOnly {
+		archive, err := NewArchive(cfg.Archive)
+		if err != nil {
+			return nil, err
+		}
+		f.archive = archive
+		f.transport = &archiveTransport{archive: archive, next: f.transport}
+	}
+	health, err := NewHealthMonitor(cfg.Health)
+	if err != nil {
+		return nil, err
+	}
+	f.health = health
+	return f, nil
+}
+
+func currentFactory() *collectorFactory {
+	factoryMu.RLock()
+	defer factoryMu.RUnlock()
+	return factory
+}
+
+type factoryKey struct{}
+
+// withFactory returns a copy of ctx whose scrapes create their collectors
+// with f rather than the configured factory.
+func withFactory(ctx context.Context, f *collectorFactory) context.Context {
+	return context.WithValue(ctx, factoryKey{}, f)
+}
+
+// factoryFrom returns the collector factory of the scrapes of ctx.
+func factoryFrom(ctx context.Context) *collectorFactory {
+	if f, ok := ctx.Value(factoryKey{}).(*collectorFactory); ok {
+		return f
+	}
+	return currentFactory()
+}
+
+// newCollector creates a colly collector whose requests are cancelled when
+// ctx is done.
+func newCollector(ctx context.Context) *colly.Collector {
+	return factoryFrom(ctx).newCollector(ctx)
+}
+
+func (f *collectorFactory) newCollector(ctx context.Context) *colly.Collector {
+	c := colly.NewCollector()
+	c.WithTransport(&ctxTransport{ctx: ctx, next: f.transport})
+	if f.jar != nil {
+		c.SetCookieJar(f.jar.jar)
+	}
+	return c
+}
+
+// ProxiesHealth returns the health of each proxy requests are sent through,
+// nil if requests are not proxied.
+func ProxiesHealth() []ProxyHealth {
+	if p := currentFactory().proxies; p != nil {
+		return p.Health()
+	}
+	return nil
+}
+
+// CheckURL returns ErrDisallowed if the robots.txt of its host disallows
+// fetching the url raw, or ErrRobotsUnavailable if it could not be fetched.
+// Urls of retailers whose crawl policy is overridden are always allowed.
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	return currentFactory().policy.Check(ctx, u)
+}
+
+// SaveCookies saves the cookies of the configured cookie jar, if any.
+func SaveCookies() error {
+	return currentFactory().saveCookies()
+}
+
+func (f *collectorFactory) saveCookies() error {
+	if f.jar == nil {
+		return nil
+	}
+	return f.jar.Save(f.cookieFile)
+}
+
+// ConfiguredArchive returns the configured archive of scraped pages, nil if pages
+// are not archived.
+func ConfiguredArchive() *Archive {
+	return currentFactory().archive
+}
@@ -0,0 +1,122 @@
+package webscraper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollectorIdentities(t *testing.T) {
+	var mu sync.Mutex
+	var agents, langs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		agents = append(agents, r.Header.Get("User-Agent"))
+		langs = append(langs, r.Header.Get("Accept-Language"))
+	}))
+	defer srv.Close()
+
+	f, err := newCollectorFactory(Config{Identities: map[string]IdentityConfig{
+		"default": {UserAgents: []string{"agent-a", "agent-b"}, AcceptLanguages: []string{"en-GB"}},
+	}}, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/1", "/2", "/3"} {
+		if err := f.newCollector(context.Background()).Visit(srv.URL + path); err != nil {
+			t.Fatalf("Visit() failed: %s", err)
+		}
+	}
+
+	if want := []string{"agent-a", "agent-b", "agent-a"}; !reflect.DeepEqual(agents, want) {
+		t.Errorf("user agents = %v, want %v", agents, want)
+	}
+	if want := []string{"en-GB", "en-GB", "en-GB"}; !reflect.DeepEqual(langs, want) {
+		t.Errorf("accept languages = %v, want %v", langs, want)
+	}
+}
+
+func TestCollectorCrawlUserAgent(t *testing.T) {
+	var agent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			agent = r.Header.Get("User-Agent")
+		}
+	}))
+	defer srv.Close()
+
+	f, err := newCollectorFactory(Config{Crawl: CrawlConfig{UserAgent: "record-bot/2.0", Contact: "mailto:records@example.com"}}, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.newCollector(context.Background()).Visit(srv.URL); err != nil {
+		t.Fatalf("Visit() failed: %s", err)
+	}
+	if expected := "record-bot/2.0 (+mailto:records@example.com)"; agent != expected {
+		t.Errorf("user agent = %q, Expected: %q", agent, expected)
+	}
+}
+
+func TestCollectorProxyAndCookies(t *testing.T) {
+	p := newProxyStandIn(http.StatusOK)
+	defer p.Close()
+	cookieFile := filepath.Join(t.TempDir(), "cookies.json")
+
+	cfg := Config{Proxies: ProxyConfig{Urls: []string{p.URL}}, CookieFile: cookieFile}
+	f, err := newCollectorFactory(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.newCollector(context.Background()).Visit("http://www.amazon.test/dp/1"); err != nil {
+		t.Fatalf("Visit() through proxy failed: %s", err)
+	}
+	if p.requests() != 1 || p.hosts[0] != "www.amazon.test" {
+		t.Errorf("proxied hosts = %v, want www.amazon.test", p.hosts)
+	}
+	if err := f.jar.Save(cookieFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// the session cookie outlives the factory.
+	f, err = newCollectorFactory(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := f.jar.Cookies(&url.URL{Scheme: "http", Host: "www.amazon.test", Path: "/"})
+	if len(cookies) != 1 || cookies[0].Value != "abc" {
+		t.Errorf("loaded cookies = %v, want session=abc", cookies)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scraper.json")
+	os.WriteFile(filename, []byte(`{
+		"proxies": {"urls": ["socks5://10.0.0.2:1080"], "strategy": "sticky", "max_failures": 3, "cooldown": "5m"},
+		"identities": {"amazon": {"user_agents": ["agent-a"]}},
+		"cookie_file": "cookies.json"
+	}`), 0o644)
+
+	cfg, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %s", err)
+	}
+	if cfg.Proxies.Strategy != Sticky || time.Duration(cfg.Proxies.Cooldown) != 5*time.Minute ||
+		cfg.Identities["amazon"].UserAgents[0] != "agent-a" || cfg.CookieFile != "cookies.json" {
+		t.Errorf("LoadConfig() = %+v", cfg)
+	}
+
+	if cfg, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json")); err != nil || !reflect.DeepEqual(cfg, Config{}) {
+		t.Errorf("LoadConfig() of a missing file = %+v, %v, want the default config", cfg, err)
+	}
+}
@@ -0,0 +1,468 @@
+package webscraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaults of HealthConfig.
+const (
+	defaultHealthRuns     = 7
+	defaultHealthMaxDrop  = 0.2
+	defaultHealthMinPages = 3
+	defaultHealthMaxJump  = 0.9
+)
+
+// maxSuppressed is how many suppressed records are kept for review, the
+// oldest are dropped first.
+const maxSuppressed = 100
+
+// reasons the price of a scraped record is held for review rather than
+// written.
+const (
+	SuppressZeroPrice = "zero_price"
+	SuppressPriceJump = "price_jump"
+)
+
+// ErrNotSuppressed is returned when releasing a url with no suppressed
+// records.
+var ErrNotSuppressed = errors.New("no suppressed records")
+
+var (
+	fieldExtractions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webscraper_field_extractions_total",
+		Help: "Number of product pages parsed by retailer, field and whether the field was found.",
+	}, []string{"retailer", "field", "result"})
+	retailerDegraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webscraper_retailer_degraded",
+		Help: "Whether the fields of a retailer's pages are extracted less often than its baseline.",
+	}, []string{"retailer"})
+	suppressedRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webscraper_suppressed_records_total",
+		Help: "Number of scraped records whose price was held for review by retailer and reason.",
+	}, []string{"retailer", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(fieldExtractions, retailerDegraded, suppressedRecords)
+}
+
+// HealthConfig configures the monitoring of how reliably the fields of each
+// retailer's pages are extracted, and which scraped prices are held for
+// review as bogus.
+type HealthConfig struct {
+	// File persists the extraction history and last prices between restarts,
+	// they are kept in memory only if empty.
+	File string `json:"file"`
+	// Runs is how many past scrape runs a retailer's baseline success rate
+	// is averaged over, 7 if 0.
+	Runs int `json:"runs"`
+	// MaxDrop is the fraction a field's success rate may fall below its
+	// baseline before the retailer is degraded, 0.2 if 0.
+	MaxDrop float64 `json:"max_drop"`
+	// MinPages is how many of a retailer's pages a run must parse for its
+	// success rates to be judged, 3 if 0.
+	MinPages int `json:"min_pages"`
+	// MaxJump is the fraction a price may change from the last price of its
+	// product before it is held for review, 0.9 if 0.
+	MaxJump float64 `json:"max_jump"`
+}
+
+// healthCounts are the pages of a retailer parsed in a run, and how many of
+// them each field was found in.
+type healthCounts struct {
+	Pages int            `json:"pages"`
+	Found map[string]int `json:"found"`
+}
+
+func (c *healthCounts) rate(field string) float64 {
+	if c.Pages == 0 {
+		return 0
+	}
+	return float64(c.Found[field]) / float64(c.Pages)
+}
+
+// healthRun are the extraction counts of each retailer in a scrape run.
+type healthRun struct {
+	At        time.Time                `json:"at"`
+	Retailers map[string]*healthCounts `json:"retailers"`
+
+	monitor *HealthMonitor
+}
+
+// SuppressedRecord is a scraped record whose price was held for review as it
+// looks bogus. It is reported until its url is released.
+type SuppressedRecord struct {
+	Url       string    `json:"url"`
+	Retailer  string    `json:"retailer"`
+	Artist    string    `json:"artist"`
+	Album     string    `json:"album"`
+	Price     float32   `json:"price"`
+	LastPrice float32   `json:"last_price,omitempty"`
+	Reason    string    `json:"reason"`
+	At        time.Time `json:"at"`
+}
+
+// healthState is what the monitor persists.
+type healthState struct {
+	Runs []*healthRun `json:"runs"`
+	// LastPrices are the last accepted price of each product, by canonical
+	// url.
+	LastPrices map[string]float32 `json:"last_prices"`
+	Suppressed []SuppressedRecord `json:"suppressed"`
+}
+
+// HealthMonitor tracks the success rate of extracting each field of each
+// retailer's pages across scrape runs, flagging a retailer as degraded when
+// a field is found less often than its baseline, and holds for review the
+// prices of records which are zero or jump from the last price of the
+// product.
+type HealthMonitor struct {
+	cfg HealthConfig
+
+	mu       sync.Mutex
+	state    healthState
+	degraded map[string]bool
+	now      func() time.Time
+}
+
+// NewHealthMonitor creates the monitor described by cfg, loading its history
+// from cfg.File if it exists.
+func NewHealthMonitor(cfg HealthConfig) (*HealthMonitor, error) {
+	if cfg.Runs < 0 || cfg.MinPages < 0 || cfg.MaxDrop < 0 || cfg.MaxDrop >= 1 || cfg.MaxJump < 0 {
+		return nil, errors.New("NewHealthMonitor() runs, min_pages and max_jump must not be negative, max_drop must be in [0, 1)")
+	}
+	if cfg.Runs == 0 {
+		cfg.Runs = defaultHealthRuns
+	}
+	if cfg.MaxDrop == 0 {
+		cfg.MaxDrop = defaultHealthMaxDrop
+	}
+	if cfg.MinPages == 0 {
+		cfg.MinPages = defaultHealthMinPages
+	}
+	if cfg.MaxJump == 0 {
+		cfg.MaxJump = defaultHealthMaxJump
+	}
+	m := &HealthMonitor{
+		cfg:      cfg,
+		state:    healthState{LastPrices: make(map[string]float32)},
+		degraded: make(map[string]bool),
+		now:      time.Now,
+	}
+	if cfg.File == "" {
+		return m, nil
+	}
+	b, err := os.ReadFile(cfg.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("NewHealthMonitor() reading %s failed: %w", cfg.File, err)
+	}
+	if err := json.Unmarshal(b, &m.state); err != nil {
+		return nil, fmt.Errorf("NewHealthMonitor() parsing %s failed: %w", cfg.File, err)
+	}
+	if m.state.LastPrices == nil {
+		m.state.LastPrices = make(map[string]float32)
+	}
+	for retailer := range m.retailers() {
+		m.degraded[retailer] = m.retailerHealth(retailer).Degraded
+	}
+	return m, nil
+}
+
+// newRun starts collecting the extraction counts of a scrape run.
+func (m *HealthMonitor) newRun() *healthRun {
+	return &healthRun{At: m.now(), Retailers: make(map[string]*healthCounts), monitor: m}
+}
+
+// observe counts which required fields were extracted from a page of
+// retailer.
+func (r *healthRun) observe(retailer string, fields map[string]string) {
+	r.monitor.mu.Lock()
+	defer r.monitor.mu.Unlock()
+	c, ok := r.Retailers[retailer]
+	if !ok {
+		c = &healthCounts{Found: make(map[string]int)}
+		r.Retailers[retailer] = c
+	}
+	c.Pages++
+	for _, name := range requiredFields {
+		result := "found"
+		if fields[name] != "" {
+			c.Found[name]++
+		} else {
+			result = "missing"
+		}
+		fieldExtractions.WithLabelValues(retailer, name, result).Inc()
+	}
+}
+
+// finish adds a run to the history, logging each retailer which becomes
+// degraded or recovers, then saves the history.
+func (r *healthRun) finish(ctx context.Context) {
+	m := r.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(r.Retailers) == 0 {
+		return
+	}
+	m.state.Runs = append(m.state.Runs, r)
+	// a retailer's baseline is judged from its runs alone, so a few more
+	// runs than the baseline spans are kept for retailers not in every run.
+	if n := len(m.state.Runs) - 2*(m.cfg.Runs+1); n > 0 {
+		m.state.Runs = m.state.Runs[n:]
+	}
+	for retailer := range r.Retailers {
+		h := m.retailerHealth(retailer)
+		was := m.degraded[retailer]
+		m.degraded[retailer] = h.Degraded
+		if h.Degraded {
+			retailerDegraded.WithLabelValues(retailer).Set(1)
+		} else {
+			retailerDegraded.WithLabelValues(retailer).Set(0)
+		}
+		switch {
+		case h.Degraded && !was:
+			logging.FromContext(ctx).Error("retailer extraction degraded", "retailer", retailer, "fields", h.Fields)
+		case !h.Degraded && was:
+			logging.FromContext(ctx).Info("retailer extraction recovered", "retailer", retailer)
+		}
+	}
+	if err := m.save(); err != nil {
+		logging.FromContext(ctx).Error("saving extraction health failed", "path", m.cfg.File, "err", err)
+	}
+}
+
+// check reports whether the price of a record scraped from url may be
+// written, holding it for review and recording the record as suppressed if
+// not. The last price of url is updated by records which pass, and by those
+// released.
+func (m *HealthMonitor) check(ctx context.Context, url string, rec *records.Record) bool {
+	key := url
+	if _, canonical, err := records.ParseProductURL(url); err == nil {
+		key = canonical
+	}
+	price := rec.GetPrice()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last, seen := m.state.LastPrices[key]
+	reason := ""
+	switch {
+	case price <= 0:
+		reason = SuppressZeroPrice
+	case seen && last > 0 && math.Abs(float64(price-last))/float64(last) >= m.cfg.MaxJump:
+		reason = SuppressPriceJump
+	}
+	if reason == "" {
+		m.state.LastPrices[key] = price
+		return true
+	}
+
+	s := SuppressedRecord{
+		Url:       key,
+		Retailer:  retailerOf(url),
+		Artist:    rec.GetArtist(),
+		Album:     rec.GetAlbum(),
+		Price:     price,
+		LastPrice: last,
+		Reason:    reason,
+		At:        m.now(),
+	}
+	m.state.Suppressed = append(m.state.Suppressed, s)
+	if n := len(m.state.Suppressed) - maxSuppressed; n > 0 {
+		m.state.Suppressed = m.state.Suppressed[n:]
+	}
+	rec.Hold(reason)
+	suppressedRecords.WithLabelValues(s.Retailer, reason).Inc()
+	logging.FromContext(ctx).Warn("scraped price held for review",
+		"url", key, "reason", reason, "price", price, "last_price", last)
+	return false
+}
+
+// Release drops the suppressed records of url, taking the price of the last
+// as the last price of url unless it is zero, so that a product whose price
+// really moved is no longer held. ErrNotSuppressed is returned if url has no
+// suppressed records.
+func (m *HealthMonitor) Release(url string) error {
+	key := url
+	if _, canonical, err := records.ParseProductURL(url); err == nil {
+		key = canonical
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var kept []SuppressedRecord
+	var last *SuppressedRecord
+	for i, s := range m.state.Suppressed {
+		if s.Url == key {
+			last = &m.state.Suppressed[i]
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if last == nil {
+		return fmt.Errorf("Release() of %s failed: %w", key, ErrNotSuppressed)
+	}
+	if last.Price > 0 {
+		m.state.LastPrices[key] = last.Price
+	}
+	m.state.Suppressed = kept
+	if err := m.save(); err != nil {
+		return fmt.Errorf("Release() saving %s failed: %w", m.cfg.File, err)
+	}
+	return nil
+}
+
+// save writes the monitor's state to its file, replacing the file so that a
+// crash never leaves it partially written.
+func (m *HealthMonitor) save() error {
+	if m.cfg.File == "" {
+		return nil
+	}
+	b, err := json.Marshal(m.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.cfg.File, b)
+}
+
+// FieldHealth is how often a field of a retailer's pages was extracted in
+// its last run and on average over the runs before.
+type FieldHealth struct {
+	Field    string  `json:"field"`
+	Rate     float64 `json:"rate"`
+	Baseline float64 `json:"baseline"`
+	Degraded bool    `json:"degraded"`
+}
+
+// RetailerHealth is the extraction health of a retailer as of the last run
+// it was scraped in. Retailers are not judged until they have a baseline and
+// a last run of at least MinPages pages.
+type RetailerHealth struct {
+	Retailer string        `json:"retailer"`
+	LastRun  time.Time     `json:"last_run"`
+	Pages    int           `json:"pages"`
+	Baseline int           `json:"baseline_runs"`
+	Degraded bool          `json:"degraded"`
+	Fields   []FieldHealth `json:"fields"`
+}
+
+// HealthReport is the extraction health of every retailer and the records
+// whose price was held for review, oldest first.
+type HealthReport struct {
+	Retailers  []RetailerHealth   `json:"retailers"`
+	Suppressed []SuppressedRecord `json:"suppressed"`
+}
+
+// Report returns the extraction health of every retailer scraped in the
+// monitor's history.
+func (m *HealthMonitor) Report() HealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := HealthReport{
+		Retailers:  []RetailerHealth{},
+		Suppressed: append([]SuppressedRecord{}, m.state.Suppressed...),
+	}
+	for retailer := range m.retailers() {
+		report.Retailers = append(report.Retailers, m.retailerHealth(retailer))
+	}
+	sort.Slice(report.Retailers, func(i, j int) bool {
+		return report.Retailers[i].Retailer < report.Retailers[j].Retailer
+	})
+	return report
+}
+
+func (m *HealthMonitor) retailers() map[string]bool {
+	retailers := make(map[string]bool)
+	for _, r := range m.state.Runs {
+		for retailer := range r.Retailers {
+			retailers[retailer] = true
+		}
+	}
+	return retailers
+}
+
+// retailerHealth judges the last run of retailer against the average of the
+// Runs before it which parsed at least MinPages of its pages.
+func (m *HealthMonitor) retailerHealth(retailer string) RetailerHealth {
+	var runs []*healthRun
+	for _, r := range m.state.Runs {
+		if c, ok := r.Retailers[retailer]; ok && c.Pages > 0 {
+			runs = append(runs, r)
+		}
+	}
+	h := RetailerHealth{Retailer: retailer, Fields: []FieldHealth{}}
+	if len(runs) == 0 {
+		return h
+	}
+	last := runs[len(runs)-1]
+	current := last.Retailers[retailer]
+	h.LastRun, h.Pages = last.At, current.Pages
+
+	var baseline []*healthCounts
+	for i := len(runs) - 2; i >= 0 && len(baseline) < m.cfg.Runs; i-- {
+		if c := runs[i].Retailers[retailer]; c.Pages >= m.cfg.MinPages {
+			baseline = append(baseline, c)
+		}
+	}
+	h.Baseline = len(baseline)
+	judged := len(baseline) > 0 && current.Pages >= m.cfg.MinPages
+
+	for _, name := range requiredFields {
+		f := FieldHealth{Field: name, Rate: current.rate(name)}
+		for _, c := range baseline {
+			f.Baseline += c.rate(name) / float64(len(baseline))
+		}
+		f.Degraded = judged && f.Rate < f.Baseline*(1-m.cfg.MaxDrop)
+		h.Degraded = h.Degraded || f.Degraded
+		h.Fields = append(h.Fields, f)
+	}
+	return h
+}
+
+type healthRunKey struct{}
+
+// withHealthRun returns a copy of ctx whose scrapes count their extractions
+// against run.
+func withHealthRun(ctx context.Context, run *healthRun) context.Context {
+	return context.WithValue(ctx, healthRunKey{}, run)
+}
+
+// healthRunFrom returns the run the scrapes of ctx are counted against, nil
+// if they are not monitored.
+func healthRunFrom(ctx context.Context) *healthRun {
+	run, _ := ctx.Value(healthRunKey{}).(*healthRun)
+	return run
+}
+
+// Health returns the extraction health of the configured scrapes.
+func Health() HealthReport {
+	if m := currentFactory().health; m != nil {
+		return m.Report()
+	}
+	return HealthReport{Retailers: []RetailerHealth{}, Suppressed: []SuppressedRecord{}}
+}
+
+// ReleaseSuppressed releases the suppressed records of url, see
+// HealthMonitor.Release. ErrNotSuppressed is returned if url has none, or
+// health is not monitored.
+func ReleaseSuppressed(url string) error {
+	if m := currentFactory().health; m != nil {
+		return m.Release(url)
+	}
+	return ErrNotSuppressed
+}
@@ -0,0 +1,187 @@
+package webscraper
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scrapeRun counts a run of pages of retailer, the first found of which have
+// every field and the rest no price.
+func scrapeRun(m *HealthMonitor, retailer string, pages, found int) {
+	run := m.newRun()
+	for i := 0; i < pages; i++ {
+		fields := map[string]string{fieldAlbum: "Blue", fieldArtist: "Joni Mitchell", fieldPrice: "£25.00"}
+		if i >= found {
+			fields[fieldPrice] = ""
+		}
+		run.observe(retailer, fields)
+	}
+	run.finish(context.Background())
+}
+
+func TestHealthMonitorDegraded(t *testing.T) {
+	m, err := NewHealthMonitor(HealthConfig{Runs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := func() RetailerHealth {
+		t.Helper()
+		r := m.Report().Retailers
+		if len(r) != 1 {
+			t.Fatalf("Report() = %v, Expected: one retailer", r)
+		}
+		return r[0]
+	}
+
+	scrapeRun(m, "shop", 10, 10)
+	if h := health(); h.Degraded || h.Baseline != 0 {
+		t.Errorf("Report() = %+v, Expected: no baseline to judge against", h)
+	}
+	scrapeRun(m, "shop", 10, 9)
+	scrapeRun(m, "shop", 2, 0)
+	if h := health(); h.Degraded {
+		t.Errorf("Report() = %+v, Expected: too few pages to judge", h)
+	}
+
+	scrapeRun(m, "shop", 10, 2)
+	h := health()
+	if !h.Degraded || h.Baseline != 2 {
+		t.Fatalf("Report() = %+v, Expected: degraded against a baseline of 2 runs", h)
+	}
+	for _, f := range h.Fields {
+		if (f.Field == fieldPrice) != f.Degraded {
+			t.Errorf("Report() %s = %+v, Expected: only the price degraded", f.Field, f)
+		}
+		if f.Field == fieldPrice && (f.Rate != 0.2 || f.Baseline != 0.95) {
+			t.Errorf("Report() price = %+v, Expected: rate 0.2 against 0.95", f)
+		}
+	}
+	if testutil.ToFloat64(retailerDegraded.WithLabelValues("shop")) != 1 {
+		t.Errorf("webscraper_retailer_degraded{shop} = %v, Expected: 1", testutil.ToFloat64(retailerDegraded.WithLabelValues("shop")))
+	}
+
+	scrapeRun(m, "shop", 10, 10)
+	if h := health(); h.Degraded || testutil.ToFloat64(retailerDegraded.WithLabelValues("shop")) != 0 {
+		t.Errorf("Report() = %+v, Expected: recovered", h)
+	}
+}
+
+func TestHealthMonitorCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "health.json")
+	m, err := NewHealthMonitor(HealthConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	url := "https://www.amazon.co.uk/dp/B084P38346?ref=sr_1"
+	rec := func(price float32) *records.Record {
+		return records.NewRecord("Tom Misch", "What Kinda Music", url, price)
+	}
+
+	tests := []struct {
+		name     string
+		price    float32
+		expected bool
+	}{
+		{"first price", 22.5, true},
+		{"zero price", 0, false},
+		{"small change", 20, true},
+		{"jump", 199.99, false},
+		{"drop", 1.5, false},
+		{"change from the last accepted price", 24, true},
+	}
+	for _, tt := range tests {
+		r := rec(tt.price)
+		if got := m.check(ctx, url, r); got != tt.expected {
+			t.Errorf("check() %s = %v, Expected: %v", tt.name, got, tt.expected)
+		}
+		// the record is still written, only its price is held.
+		if held := len(r.GetHeld()) > 0; held == tt.expected {
+			t.Errorf("check() %s held = %v, Expected: %v", tt.name, r.GetHeld(), !tt.expected)
+		}
+	}
+
+	s := m.Report().Suppressed
+	if len(s) != 3 {
+		t.Fatalf("Report() suppressed = %v, Expected: 3 records", s)
+	}
+	if s[1].Reason != SuppressPriceJump || s[1].LastPrice != 20 || s[1].Url != "https://www.amazon.co.uk/dp/B084P38346" {
+		t.Errorf("Report() suppressed = %+v, Expected: a price jump from 20 by canonical url", s[1])
+	}
+
+	// the last prices and suppressed records survive a restart once a run
+	// is saved.
+	scrapeRun(m, retailerAmazon, 1, 1)
+	m, err = NewHealthMonitor(HealthConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Report().Suppressed) != 3 || m.check(ctx, url, rec(2.4)) {
+		t.Errorf("NewHealthMonitor() = %+v, Expected: state loaded from %s", m.Report(), file)
+	}
+}
+
+func TestHealthMonitorRelease(t *testing.T) {
+	m, err := NewHealthMonitor(HealthConfig{File: filepath.Join(t.TempDir(), "health.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	url := "https://www.amazon.co.uk/dp/B084P38346"
+	other := "https://www.amazon.co.uk/dp/B07BKSN5L8"
+	for _, u := range []string{url, other} {
+		m.check(ctx, u, records.NewRecord("Tom Misch", "What Kinda Music", u, 20))
+	}
+
+	// a real change in price is held until the url is released, after which
+	// it is the last price.
+	for i := 0; i < 2; i++ {
+		if m.check(ctx, url, records.NewRecord("Tom Misch", "What Kinda Music", url, 45)) {
+			t.Errorf("check() of a jump before release, Expected: held")
+		}
+	}
+	m.check(ctx, other, records.NewRecord("Tom Misch", "What Kinda Music", other, 0))
+	if err := m.Release(url + "?ref=sr_1"); err != nil {
+		t.Fatalf("Release() failed: %s", err)
+	}
+	if s := m.Report().Suppressed; len(s) != 1 || s[0].Url != other {
+		t.Errorf("Report() suppressed after release = %+v, Expected: only %s", s, other)
+	}
+	if !m.check(ctx, url, records.NewRecord("Tom Misch", "What Kinda Music", url, 45)) {
+		t.Errorf("check() of the released price, Expected: accepted")
+	}
+
+	// a zero price released leaves the last price as it was.
+	if err := m.Release(other); err != nil {
+		t.Fatalf("Release() failed: %s", err)
+	}
+	if !m.check(ctx, other, records.NewRecord("Tom Misch", "What Kinda Music", other, 21)) {
+		t.Errorf("check() after releasing a zero price, Expected: judged against 20")
+	}
+	if err := m.Release(other); !errors.Is(err, ErrNotSuppressed) {
+		t.Errorf("Release() of a url with nothing suppressed = %v, Expected: %v", err, ErrNotSuppressed)
+	}
+}
+
+func TestHealthMonitorRetention(t *testing.T) {
+	m, err := NewHealthMonitor(HealthConfig{Runs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.now = func() time.Time { return time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC) }
+	for i := 0; i < 10; i++ {
+		scrapeRun(m, "shop", 3, 3)
+	}
+	if len(m.state.Runs) != 6 {
+		t.Errorf("runs kept = %d, Expected: 6", len(m.state.Runs))
+	}
+	if _, err := NewHealthMonitor(HealthConfig{MaxDrop: 1}); err == nil {
+		t.Errorf("NewHealthMonitor() max_drop 1 = nil, Expected: an error")
+	}
+}
@@ -0,0 +1,279 @@
+package webscraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/1602077/webscraper/go/pkg/records"
+	"github.com/temoto/robotstxt"
+)
+
+var (
+	// ErrDisallowed is returned for urls the robots.txt of their host
+	// disallows us from fetching.
+	ErrDisallowed = errors.New("disallowed by robots.txt")
+	// ErrRobotsUnavailable is returned for urls whose host's robots.txt could
+	// not be fetched, which are not fetched either.
+	ErrRobotsUnavailable = errors.New("robots.txt unavailable")
+)
+
+// crawl policy defaults.
+const (
+	defaultUserAgent = "vinyl-webscraper/1.0"
+	defaultContact   = "https://github.com/1602077/vinyl_webscraper-go"
+	defaultRobotsTTL = 24 * time.Hour
+	// unavailable robots.txt are retried sooner than those fetched.
+	unavailableRobotsTTL = 10 * time.Minute
+	// maxRobotsSize is the most of a robots.txt read, as RFC 9309 requires
+	// at least 500 KiB to be.
+	maxRobotsSize = 500 << 10
+)
+
+// CrawlConfig configures how politely retailers are crawled.
+type CrawlConfig struct {
+	// UserAgent is the product token and version we identify as and match
+	// robots.txt groups by, e.g. "vinyl-webscraper/1.0".
+	UserAgent string `json:"user_agent"`
+	// Contact is a url or email address added to the user agent for site
+	// owners to reach us by.
+	Contact string `json:"contact"`
+	// Delay is the least time between requests to a host, robots.txt may
+	// ask for longer with Crawl-delay.
+	Delay duration `json:"delay"`
+	// RobotsTTL is how long a host's robots.txt is cached for.
+	RobotsTTL duration `json:"robots_ttl"`
+	// Overrides relax the policy for a retailer, each must record why.
+	Overrides map[string]CrawlOverride `json:"overrides"`
+}
+
+// CrawlOverride relaxes the crawl policy of a retailer.
+type CrawlOverride struct {
+	// IgnoreRobots fetches urls robots.txt disallows, logging a warning.
+	IgnoreRobots bool `json:"ignore_robots"`
+	// Delay replaces the delay between requests to the retailer's hosts.
+	Delay duration `json:"delay"`
+	// Reason records why the retailer is overridden.
+	Reason string `json:"reason"`
+}
+
+// robotsEntry is the cached robots.txt of a host.
+type robotsEntry struct {
+	mu      sync.Mutex
+	robots  *robotstxt.RobotsData
+	err     error
+	expires time.Time
+}
+
+// CrawlPolicy is an http.RoundTripper refusing requests the robots.txt of
+// their host disallows and spacing requests to each host by its crawl delay.
+// robots.txt is fetched through the next transport and cached per host.
+type CrawlPolicy struct {
+	userAgent string
+	token     string
+	delay     time.Duration
+	ttl       time.Duration
+	overrides map[string]CrawlOverride
+	client    *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]*robotsEntry
+	slots map[string]time.Time // earliest time of the next request by host
+	now   func() time.Time
+}
+
+// NewCrawlPolicy creates the crawl policy described by cfg, fetching pages
+// and robots.txt through next.
+func NewCrawlPolicy(cfg CrawlConfig, next http.RoundTripper) (*CrawlPolicy, error) {
+	p := &CrawlPolicy{
+		userAgent: cfg.UserAgent,
+		delay:     time.Duration(cfg.Delay),
+		ttl:       time.Duration(cfg.RobotsTTL),
+		overrides: cfg.Overrides,
+		client:    &http.Client{Transport: next},
+		hosts:     make(map[string]*robotsEntry),
+		slots:     make(map[string]time.Time),
+		now:       time.Now,
+	}
+	if p.userAgent == "" {
+		p.userAgent = defaultUserAgent
+	}
+	p.token, _, _ = strings.Cut(p.userAgent, "/")
+	contact := cfg.Contact
+	if contact == "" {
+		contact = defaultContact
+	}
+	p.userAgent = fmt.Sprintf("%s (+%s)", p.userAgent, contact)
+	if p.ttl <= 0 {
+		p.ttl = defaultRobotsTTL
+	}
+	for retailer, o := range cfg.Overrides {
+		if strings.TrimSpace(o.Reason) == "" {
+			return nil, fmt.Errorf("NewCrawlPolicy() override of %s must record a reason", retailer)
+		}
+	}
+	return p, nil
+}
+
+// UserAgent is the user agent the policy identifies us by.
+func (p *CrawlPolicy) UserAgent() string {
+	return p.userAgent
+}
+
+// robots returns the robots.txt of the host of u, fetching it if it is not
+// cached or has expired.
+func (p *CrawlPolicy) robots(ctx context.Context, u *url.URL) (*robotstxt.RobotsData, error) {
+	origin := u.Scheme + "://" + u.Host
+	p.mu.Lock()
+	e, ok := p.hosts[origin]
+	if !ok {
+		e = &robotsEntry{}
+		p.hosts[origin] = e
+	}
+	p.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if p.now().Before(e.expires) {
+		return e.robots, e.err
+	}
+
+	rb, err := p.fetchRobots(ctx, origin)
+	// a cancelled fetch says nothing of the host so is not cached.
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	ttl := p.ttl
+	if err != nil && ttl > unavailableRobotsTTL {
+		ttl = unavailableRobotsTTL
+	}
+	e.robots, e.err, e.expires = rb, err, p.now().Add(ttl)
+	return rb, err
+}
+
+// fetchRobots fetches the robots.txt of origin. A missing robots.txt allows
+// everything, while one which cannot be fetched or parsed disallows
+// everything.
+func (p *CrawlPolicy) fetchRobots(ctx context.Context, origin string) (*robotstxt.RobotsData, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRobotsUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrRobotsUnavailable, err)
+		}
+		rb, err := robotstxt.FromBytes(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s/robots.txt %s", ErrRobotsUnavailable, origin, err)
+		}
+		return rb, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return robotstxt.FromStatusAndBytes(resp.StatusCode, nil)
+	default:
+		return nil, fmt.Errorf("%w: %s/robots.txt returned %d", ErrRobotsUnavailable, origin, resp.StatusCode)
+	}
+}
+
+// check returns the robots.txt group u is fetched under, or an error if u
+// may not be fetched. Urls of an overridden retailer are always fetched.
+func (p *CrawlPolicy) check(ctx context.Context, u *url.URL) (*robotstxt.Group, error) {
+	retailer := records.RetailerOf(u.Hostname())
+	override := p.overrides[retailer]
+
+	rb, err := p.robots(ctx, u)
+	if err != nil {
+		if override.IgnoreRobots && ctx.Err() == nil {
+			logging.FromContext(ctx).Warn("robots.txt unavailable, fetching under override",
+				"url", u.String(), "retailer", retailer, "reason", override.Reason, "err", err)
+			return &robotstxt.Group{}, nil
+		}
+		return nil, err
+	}
+
+	g := rb.FindGroup(p.token)
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if g.Test(path) {
+		return g, nil
+	}
+	if override.IgnoreRobots {
+		logging.FromContext(ctx).Warn("robots.txt disallows url, fetching under override",
+			"url", u.String(), "retailer", retailer, "reason", override.Reason)
+		return g, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDisallowed, u)
+}
+
+// Check returns ErrDisallowed if the robots.txt of the host of u disallows
+// fetching it, or ErrRobotsUnavailable if it could not be fetched.
+func (p *CrawlPolicy) Check(ctx context.Context, u *url.URL) error {
+	_, err := p.check(ctx, u)
+	return err
+}
+
+// wait blocks until the next request to host may be sent, d after the last.
+func (p *CrawlPolicy) wait(ctx context.Context, host string, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	now := p.now()
+	at := p.slots[host]
+	if at.Before(now) {
+		at = now
+	}
+	p.slots[host] = at.Add(d)
+	p.mu.Unlock()
+
+	if at.Equal(now) {
+		return nil
+	}
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RoundTrip sends req once the crawl policy allows it.
+func (p *CrawlPolicy) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path != "/robots.txt" {
+		g, err := p.check(req.Context(), req.URL)
+		if err != nil {
+			return nil, err
+		}
+		delay := p.delay
+		if g.CrawlDelay > delay {
+			delay = g.CrawlDelay
+		}
+		if o, ok := p.overrides[records.RetailerOf(req.URL.Hostname())]; ok && o.Delay > 0 {
+			delay = time.Duration(o.Delay)
+		}
+		if err := p.wait(req.Context(), req.URL.Host, delay); err != nil {
+			return nil, err
+		}
+	}
+	return p.client.Transport.RoundTrip(req)
+}
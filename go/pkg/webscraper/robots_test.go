@@ -0,0 +1,170 @@
+package webscraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+const robotsFixture = `# robots.txt
+User-agent: *
+Disallow: /gp/
+Allow: /gp/aod/
+Disallow: /*?ref=
+Disallow: /*.pdf$
+
+User-agent: vinyl-webscraper
+User-agent: another-bot
+Disallow: /private
+Crawl-delay: 0.1
+
+Sitemap: https://www.amazon.test/sitemap.xml
+`
+
+// the rules of the fixture apply to each user agent token as the crawl
+// policy looks them up.
+func TestRobotsRules(t *testing.T) {
+	rb, err := robotstxt.FromString(robotsFixture)
+	if err != nil {
+		t.Fatalf("FromString() failed: %s", err)
+	}
+
+	tests := []struct {
+		token, path string
+		expected    bool
+	}{
+		{"other-bot", "/dp/B07BKSN5L8", true},
+		{"other-bot", "/gp/cart", false},
+		{"other-bot", "/gp/aod/ajax?asin=B07BKSN5L8", true},
+		{"other-bot", "/dp/B07BKSN5L8?ref=sr_1", false},
+		{"other-bot", "/manual.pdf", false},
+		{"other-bot", "/manual.pdf?page=2", true},
+		{"Vinyl-Webscraper", "/gp/cart", true},
+		{"vinyl-webscraper", "/private/wishlist", false},
+		{"another-bot", "/private", false},
+	}
+	for _, tt := range tests {
+		if got := rb.FindGroup(tt.token).Test(tt.path); got != tt.expected {
+			t.Errorf("Test(%s, %s) = %v, Expected: %v", tt.token, tt.path, got, tt.expected)
+		}
+	}
+
+	if d := rb.FindGroup("vinyl-webscraper").CrawlDelay; d != 100*time.Millisecond {
+		t.Errorf("crawl delay = %v, Expected: %v", d, 100*time.Millisecond)
+	}
+	if d := rb.FindGroup("other-bot").CrawlDelay; d != 0 {
+		t.Errorf("crawl delay = %v, Expected: 0", d)
+	}
+}
+
+// siteStandIn serves every host from handler, acting as the proxy requests
+// are sent through.
+func siteStandIn(handler http.HandlerFunc) (*httptest.Server, http.RoundTripper) {
+	srv := httptest.NewServer(handler)
+	u, _ := url.Parse(srv.URL)
+	return srv, &http.Transport{Proxy: http.ProxyURL(u)}
+}
+
+// roundTripFunc adapts a function to an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCrawlPolicy(t *testing.T) {
+	var mu sync.Mutex
+	var robotsAgents []string
+	var fetched []time.Time
+	srv, transport := siteStandIn(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Host == "www.down.test":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Host == "www.missing.test" && r.URL.Path == "/robots.txt":
+			http.NotFound(w, r)
+		case r.URL.Path == "/robots.txt":
+			robotsAgents = append(robotsAgents, r.Header.Get("User-Agent"))
+			w.Write([]byte(robotsFixture))
+		default:
+			fetched = append(fetched, time.Now())
+		}
+	})
+	defer srv.Close()
+
+	// pages are timestamped as the policy sends them, the crawl delay spaces
+	// requests as sent rather than as they arrive.
+	var sent []time.Time
+	stamped := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/robots.txt" {
+			mu.Lock()
+			sent = append(sent, time.Now())
+			mu.Unlock()
+		}
+		return transport.RoundTrip(req)
+	})
+	p, err := NewCrawlPolicy(CrawlConfig{Contact: "mailto:records@example.com"}, stamped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for _, u := range []string{"http://www.amazon.test/dp/1", "http://www.amazon.test/dp/2", "http://www.missing.test/private"} {
+		if _, err := get(t, p, u); err != nil {
+			t.Errorf("RoundTrip(%s) failed: %s", u, err)
+		}
+	}
+	if _, err := get(t, p, "http://www.amazon.test/private"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("RoundTrip() of a disallowed url = %v, Expected: %v", err, ErrDisallowed)
+	}
+	if _, err := get(t, p, "http://www.down.test/dp/1"); !errors.Is(err, ErrRobotsUnavailable) {
+		t.Errorf("RoundTrip() with robots.txt unavailable = %v, Expected: %v", err, ErrRobotsUnavailable)
+	}
+
+	expected := "vinyl-webscraper/1.0 (+mailto:records@example.com)"
+	if len(robotsAgents) != 1 || robotsAgents[0] != expected {
+		t.Errorf("robots.txt requests = %v, Expected: one from %q", robotsAgents, expected)
+	}
+	if len(fetched) != 3 {
+		t.Fatalf("fetched %d pages, Expected: 3", len(fetched))
+	}
+	// the first page's slot began after start, so the second may not be sent
+	// before the crawl delay has passed since.
+	if gap := sent[1].Sub(start); gap < 100*time.Millisecond {
+		t.Errorf("second request sent %v after the first, Expected: at least the crawl delay", gap)
+	}
+}
+
+func TestCrawlPolicyOverride(t *testing.T) {
+	srv, transport := siteStandIn(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /\n"))
+		}
+	})
+	defer srv.Close()
+
+	if _, err := NewCrawlPolicy(CrawlConfig{Overrides: map[string]CrawlOverride{"amazon": {IgnoreRobots: true}}}, transport); err == nil {
+		t.Errorf("NewCrawlPolicy() of an override without a reason, Expected: an error")
+	}
+
+	p, err := NewCrawlPolicy(CrawlConfig{Overrides: map[string]CrawlOverride{
+		"amazon": {IgnoreRobots: true, Reason: "prices of listed products only"},
+	}}, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := p.Check(ctx, &url.URL{Scheme: "http", Host: "www.amazon.test", Path: "/dp/1"}); err != nil {
+		t.Errorf("Check() of an overridden retailer = %v, Expected: nil", err)
+	}
+	if err := p.Check(ctx, &url.URL{Scheme: "http", Host: "www.roughtrade.test", Path: "/dp/1"}); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("Check() = %v, Expected: %v", err, ErrDisallowed)
+	}
+}
@@ -0,0 +1,336 @@
+package webscraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1602077/webscraper/go/pkg/logging"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// fields extracted from a product page by a retailer's selectors.
+const (
+	fieldAlbum  = "album"
+	fieldArtist = "artist"
+	fieldPrice  = "price"
+)
+
+var requiredFields = []string{fieldAlbum, fieldArtist, fieldPrice}
+
+// artistPattern strips the ratings count amazon sometimes includes in the
+// artist link text.
+const artistPattern = `(?s)^(.*?)(?:\s+\d[\d,]*\s+ratings.*)?$`
+
+// cleanups are the text cleanups a field may apply, by name.
+var cleanups = map[string]func(string) string{
+	"trim":           strings.TrimSpace,
+	"collapse_space": func(s string) string { return strings.Join(strings.Fields(s), " ") },
+	"strip_parens":   func(s string) string { return parensPattern.ReplaceAllString(s, "") },
+	"lower":          strings.ToLower,
+}
+
+var parensPattern = regexp.MustCompile(`\s*\([^)]*\)`)
+
+// Selector finds the nodes a field is read from by either a CSS or an XPath
+// expression, reading their text or Attr.
+type Selector struct {
+	CSS   string `json:"css,omitempty"`
+	XPath string `json:"xpath,omitempty"`
+	Attr  string `json:"attr,omitempty"`
+
+	css   cascadia.Selector
+	xpath *xpath.Expr
+}
+
+func (s *Selector) compile() error {
+	var err error
+	switch {
+	case (s.CSS == "") == (s.XPath == ""):
+		return errors.New("selector must have one of css or xpath")
+	case s.CSS != "":
+		if s.css, err = cascadia.Compile(s.CSS); err != nil {
+			return fmt.Errorf("css %q: %w", s.CSS, err)
+		}
+	default:
+		if s.xpath, err = xpath.Compile(s.XPath); err != nil {
+			return fmt.Errorf("xpath %q: %w", s.XPath, err)
+		}
+	}
+	return nil
+}
+
+// text returns the text of the nodes of doc the selector matches, joined as
+// goquery's Text does, or the first non-empty value of Attr.
+func (s *Selector) text(doc *html.Node) string {
+	var nodes []*html.Node
+	if s.css != nil {
+		nodes = s.css.MatchAll(doc)
+	} else {
+		nodes = htmlquery.QuerySelectorAll(doc, s.xpath)
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		if s.Attr == "" {
+			b.WriteString(htmlquery.InnerText(n))
+		} else if v := htmlquery.SelectAttr(n, s.Attr); v != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// FieldSelectors reads a field from the first of its Selectors to give a
+// value. The text selected is post-processed by Regex, taking its first
+// group if it has one, then each of Cleanups in order.
+type FieldSelectors struct {
+	Selectors []*Selector `json:"selectors"`
+	Regex     string      `json:"regex,omitempty"`
+	Cleanups  []string    `json:"cleanups,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (f *FieldSelectors) compile() error {
+	if len(f.Selectors) == 0 {
+		return errors.New("no selectors")
+	}
+	for _, s := range f.Selectors {
+		if err := s.compile(); err != nil {
+			return err
+		}
+	}
+	if f.Regex != "" {
+		re, err := regexp.Compile(f.Regex)
+		if err != nil {
+			return fmt.Errorf("regex %q: %w", f.Regex, err)
+		}
+		if re.NumSubexp() > 1 {
+			return fmt.Errorf("regex %q must have at most one group", f.Regex)
+		}
+		f.re = re
+	}
+	for _, c := range f.Cleanups {
+		if _, ok := cleanups[c]; !ok {
+			return fmt.Errorf("unknown cleanup %q", c)
+		}
+	}
+	return nil
+}
+
+// clean post-processes the text selected for a field.
+func (f *FieldSelectors) clean(s string) string {
+	if f.re != nil {
+		m := f.re.FindStringSubmatch(s)
+		switch {
+		case m == nil:
+			s = ""
+		case len(m) > 1:
+			s = m[1]
+		default:
+			s = m[0]
+		}
+	}
+	for _, c := range f.Cleanups {
+		s = cleanups[c](s)
+	}
+	return strings.TrimSpace(s)
+}
+
+func (f *FieldSelectors) extract(doc *html.Node) string {
+	for _, s := range f.Selectors {
+		if v := f.clean(s.text(doc)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Fixture is a saved page of a retailer and the fields expected of it.
+type Fixture struct {
+	// File is relative to the selector config.
+	File   string            `json:"file"`
+	Expect map[string]string `json:"expect"`
+}
+
+// RetailerSelectors are the selectors of the fields of a retailer's product
+// pages, and the fixtures they must extract.
+type RetailerSelectors struct {
+	Fields   map[string]*FieldSelectors `json:"fields"`
+	Fixtures []Fixture                  `json:"fixtures"`
+}
+
+// Extract reads each field of a product page, missing fields are empty.
+func (r *RetailerSelectors) Extract(page []byte) (map[string]string, error) {
+	doc, err := htmlquery.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+	return r.extract(doc), nil
+}
+
+func (r *RetailerSelectors) extract(doc *html.Node) map[string]string {
+	fields := make(map[string]string)
+	for name, f := range r.Fields {
+		fields[name] = f.extract(doc)
+	}
+	return fields
+}
+
+// SelectorConfig are the selectors of each retailer, keyed by retailer.
+type SelectorConfig struct {
+	Retailers map[string]*RetailerSelectors `json:"retailers"`
+}
+
+// defaultSelectors are used if no selector config is given.
+func defaultSelectors() *SelectorConfig {
+	cfg := &SelectorConfig{Retailers: map[string]*RetailerSelectors{
+		retailerAmazon: {Fields: map[string]*FieldSelectors{
+			fieldAlbum:  {Selectors: []*Selector{{CSS: `div#centerCol span#productTitle`}}},
+			fieldArtist: {Selectors: []*Selector{{CSS: `div#centerCol a.a-link-normal`}}, Regex: artistPattern},
+			fieldPrice:  {Selectors: []*Selector{{CSS: `div#centerCol span[class='a-offscreen']`}}},
+		}},
+	}}
+	if err := cfg.compile(); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// compile compiles the selectors of every retailer, which must select each
+// required field.
+func (c *SelectorConfig) compile() error {
+	if c.Retailers[retailerAmazon] == nil {
+		return fmt.Errorf("no selectors for %s", retailerAmazon)
+	}
+	for retailer, r := range c.Retailers {
+		for _, name := range requiredFields {
+			if r.Fields[name] == nil {
+				return fmt.Errorf("%s: no selectors for %s", retailer, name)
+			}
+		}
+		for name, f := range r.Fields {
+			if err := f.compile(); err != nil {
+				return fmt.Errorf("%s %s: %w", retailer, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate extracts the fields of every retailer's fixtures, failing if any
+// differs from that expected. Fixture files are relative to dir.
+func (c *SelectorConfig) validate(dir string) error {
+	for retailer, r := range c.Retailers {
+		for _, fx := range r.Fixtures {
+			path := fx.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			page, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s fixture: %w", retailer, err)
+			}
+			fields, err := r.Extract(page)
+			if err != nil {
+				return fmt.Errorf("%s fixture %s: %w", retailer, fx.File, err)
+			}
+			for name, want := range fx.Expect {
+				if got := fields[name]; got != want {
+					return fmt.Errorf("%s fixture %s: %s = %q, expected %q", retailer, fx.File, name, got, want)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LoadSelectors reads the selectors of each retailer from a json file,
+// compiling them and checking them against their fixtures. A missing file
+// gives the default selectors.
+func LoadSelectors(filename string) (*SelectorConfig, error) {
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultSelectors(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoadSelectors() reading %s failed: %w", filename, err)
+	}
+	var cfg SelectorConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("LoadSelectors() parsing %s failed: %w", filename, err)
+	}
+	if err := cfg.compile(); err != nil {
+		return nil, fmt.Errorf("LoadSelectors() %s: %w", filename, err)
+	}
+	if err := cfg.validate(filepath.Dir(filename)); err != nil {
+		return nil, fmt.Errorf("LoadSelectors() %s: %w", filename, err)
+	}
+	return &cfg, nil
+}
+
+var (
+	selectorsMu sync.RWMutex
+	selectors   = defaultSelectors()
+)
+
+// SetSelectors sets the selectors of each retailer used by later scrapes.
+func SetSelectors(cfg *SelectorConfig) {
+	selectorsMu.Lock()
+	defer selectorsMu.Unlock()
+	selectors = cfg
+}
+
+// retailerSelectors returns the selectors of a retailer, nil if it has none.
+func retailerSelectors(retailer string) *RetailerSelectors {
+	selectorsMu.RLock()
+	defer selectorsMu.RUnlock()
+	return selectors.Retailers[retailer]
+}
+
+// WatchSelectors reloads the selector config from filename whenever it
+// changes, checking every interval until ctx is done. A config which fails
+// to load is logged and the selectors in use are kept.
+func WatchSelectors(ctx context.Context, filename string, interval time.Duration) {
+	modified := func() time.Time {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	last := modified()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		m := modified()
+		if m.Equal(last) {
+			continue
+		}
+		last = m
+		cfg, err := LoadSelectors(filename)
+		if err != nil {
+			logging.FromContext(ctx).Error("reloading selectors failed, keeping those in use", "path", filename, "err", err)
+			continue
+		}
+		SetSelectors(cfg)
+		logging.FromContext(ctx).Info("selectors reloaded", "path", filename, "retailers", len(cfg.Retailers))
+	}
+}
@@ -0,0 +1,150 @@
+package webscraper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the selector config shipped with the app extracts its own fixtures.
+func TestShippedSelectors(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	cfg, err := LoadSelectors(filepath.Join(filepath.Dir(file), "../../../selectors.json"))
+	if err != nil {
+		t.Fatalf("LoadSelectors() failed: %s", err)
+	}
+	if len(cfg.Retailers[retailerAmazon].Fixtures) == 0 {
+		t.Errorf("LoadSelectors() amazon has no fixtures, Expected: at least one")
+	}
+}
+
+func TestFieldSelectors(t *testing.T) {
+	page := []byte(`<html><body>
+		<div id="title"><h1>  Bon   Iver  </h1></div>
+		<a class="artist" data-name="Bon Iver (Band)">Bon Iver</a>
+		<p class="price">Now only £19.99 (was £24.99)</p>
+	</body></html>`)
+
+	tests := []struct {
+		name     string
+		field    FieldSelectors
+		expected string
+	}{
+		{"first selector", FieldSelectors{Selectors: []*Selector{{CSS: "#title h1"}}, Cleanups: []string{"collapse_space"}}, "Bon Iver"},
+		{"falls back to xpath", FieldSelectors{Selectors: []*Selector{{CSS: "#productTitle"}, {XPath: "//div[@id='title']/h1"}}}, "Bon   Iver"},
+		{"attribute", FieldSelectors{Selectors: []*Selector{{CSS: "a.artist", Attr: "data-name"}}, Cleanups: []string{"strip_parens", "lower"}}, "bon iver"},
+		{"regex group", FieldSelectors{Selectors: []*Selector{{CSS: "p.price"}}, Regex: `£([\d.]+)`}, "19.99"},
+		{"regex without match falls back", FieldSelectors{Selectors: []*Selector{{CSS: "#title h1"}, {CSS: "p.price"}}, Regex: `£[\d.]+`}, "£19.99"},
+		{"no match", FieldSelectors{Selectors: []*Selector{{XPath: "//span"}}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RetailerSelectors{Fields: map[string]*FieldSelectors{"field": &tt.field}}
+			if err := tt.field.compile(); err != nil {
+				t.Fatal(err)
+			}
+			fields, err := r.Extract(page)
+			if err != nil || fields["field"] != tt.expected {
+				t.Errorf("Extract() = %q, %v, Expected: %q", fields["field"], err, tt.expected)
+			}
+		})
+	}
+}
+
+func writeSelectors(t *testing.T, dir, album string) string {
+	t.Helper()
+	os.WriteFile(filepath.Join(dir, "page.html"), []byte(`<html><body><div id="centerCol">
+		<span id="productTitle">Bon Iver</span><a class="a-link-normal">Bon Iver</a>
+		<span class="a-offscreen">£20.00</span></div></body></html>`), 0o644)
+	filename := filepath.Join(dir, "selectors.json")
+	os.WriteFile(filename, []byte(`{"retailers": {"amazon": {
+		"fields": {
+			"album": {"selectors": [{"css": "`+album+`"}]},
+			"artist": {"selectors": [{"css": "a.a-link-normal"}]},
+			"price": {"selectors": [{"css": "span.a-offscreen"}]}
+		},
+		"fixtures": [{"file": "page.html", "expect": {"album": "Bon Iver", "price": "£20.00"}}]
+	}}}`), 0o644)
+	return filename
+}
+
+func TestLoadSelectors(t *testing.T) {
+	tests := []struct{ name, album, err string }{
+		{"valid", "span#productTitle", ""},
+		{"invalid css", "span[", "css"},
+		{"fails fixture", "span.a-offscreen", "fixture page.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSelectors(writeSelectors(t, t.TempDir(), tt.album))
+			if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("LoadSelectors() = %v, Expected: error containing %q", err, tt.err)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	for _, cfg := range []string{
+		`{"retailers": {}}`,
+		`{"retailers": {"amazon": {"fields": {"album": {"selectors": [{"css": "h1"}]}}}}}`,
+		`{"retailers": {"amazon": {"fields": {
+			"album": {"selectors": [{"css": "h1", "xpath": "//h1"}]},
+			"artist": {"selectors": [{"css": "a"}]}, "price": {"selectors": [{"css": "p"}]}}}}}`,
+		`{"retailers": {"amazon": {"fields": {
+			"album": {"selectors": [{"css": "h1"}], "cleanups": ["shout"]},
+			"artist": {"selectors": [{"css": "a"}]}, "price": {"selectors": [{"css": "p"}]}}}}}`,
+	} {
+		filename := filepath.Join(dir, "selectors.json")
+		os.WriteFile(filename, []byte(cfg), 0o644)
+		if _, err := LoadSelectors(filename); err == nil {
+			t.Errorf("LoadSelectors(%s), Expected: an error", cfg)
+		}
+	}
+
+	if cfg, err := LoadSelectors(filepath.Join(dir, "missing.json")); err != nil || cfg.Retailers[retailerAmazon] == nil {
+		t.Errorf("LoadSelectors() of a missing file = %v, Expected: the default selectors", err)
+	}
+}
+
+func TestWatchSelectors(t *testing.T) {
+	defer SetSelectors(defaultSelectors())
+	dir := t.TempDir()
+	filename := writeSelectors(t, dir, "span#productTitle")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchSelectors(ctx, filename, 5*time.Millisecond)
+
+	reloaded := func(css string) bool {
+		for i := 0; i < 200; i++ {
+			if retailerSelectors(retailerAmazon).Fields[fieldAlbum].Selectors[0].CSS == css {
+				return true
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return false
+	}
+	touch := func(n int) {
+		later := time.Now().Add(time.Duration(n) * time.Second)
+		os.Chtimes(filename, later, later)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	writeSelectors(t, dir, "#productTitle")
+	touch(1)
+	if !reloaded("#productTitle") {
+		t.Fatalf("WatchSelectors() did not reload the changed config")
+	}
+
+	// a config failing its fixtures is not loaded.
+	writeSelectors(t, dir, "span.a-offscreen")
+	touch(2)
+	time.Sleep(50 * time.Millisecond)
+	if css := retailerSelectors(retailerAmazon).Fields[fieldAlbum].Selectors[0].CSS; css != "#productTitle" {
+		t.Errorf("selectors after an invalid reload = %s, Expected: #productTitle kept", css)
+	}
+}
@@ -0,0 +1,366 @@
+package webscraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/htmlquery"
+	"golang.org/x/net/html"
+)
+
+// optional fields of a product page, read from its structured data or a
+// retailer's selectors.
+const (
+	fieldCurrency     = "currency"
+	fieldAvailability = "availability"
+	fieldGTIN         = "gtin"
+)
+
+// productTypes are the schema.org types a product is described by.
+var productTypes = map[string]bool{
+	"product":           true,
+	"individualproduct": true,
+	"productmodel":      true,
+	"productgroup":      true,
+	"musicalbum":        true,
+	"musicrelease":      true,
+}
+
+// StructuredData is the product a page describes in schema.org JSON-LD or
+// microdata, or OpenGraph product tags.
+type StructuredData struct {
+	Name string
+	// Brand is the artist of a record, its byArtist if it is described as a
+	// music release.
+	Brand        string
+	Price        string
+	Currency     string
+	Availability string
+	GTIN         string
+}
+
+// merge fills the fields of d missing from o.
+func (d *StructuredData) merge(o StructuredData) {
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fill(&d.Name, o.Name)
+	fill(&d.Brand, o.Brand)
+	fill(&d.Price, o.Price)
+	fill(&d.Currency, o.Currency)
+	fill(&d.Availability, o.Availability)
+	fill(&d.GTIN, o.GTIN)
+}
+
+// fields returns the structured data as the fields of a product page.
+func (d StructuredData) fields() map[string]string {
+	return map[string]string{
+		fieldAlbum:        d.Name,
+		fieldArtist:       d.Brand,
+		fieldPrice:        d.Price,
+		fieldCurrency:     d.Currency,
+		fieldAvailability: d.Availability,
+		fieldGTIN:         d.GTIN,
+	}
+}
+
+// ExtractStructured reads the product a page describes from its JSON-LD,
+// then its microdata, then its OpenGraph tags, each filling the fields the
+// former lack.
+func ExtractStructured(page []byte) StructuredData {
+	doc, err := htmlquery.Parse(bytes.NewReader(page))
+	if err != nil {
+		return StructuredData{}
+	}
+	return extractStructured(doc)
+}
+
+func extractStructured(doc *html.Node) StructuredData {
+	d := jsonLD(doc)
+	d.merge(microdata(doc))
+	d.merge(openGraph(doc))
+	return d
+}
+
+// extractFields reads the fields of a retailer's product page from its
+// structured data, falling back to the retailer's selectors for any it lacks.
+// Retailers without selectors rely on the structured data alone.
+func extractFields(retailer string, page []byte) (map[string]string, error) {
+	doc, err := htmlquery.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+	fields := extractStructured(doc).fields()
+	if sel := retailerSelectors(retailer); sel != nil {
+		for name, v := range sel.extract(doc) {
+			if fields[name] == "" {
+				fields[name] = v
+			}
+		}
+	}
+	return fields, nil
+}
+
+// jsonLD reads the first product of the JSON-LD scripts of doc.
+func jsonLD(doc *html.Node) StructuredData {
+	for _, n := range htmlquery.Find(doc, `//script[@type='application/ld+json']`) {
+		var v any
+		if err := json.Unmarshal([]byte(htmlquery.InnerText(n)), &v); err != nil {
+			continue
+		}
+		if p := findProduct(v); p != nil {
+			return productFromItem(p)
+		}
+	}
+	return StructuredData{}
+}
+
+// findProduct returns the first item of a JSON-LD document, or its @graph,
+// whose type is a product.
+func findProduct(v any) map[string]any {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			if p := findProduct(item); p != nil {
+				return p
+			}
+		}
+	case map[string]any:
+		if isProduct(v["@type"]) {
+			return v
+		}
+		if g, ok := v["@graph"]; ok {
+			return findProduct(g)
+		}
+	}
+	return nil
+}
+
+// isProduct reports whether a schema.org type, or any of a list of types, is
+// a product.
+func isProduct(t any) bool {
+	switch t := t.(type) {
+	case string:
+		t = t[strings.LastIndexAny(t, "/:")+1:]
+		return productTypes[strings.ToLower(t)]
+	case []any:
+		for _, s := range t {
+			if isProduct(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// productFromItem reads a schema.org product, from JSON-LD or microdata, its
+// values either strings, numbers, nested items or lists of them.
+func productFromItem(p map[string]any) StructuredData {
+	d := StructuredData{
+		Name:  text(p["name"]),
+		Brand: name(p["byArtist"]),
+		GTIN:  gtin(p),
+	}
+	if d.Brand == "" {
+		d.Brand = name(p["brand"])
+	}
+
+	offer, _ := first(p["offers"]).(map[string]any)
+	if offer == nil {
+		return d
+	}
+	d.Price = text(offer["price"])
+	if d.Price == "" {
+		d.Price = text(offer["lowPrice"])
+	}
+	if spec, ok := first(offer["priceSpecification"]).(map[string]any); ok && d.Price == "" {
+		d.Price = text(spec["price"])
+		d.Currency = text(spec["priceCurrency"])
+	}
+	d.Price = normalisePrice(d.Price)
+	if c := text(offer["priceCurrency"]); c != "" {
+		d.Currency = c
+	}
+	d.Currency = strings.ToUpper(d.Currency)
+	d.Availability = schemaValue(text(offer["availability"]))
+	if d.GTIN == "" {
+		d.GTIN = gtin(offer)
+	}
+	return d
+}
+
+// first returns the first value of a list, or the value itself.
+func first(v any) any {
+	if l, ok := v.([]any); ok {
+		if len(l) == 0 {
+			return nil
+		}
+		return l[0]
+	}
+	return v
+}
+
+// text returns a string or number value as a string.
+func text(v any) string {
+	switch v := first(v).(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// name returns the name of a nested item, or a value given as a string.
+func name(v any) string {
+	if item, ok := first(v).(map[string]any); ok {
+		return text(item["name"])
+	}
+	return text(v)
+}
+
+// gtin returns the first global trade item number of an item.
+func gtin(item map[string]any) string {
+	for _, k := range []string{"gtin13", "gtin", "gtin12", "gtin14", "gtin8", "isbn"} {
+		if s := text(item[k]); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// schemaValue strips the schema.org prefix of an enumeration value, e.g.
+// https://schema.org/InStock.
+func schemaValue(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
+
+// normalisePrice formats a price with two decimal places, dropping
+// thousands separators. Prices which are not numbers are returned as they
+// are for parsePrice.
+func normalisePrice(s string) string {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// microdata reads the first product item of doc.
+func microdata(doc *html.Node) StructuredData {
+	for _, n := range htmlquery.Find(doc, `//*[@itemscope and @itemtype]`) {
+		if htmlquery.ExistsAttr(n, "itemprop") {
+			continue
+		}
+		for _, t := range strings.Fields(htmlquery.SelectAttr(n, "itemtype")) {
+			if isProduct(t) {
+				return productFromItem(itemProps(n))
+			}
+		}
+	}
+	return StructuredData{}
+}
+
+// itemProps reads the properties of a microdata item, those of nested items
+// as maps. Only the first value of each property is kept.
+func itemProps(item *html.Node) map[string]any {
+	props := make(map[string]any)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			prop := htmlquery.SelectAttr(c, "itemprop")
+			scoped := htmlquery.ExistsAttr(c, "itemscope")
+			if prop != "" {
+				var v any = itemValue(c)
+				if scoped {
+					v = itemProps(c)
+				}
+				for _, p := range strings.Fields(prop) {
+					if _, ok := props[p]; !ok {
+						props[p] = v
+					}
+				}
+			}
+			// the properties of nested items are not the item's own.
+			if !scoped {
+				walk(c)
+			}
+		}
+	}
+	walk(item)
+	return props
+}
+
+// itemValue is the value of a microdata property element.
+func itemValue(n *html.Node) string {
+	if v := htmlquery.SelectAttr(n, "content"); v != "" {
+		return strings.TrimSpace(v)
+	}
+	var attr string
+	switch n.Data {
+	case "a", "link", "area":
+		attr = "href"
+	case "img", "audio", "video", "source", "embed", "iframe":
+		attr = "src"
+	case "data", "meter":
+		attr = "value"
+	case "time":
+		attr = "datetime"
+	}
+	if attr != "" {
+		if v := htmlquery.SelectAttr(n, attr); v != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return strings.Join(strings.Fields(htmlquery.InnerText(n)), " ")
+}
+
+// openGraph reads the OpenGraph product tags of doc, pages whose og:type is
+// not a product are ignored as their title is rarely the product's name.
+func openGraph(doc *html.Node) StructuredData {
+	tags := make(map[string]string)
+	for _, n := range htmlquery.Find(doc, `//meta[@property and @content]`) {
+		p := strings.ToLower(htmlquery.SelectAttr(n, "property"))
+		if _, ok := tags[p]; !ok {
+			tags[p] = strings.TrimSpace(htmlquery.SelectAttr(n, "content"))
+		}
+	}
+	if t := tags["og:type"]; t != "product" && !strings.HasPrefix(t, "product.") && !strings.HasPrefix(t, "og:product") {
+		return StructuredData{}
+	}
+	get := func(keys ...string) string {
+		for _, k := range keys {
+			if v := tags[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	return StructuredData{
+		Name:         get("og:title"),
+		Brand:        get("product:brand", "og:brand"),
+		Price:        normalisePrice(get("product:price:amount", "og:price:amount")),
+		Currency:     strings.ToUpper(get("product:price:currency", "og:price:currency")),
+		Availability: get("product:availability", "og:availability"),
+		GTIN:         get("product:ean", "product:upc", "product:gtin", "product:isbn"),
+	}
+}
+
+// isAvailable reports whether an availability, from structured data or a
+// retailer's selectors, is in stock, false if it is not recognised.
+func isAvailable(s string) (available, ok bool) {
+	switch strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(schemaValue(s))) {
+	case "instock", "limitedavailability", "onlineonly", "instoreonly", "preorder", "presale", "available":
+		return true, true
+	case "outofstock", "soldout", "discontinued", "backorder", "unavailable", "oos":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,137 @@
+package webscraper
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestExtractStructured(t *testing.T) {
+	tests := []struct {
+		name     string
+		page     string
+		expected StructuredData
+	}{
+		{
+			"json-ld graph",
+			`<html><head><script type="application/ld+json">{"@context": "https://schema.org", "@graph": [
+				{"@type": "WebSite", "name": "Rough Trade"},
+				{"@type": ["Product", "MusicAlbum"], "name": "Ants From Up There", "byArtist": {"@type": "MusicGroup", "name": "Black Country, New Road"},
+				 "brand": "Ninja Tune", "gtin13": "5054429152271",
+				 "offers": [{"@type": "Offer", "price": 1299.5, "priceCurrency": "gbp", "availability": "https://schema.org/InStock"}]}
+			]}</script></head></html>`,
+			StructuredData{"Ants From Up There", "Black Country, New Road", "1299.50", "GBP", "InStock", "5054429152271"},
+		},
+		{
+			"json-ld aggregate offer",
+			`<script type="application/ld+json">not json</script>
+			<script type="application/ld+json">[{"@type": "Product", "name": "Bon Iver", "brand": {"name": "Bon Iver"},
+				"offers": {"@type": "AggregateOffer", "lowPrice": "18.99", "priceCurrency": "EUR", "gtin": "0656605211817"}}]</script>`,
+			StructuredData{"Bon Iver", "Bon Iver", "18.99", "EUR", "", "0656605211817"},
+		},
+		{
+			"microdata",
+			`<div itemscope itemtype="https://schema.org/Product">
+				<h1 itemprop="name"> What Kinda   Music </h1>
+				<div itemprop="brand" itemscope itemtype="https://schema.org/Brand"><span itemprop="name">Tom Misch</span></div>
+				<meta itemprop="gtin13" content="0602508490375">
+				<div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+					<span itemprop="price" content="22.50">£22.50</span>
+					<meta itemprop="priceCurrency" content="GBP">
+					<link itemprop="availability" href="https://schema.org/OutOfStock">
+				</div>
+			</div>`,
+			StructuredData{"What Kinda Music", "Tom Misch", "22.50", "GBP", "OutOfStock", "0602508490375"},
+		},
+		{
+			"opengraph",
+			`<head><meta property="og:type" content="product"><meta property="og:title" content="Blue">
+				<meta property="product:brand" content="Joni Mitchell"><meta property="product:price:amount" content="25">
+				<meta property="product:price:currency" content="GBP"><meta property="product:availability" content="in stock"></head>`,
+			StructuredData{"Blue", "Joni Mitchell", "25.00", "GBP", "in stock", ""},
+		},
+		{
+			"opengraph of a page which is not a product",
+			`<head><meta property="og:type" content="website"><meta property="og:title" content="Amazon.co.uk"></head>`,
+			StructuredData{},
+		},
+		{
+			"json-ld first then opengraph",
+			`<head><meta property="og:type" content="product"><meta property="og:title" content="Blue (Remastered)">
+				<meta property="product:price:currency" content="GBP">
+				<script type="application/ld+json">{"@type": "Product", "name": "Blue", "offers": {"price": "25.00"}}</script></head>`,
+			StructuredData{Name: "Blue", Price: "25.00", Currency: "GBP"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractStructured([]byte(tt.page)); got != tt.expected {
+				t.Errorf("ExtractStructured() = %+v, Expected: %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsAvailable(t *testing.T) {
+	tests := []struct {
+		s                   string
+		available, expected bool
+	}{
+		{"https://schema.org/InStock", true, true},
+		{"in stock", true, true},
+		{"PreOrder", true, true},
+		{"http://schema.org/OutOfStock", false, true},
+		{"sold_out", false, true},
+		{"", false, false},
+		{"call for availability", false, false},
+	}
+	for _, tt := range tests {
+		available, ok := isAvailable(tt.s)
+		if available != tt.available || ok != tt.expected {
+			t.Errorf("isAvailable(%q) = %v, %v, Expected: %v, %v", tt.s, available, ok, tt.available, tt.expected)
+		}
+	}
+}
+
+// shops without selectors are scraped from their structured data alone, and
+// amazon's fields missing from its structured data are read by its selectors.
+func TestGetPageInfoStructured(t *testing.T) {
+	srv, transport := siteStandIn(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products/blue-lp":
+			w.Write([]byte(`<html><head><script type="application/ld+json">{"@type": "Product",
+				"name": "Blue", "brand": "Joni Mitchell", "gtin13": "0075597965872",
+				"offers": {"price": "25.00", "priceCurrency": "GBP", "availability": "https://schema.org/OutOfStock"}}</script></head></html>`))
+		case "/dp/B084P38346":
+			w.Write([]byte(`<html><head><meta property="og:type" content="product">
+				<meta property="product:price:amount" content="19.99"></head>` + productPageFixture[len("<html>"):]))
+		}
+	})
+	defer srv.Close()
+
+	f, err := newCollectorFactory(Config{}, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withFactory(context.Background(), f)
+
+	r := getPageInfo(ctx, "http://www.shop.test/products/blue-lp")
+	if r == nil {
+		t.Fatal("getPageInfo() = nil, Expected: a record")
+	}
+	o := r.GetOffers()[0]
+	if r.GetAlbum() != "Blue" || r.GetArtist() != "Joni Mitchell" || o.Price != 25 || o.Currency != "GBP" {
+		t.Errorf("getPageInfo() = %s by %s at %v %s, Expected: Blue by Joni Mitchell at 25 GBP", r.GetAlbum(), r.GetArtist(), o.Price, o.Currency)
+	}
+	if o.Retailer != "shop" || o.Available {
+		t.Errorf("getPageInfo() offer = %+v, Expected: an unavailable offer of shop", o)
+	}
+	if r.GetBarcode() != "0075597965872" {
+		t.Errorf("getPageInfo() barcode = %q, Expected: 0075597965872", r.GetBarcode())
+	}
+
+	r = getPageInfo(ctx, "http://www.amazon.test/dp/B084P38346")
+	if r == nil || r.GetAlbum() != "What Kinda Music" || r.GetOffers()[0].Price != 19.99 {
+		t.Errorf("getPageInfo() = %v, Expected: What Kinda Music at the structured price 19.99", r)
+	}
+}
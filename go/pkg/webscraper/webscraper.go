@@ -2,74 +2,414 @@
 package webscraper
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/1602077/webscraper/go/pkg/logging"
 	"github.com/1602077/webscraper/go/pkg/records"
 	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+const retailerAmazon = "amazon"
+
+// scrape outcomes recorded against scrapeAttempts.
+const (
+	outcomeSuccess   = "success"
+	outcomeEmpty     = "empty"
+	outcomeError     = "error"
+	outcomeCancelled = "cancelled"
+	// outcomeDisallowed is a page the crawl policy refused to fetch.
+	outcomeDisallowed = "disallowed"
+)
+
+var (
+	scrapeAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webscraper_scrape_attempts_total",
+		Help: "Number of page scrapes attempted by retailer and outcome.",
+	}, []string{"retailer", "outcome"})
+	scrapeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webscraper_scrape_duration_seconds",
+		Help:    "Time taken to scrape a single page by retailer.",
+		Buckets: []float64{.25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"retailer"})
+)
+
+func init() {
+	prometheus.MustRegister(scrapeAttempts, scrapeDuration)
+}
+
+var (
+	shippingMu    sync.RWMutex
+	shippingRules records.ShippingRules
+)
+
+// SetShippingRules sets the shipping rules of each retailer, which price the
+// delivery of offers whose listing does not state a delivery charge.
+func SetShippingRules(rules records.ShippingRules) {
+	shippingMu.Lock()
+	defer shippingMu.Unlock()
+	shippingRules = rules
+}
+
+// withShipping sets the shipping of an offer from the delivery charge listed
+// with it, or its retailer's shipping rule if none is listed.
+func withShipping(o records.Offer, delivery string) records.Offer {
+	if strings.TrimSpace(delivery) != "" {
+		o.Shipping = parseShipping(delivery)
+		return o
+	}
+	shippingMu.RLock()
+	defer shippingMu.RUnlock()
+	return shippingRules.Apply(o)
+}
+
+type jobIDKey struct{}
+
+// WithJobID returns a copy of ctx tagged with the id of a scrape job, which is
+// added to all log lines written by the job.
+func WithJobID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, jobIDKey{}, id)
+	return logging.With(ctx, "job_id", id)
+}
+
+// ErrDuplicateURL is returned by AddURL for a product already in the input
+// file.
+var ErrDuplicateURL = errors.New("product already added")
+
+// inputMu serialises writes to input files.
+var inputMu sync.Mutex
+
+// parseURLs canonicalises each url of the input file data, keyed by product
+// id. Blank lines are skipped, as are invalid and duplicate urls which are
+// returned for the caller to report.
+func parseURLs(data string) (urls []string, ids map[records.ProductID]string, invalid, duplicates []string) {
+	ids = make(map[records.ProductID]string)
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		id, canonical, err := records.ParseProductURL(line)
+		if err != nil {
+			invalid = append(invalid, line)
+			continue
+		}
+		if _, ok := ids[id]; ok {
+			duplicates = append(duplicates, line)
+			continue
+		}
+		ids[id] = canonical
+		urls = append(urls, canonical)
+	}
+	return urls, ids, invalid, duplicates
+}
+
 // ReadURLs reads in  a list of urls each separated by a `\n` from the input
-// file to a slice of strings.
+// file to a slice of strings. Urls are canonicalised, stripping tracking
+// parameters, and each product is only returned once.
 func ReadURLs(filename string) []string {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("reading url file failed", "path", filename, "err", err)
+		os.Exit(1)
+	}
+	urls, _, invalid, duplicates := parseURLs(string(data))
+	for _, u := range invalid {
+		slog.Warn("skipping invalid product url", "path", filename, "url", u)
+	}
+	for _, u := range duplicates {
+		slog.Warn("skipping duplicate product url", "path", filename, "url", u)
 	}
-	d := strings.Split(string(data), "\n")
-	return d[:len(d)-1]
+	return urls
 }
 
-// getAmazonPageInfo gets the Artist, Album Name and Price for a given record
-// from an amazon URL by using the gocolly package.
-func getAmazonPageInfo(url string) (pageinfo *records.Record) {
-	c := colly.NewCollector()
+// AddURL appends the canonical form of the product url raw to the input
+// file, returning its product id and canonical url. ErrDuplicateURL is
+// returned if the product is already in the file.
+func AddURL(filename, raw string) (records.ProductID, string, error) {
+	id, canonical, err := records.ParseProductURL(raw)
+	if err != nil {
+		return id, "", err
+	}
 
-	c.OnHTML(`div[id=centerCol]`, func(e *colly.HTMLElement) {
-		album := e.ChildText(`span[id=productTitle]`)
-		if album == "" {
-			log.Println("no title found", e.Request.URL)
-		}
+	inputMu.Lock()
+	defer inputMu.Unlock()
 
-		artist := e.ChildText(`a.a-link-normal`)
-		if artist == "" {
-			log.Println("no artist found", e.Request.URL)
-		}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return id, "", fmt.Errorf("AddURL() reading %s failed: %w", filename, err)
+	}
+	if _, ids, _, _ := parseURLs(string(data)); ids[id] != "" {
+		return id, ids[id], ErrDuplicateURL
+	}
 
-		price := e.ChildText(`span[class='a-offscreen']`)
-		if price == "" {
-			log.Println("no price found", e.Request.URL)
-		}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return id, "", fmt.Errorf("AddURL() opening %s failed: %w", filename, err)
+	}
+	defer f.Close()
+	line := canonical + "\n"
+	// urls are terminated by a newline, which a hand edited file may lack.
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		line = "\n" + line
+	}
+	if _, err := f.WriteString(line); err != nil {
+		return id, "", fmt.Errorf("AddURL() writing %s failed: %w", filename, err)
+	}
+	return id, canonical, nil
+}
+
+// getPageInfo gets the Artist, Album Name and Price for a given record from
+// a retailer's product page by using the gocolly package. A single log line
+// is written per scrape recording its url, retailer, duration and outcome,
+// nil is returned if no record could be scraped.
+func getPageInfo(ctx context.Context, url string) (pageinfo *records.Record) {
+	start := time.Now()
+	c := newCollector(ctx)
+	retailer := retailerOf(url)
 
+	// the fields of a record are read from the structured data of the page,
+	// then the retailer's selectors, the page is treated as empty if none are
+	// found.
+	var missing []string
+	var fields map[string]string
+	c.OnResponse(func(r *colly.Response) {
+		var err error
+		fields, err = extractFields(retailer, r.Body)
+		if err != nil {
+			logging.FromContext(ctx).Warn("parsing page failed", "url", url, "err", err)
+			return
+		}
+		if run := healthRunFrom(ctx); run != nil {
+			run.observe(retailer, fields)
+		}
+		for _, name := range requiredFields {
+			if fields[name] == "" {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) == len(requiredFields) {
+			missing = nil
+			return
+		}
 		pageinfo = records.NewRecord(
-			parseArtist(artist),
-			strings.Replace(album, " [VINYL]", "", 1),
+			fields[fieldArtist],
+			fields[fieldAlbum],
 			url,
-			parsePrice(price),
+			parsePrice(fields[fieldPrice]),
 		)
 	})
-	c.Visit(url)
+	// barcodes and catalogue numbers are listed under the product details of
+	// some listings, they are optional so not counted as missing.
+	var barcode, catalogueNumber string
+	c.OnHTML(`div[id=detailBullets_feature_div] li`, func(e *colly.HTMLElement) {
+		label, value := parseDetail(e.ChildText(`span.a-text-bold`), e.ChildText(`span.a-text-bold + span`))
+		switch label {
+		case "ean", "barcode", "upc":
+			barcode = value
+		case "catalogue number", "catalog number", "label number":
+			catalogueNumber = value
+		}
+	})
+	// the seller of the buy box offer is listed in a table on current
+	// listings and a sentence on older ones.
+	var seller, dispatcher, condition string
+	c.OnHTML(`div[id=tabular-buybox]`, func(e *colly.HTMLElement) {
+		seller = e.ChildText(`div[tabular-attribute-name='Sold by'] .tabular-buybox-text-message`)
+		dispatcher = e.ChildText(`div[tabular-attribute-name='Dispatches from'] .tabular-buybox-text-message`)
+		if dispatcher == "" {
+			dispatcher = e.ChildText(`div[tabular-attribute-name='Ships from'] .tabular-buybox-text-message`)
+		}
+	})
+	c.OnHTML(`div[id=merchant-info]`, func(e *colly.HTMLElement) {
+		if seller == "" {
+			seller, dispatcher = parseMerchantInfo(e.Text)
+		}
+	})
+	var delivery string
+	c.OnHTML(`div[id=mir-layout-DELIVERY_BLOCK]`, func(e *colly.HTMLElement) {
+		delivery = e.ChildAttr(`span[data-csa-c-delivery-price]`, "data-csa-c-delivery-price")
+	})
+	// listings with no new offers show a used offer in the buy box.
+	c.OnHTML(`div[id=usedOnlyBuybox]`, func(e *colly.HTMLElement) {
+		condition = records.ParseCondition(e.ChildText(`span.a-text-bold`))
+	})
+	err := c.Visit(url)
+	if pageinfo != nil {
+		if barcode == "" {
+			barcode = fields[fieldGTIN]
+		}
+		pageinfo.WithIdentifiers(barcode, catalogueNumber)
+		buybox := pageinfo.GetOffers()[0]
+		buybox.Retailer = retailer
+		buybox.Currency = fields[fieldCurrency]
+		if available, ok := isAvailable(fields[fieldAvailability]); ok {
+			buybox.Available = available && buybox.Price > 0
+		}
+		buybox.Seller = seller
+		buybox.Fulfilled = isFulfilledBy(retailer, dispatcher)
+		buybox.Condition = condition
+		if buybox.Condition == "" {
+			buybox.Condition = records.ConditionNew
+		}
+		buybox = withShipping(buybox, delivery)
+		offers := records.Offers{buybox}
+		if listing, ok := offerListingURL(url); ok && err == nil {
+			others, lerr := getOfferListing(ctx, listing, buybox.Url)
+			if lerr != nil {
+				logging.FromContext(ctx).Warn("offer listing scrape failed", "url", listing, "err", lerr)
+			}
+			offers = append(offers, others...)
+		}
+		pageinfo.WithOffers(offers)
+	}
+	duration := time.Since(start)
+	scrapeDuration.WithLabelValues(retailer).Observe(duration.Seconds())
+
+	outcome, level := outcomeSuccess, slog.LevelInfo
+	switch {
+	case ctx.Err() != nil:
+		outcome, level, err = outcomeCancelled, slog.LevelWarn, ctx.Err()
+		pageinfo = nil
+	case errors.Is(err, ErrDisallowed), errors.Is(err, ErrRobotsUnavailable):
+		outcome, level = outcomeDisallowed, slog.LevelWarn
+		pageinfo = nil
+	case err != nil:
+		outcome, level = outcomeError, slog.LevelError
+		pageinfo = nil
+	case pageinfo == nil:
+		// amazon serves a captcha page once the call limit for a session is
+		// exceeded, which has none of the fields we scrape.
+		outcome, level = outcomeEmpty, slog.LevelError
+	case len(missing) > 0:
+		level = slog.LevelWarn
+	}
+	scrapeAttempts.WithLabelValues(retailer, outcome).Inc()
 
-	var emptyRecord *records.Record
-	if emptyRecord == pageinfo {
-		log.Fatal("getAmazonPageInfo() returned nil for all fields. Exceed call limit for session")
+	attrs := []any{
+		"url", url,
+		"retailer", retailer,
+		"duration_ms", duration.Milliseconds(),
+		"outcome", outcome,
 	}
+	if len(missing) > 0 {
+		attrs = append(attrs, "missing_fields", missing)
+	}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	logging.FromContext(ctx).Log(ctx, level, "page scraped", attrs...)
 
 	return
 }
 
-// parseArtist does a regex parse of the getAmazonPageInfo artist field output
-// to remove the ratings tag which is occasionally included in html element.
-func parseArtist(s string) string {
-	re := regexp.MustCompile(` \d+,?\d+ ratings`)
-	indx := re.FindStringIndex(s)[0]
-	return s[:indx]
+// retailerOf names the retailer of a product url, amazon if it cannot be
+// parsed.
+func retailerOf(url string) string {
+	if id, _, err := records.ParseProductURL(url); err == nil {
+		return id.Retailer
+	}
+	return retailerAmazon
+}
+
+// offerListingURL returns the url of the listing of every seller's offer of
+// an amazon product, false if url is not an amazon product url.
+func offerListingURL(url string) (string, bool) {
+	id, canonical, err := records.ParseProductURL(url)
+	if err != nil || id.Retailer != retailerAmazon {
+		return "", false
+	}
+	return strings.TrimSuffix(canonical, "/dp/"+id.Id) + "/gp/aod/ajax?asin=" + id.Id + "&pc=dp", true
 }
 
-// parsePrice does a regex parse of the getAmazonPageInfo price to strip out
+// getOfferListing scrapes the offer of each seller from an amazon offer
+// listing, the offer of the buy box is pinned to the top of the listing so is
+// skipped. productURL is the url of each offer.
+func getOfferListing(ctx context.Context, url, productURL string) (records.Offers, error) {
+	c := newCollector(ctx)
+
+	var offers records.Offers
+	c.OnHTML(`div[id=aod-offer]`, func(e *colly.HTMLElement) {
+		price := parsePrice(e.ChildText(`span.a-price span.a-offscreen`))
+		if price == 0 {
+			return
+		}
+		seller := e.ChildText(`div[id=aod-offer-soldBy] a`)
+		if seller == "" {
+			seller = e.ChildText(`div[id=aod-offer-soldBy] .a-col-right span`)
+		}
+		condition := records.ParseCondition(e.ChildText(`div[id=aod-offer-heading] h5`))
+		if condition == "" {
+			condition = records.ConditionNew
+		}
+		offers = append(offers, withShipping(records.Offer{
+			Retailer:  retailerAmazon,
+			Url:       productURL,
+			Price:     price,
+			Available: true,
+			Seller:    seller,
+			Fulfilled: isFulfilledBy(retailerAmazon, e.ChildText(`div[id=aod-offer-shipsFrom] .a-col-right span`)),
+			Condition: condition,
+		}, e.ChildAttr(`span[data-csa-c-delivery-price]`, "data-csa-c-delivery-price")))
+	})
+	err := c.Visit(url)
+	return offers, err
+}
+
+// soldAndDispatchedBy and soldByFulfilledBy match the sentences naming the
+// seller of an offer, "Dispatched from and sold by X." and "Sold by X and
+// Fulfilled by Y." respectively.
+var (
+	soldAndDispatchedBy = regexp.MustCompile(`(?i)(?:dispatched|ships) from and sold by (.+?)\.?$`)
+	soldByFulfilledBy   = regexp.MustCompile(`(?i)sold by (.+?) and (?:fulfilled|dispatched|shipped) by (.+?)\.?$`)
+)
+
+// parseMerchantInfo parses the seller and dispatcher of an offer from the
+// merchant info sentence of an amazon listing.
+func parseMerchantInfo(s string) (seller, dispatcher string) {
+	s = strings.Join(strings.Fields(s), " ")
+	if m := soldAndDispatchedBy.FindStringSubmatch(s); m != nil {
+		return m[1], m[1]
+	}
+	if m := soldByFulfilledBy.FindStringSubmatch(s); m != nil {
+		return m[1], m[2]
+	}
+	return "", ""
+}
+
+// isFulfilledBy reports whether the dispatcher of an offer is the retailer,
+// e.g. "Amazon" or "Amazon.co.uk" for amazon.
+func isFulfilledBy(retailer, dispatcher string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(dispatcher)), retailer)
+}
+
+// parseShipping parses the delivery price of an offer, which is free if it
+// is not a price.
+func parseShipping(s string) float32 {
+	if strings.Contains(strings.ToLower(s), "free") {
+		return 0
+	}
+	return parsePrice(s)
+}
+
+// parseDetail normalises the label and value of a product details bullet,
+// labels are lower cased with their trailing colon and any invisible
+// direction marks removed.
+func parseDetail(label, value string) (string, string) {
+	clean := strings.NewReplacer("\u200e", "", "\u200f", "", ":", "")
+	return strings.ToLower(strings.TrimSpace(clean.Replace(label))), strings.TrimSpace(clean.Replace(value))
+}
+
+// parsePrice does a regex parse of the getPageInfo price to strip out
 // any redundant text that may be lingering in the html element.
 func parsePrice(s string) float32 {
 	re := regexp.MustCompile(`[\d.]+`)
@@ -78,20 +418,63 @@ func parsePrice(s string) float32 {
 	return float32(flt)
 }
 
-// GetRecords concurrently calls getAmazonPageInfo to allow for the scraping of
-// URLS to be performed in parallel.
-func GetRecords(urls []string) (rs records.Records) {
+// GetRecords concurrently calls getPageInfo to allow for the scraping of
+// URLS to be performed in parallel. Cancelling ctx aborts any outstanding
+// requests and only the records scraped so far are returned.
+//
+// Every log line of the run carries a job_id, which is generated if ctx does
+// not already carry one. The fields extracted are counted towards the
+// extraction health of each retailer, and records whose price looks bogus
+// are returned with their price held for review.
+func GetRecords(ctx context.Context, urls []string) (rs records.Records) {
+	if _, ok := ctx.Value(jobIDKey{}).(string); !ok {
+		ctx = WithJobID(ctx, logging.NewID())
+	}
+	start := time.Now()
+	f := factoryFrom(ctx)
+	var run *healthRun
+	if f.health != nil {
+		run = f.health.newRun()
+		ctx = withHealthRun(ctx, run)
+	}
+	defer func() {
+		if run != nil {
+			run.finish(ctx)
+		}
+		if err := f.saveCookies(); err != nil {
+			logging.FromContext(ctx).Error("saving cookies failed", "err", err)
+		}
+		if f.archive != nil {
+			days, bodies, err := f.archive.Prune()
+			if err != nil {
+				logging.FromContext(ctx).Error("pruning page archive failed", "err", err)
+			} else if days > 0 {
+				logging.FromContext(ctx).Info("page archive pruned", "days", days, "pages", bodies)
+			}
+		}
+		logging.FromContext(ctx).Info("scrape run finished",
+			"urls", len(urls),
+			"scraped", len(rs),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}()
+
 	// limit to 10 concurrent requests at a time.
 	ch := make(chan *records.Record, 10)
 	for _, u := range urls {
 		go func(u string) {
-			r := getAmazonPageInfo(u)
+			r := getPageInfo(ctx, u)
+			// prices which look bogus are held for review when written.
+			if r != nil && f.health != nil {
+				f.health.check(ctx, u, r)
+			}
 			ch <- r
 		}(u)
 	}
 	for range urls {
-		r := <-ch
-		rs = append(rs, r)
+		if r := <-ch; r != nil {
+			rs = append(rs, r)
+		}
 	}
 	return rs
 }
@@ -1,7 +1,12 @@
 package webscraper
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
 	"strconv"
 	"testing"
@@ -25,7 +30,7 @@ func TestArtistParse(t *testing.T) {
 	for _, tt := range tests {
 		testname := fmt.Sprintf("testing %s parse", tt.name)
 		t.Run(testname, func(t *testing.T) {
-			got := parseArtist(tt.str)
+			got := defaultSelectors().Retailers[retailerAmazon].Fields[fieldArtist].clean(tt.str)
 			want := tt.name
 			if got != want {
 				t.Errorf("artist parse failed: want %v, got %v", want, got)
@@ -55,20 +60,146 @@ func TestPriceParse(t *testing.T) {
 	}
 }
 
-func TestGetAmazonPageInfo(t *testing.T) {
-	u := "https://www.amazon.co.uk/AM-VINYL-Arctic-Monkeys/dp/B00DKY4NBA/ref=sr_1_4?crid=EIQTUGWC5AAR&keywords=vinyl&qid=1645263030&sprefix=vinyl%2Caps%2C83&sr=8-4"
+func TestDetailParse(t *testing.T) {
+	tests := []struct{ label, value, wantLabel, wantValue string }{
+		{"EAN\u200f : \u200e", "0634904078126", "ean", "0634904078126"},
+		{"Label \u200f : \u200e", " XL Recordings ", "label", "XL Recordings"},
+		{"Catalogue Number:", "XLLP781", "catalogue number", "XLLP781"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantLabel, func(t *testing.T) {
+			label, value := parseDetail(tt.label, tt.value)
+			if label != tt.wantLabel || value != tt.wantValue {
+				t.Errorf("detail parse failed: want %q: %q, got %q: %q", tt.wantLabel, tt.wantValue, label, value)
+			}
+		})
+	}
+}
+
+func TestMerchantInfoParse(t *testing.T) {
+	tests := []struct {
+		info, seller, dispatcher string
+		fulfilled                bool
+	}{
+		{"Dispatched from and sold by Amazon.", "Amazon", "Amazon", true},
+		{" Ships from and sold by\n Amazon.co.uk. ", "Amazon.co.uk", "Amazon.co.uk", true},
+		{"Sold by Vinyl Vault and Fulfilled by Amazon.", "Vinyl Vault", "Amazon", true},
+		{"Dispatched from and sold by Second Spin Records.", "Second Spin Records", "Second Spin Records", false},
+		{"Currently unavailable.", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.info, func(t *testing.T) {
+			seller, dispatcher := parseMerchantInfo(tt.info)
+			if seller != tt.seller || dispatcher != tt.dispatcher {
+				t.Errorf("merchant info parse failed: want %q, %q, got %q, %q", tt.seller, tt.dispatcher, seller, dispatcher)
+			}
+			if got := isFulfilledBy(retailerAmazon, dispatcher); got != tt.fulfilled {
+				t.Errorf("isFulfilledBy(%q) = %v, want %v", dispatcher, got, tt.fulfilled)
+			}
+		})
+	}
+}
+
+func TestOfferListingURL(t *testing.T) {
+	got, ok := offerListingURL("https://www.amazon.co.uk/AM-VINYL-Arctic-Monkeys/dp/B00DKY4NBA/ref=sr_1_4?qid=1645263030")
+	want := "https://www.amazon.co.uk/gp/aod/ajax?asin=B00DKY4NBA&pc=dp"
+	if !ok || got != want {
+		t.Errorf("offerListingURL() = %q, %v, want %q", got, ok, want)
+	}
+	if _, ok := offerListingURL("https://roughtrade.com/gb/product/1"); ok {
+		t.Errorf("offerListingURL() of a non amazon url, want false")
+	}
+}
+
+const offerListingFixture = `<html><body>
+<div id="aod-pinned-offer">
+	<span class="a-price"><span class="a-offscreen">£24.99</span></span>
+</div>
+<div id="aod-offer">
+	<div id="aod-offer-heading"><h5>New</h5></div>
+	<span class="a-price"><span class="a-offscreen">£19.50</span></span>
+	<span data-csa-c-delivery-price="£2.99">£2.99 delivery</span>
+	<div id="aod-offer-shipsFrom"><div class="a-col-right"><span>Vinyl Vault</span></div></div>
+	<div id="aod-offer-soldBy"><div class="a-col-right"><a href="/sp?seller=1">Vinyl Vault</a></div></div>
+</div>
+<div id="aod-offer">
+	<div id="aod-offer-heading"><h5>Used - Very Good</h5></div>
+	<span class="a-price"><span class="a-offscreen">£12.00</span></span>
+	<span data-csa-c-delivery-price="FREE">FREE delivery</span>
+	<div id="aod-offer-shipsFrom"><div class="a-col-right"><span>Amazon</span></div></div>
+	<div id="aod-offer-soldBy"><div class="a-col-right"><a href="/sp?seller=2">Second Spin</a></div></div>
+</div>
+<div id="aod-offer">
+	<div id="aod-offer-heading"><h5>New</h5></div>
+	<span class="a-price"><span class="a-offscreen">£21.00</span></span>
+	<div id="aod-offer-shipsFrom"><div class="a-col-right"><span>Amazon</span></div></div>
+	<div id="aod-offer-soldBy"><div class="a-col-right"><a href="/sp?seller=3">Record Corner</a></div></div>
+</div>
+</body></html>`
 
-	gotPageInfo := getAmazonPageInfo(u)
-	expectedPageInfo := records.NewRecord("Arctic Monkeys", "AM", u, 0.0)
-	fmt.Print(gotPageInfo)
+func TestGetOfferListing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, offerListingFixture)
+	}))
+	defer srv.Close()
+	SetShippingRules(records.ShippingRules{"amazon": {Flat: 4.49, FreeOver: 25}})
+	defer SetShippingRules(nil)
+
+	productURL := "https://www.amazon.co.uk/dp/B07BKSN5L8"
+	got, err := getOfferListing(context.Background(), srv.URL, productURL)
+	if err != nil {
+		t.Fatalf("getOfferListing() failed: %s", err)
+	}
+	want := records.Offers{
+		{Retailer: "amazon", Url: productURL, Price: 19.5, Shipping: 2.99, Available: true,
+			Seller: "Vinyl Vault", Condition: records.ConditionNew},
+		{Retailer: "amazon", Url: productURL, Price: 12, Available: true,
+			Seller: "Second Spin", Fulfilled: true, Condition: records.ConditionUsedVeryGood},
+		// delivery is not listed so is priced by the shipping rule.
+		{Retailer: "amazon", Url: productURL, Price: 21, Shipping: 4.49, Available: true,
+			Seller: "Record Corner", Fulfilled: true, Condition: records.ConditionNew},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getOfferListing()\nwant: %+v\ngot:  %+v", want, got)
+	}
+}
+
+// the fixture page is scraped with the selector config shipped with the app.
+func TestGetPageInfo(t *testing.T) {
+	cfg, err := LoadSelectors("../selectors.json")
+	if err != nil {
+		t.Fatalf("LoadSelectors() failed: %s", err)
+	}
+	SetSelectors(cfg)
+	defer SetSelectors(defaultSelectors())
+	page, err := os.ReadFile("../fixtures/amazon_product.html")
+	if err != nil {
+		t.Fatalf("reading fixture failed: %s", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(page)
+	}))
+	defer srv.Close()
+
+	gotPageInfo := getPageInfo(context.Background(), srv.URL)
+	if gotPageInfo == nil {
+		t.Fatalf("getPageInfo(%s) = nil, Expected: a record", srv.URL)
+	}
+	expectedPageInfo := records.NewRecord("Tom Misch", "What Kinda Music", srv.URL, 22.50)
 
 	if gotPageInfo.GetAlbum() != expectedPageInfo.GetAlbum() {
 		t.Errorf("output %s not equal to expected %s", gotPageInfo.GetAlbum(), expectedPageInfo.GetAlbum())
 	}
-
 	if gotPageInfo.GetArtist() != expectedPageInfo.GetArtist() {
 		t.Errorf("output %s not equal to expected %s", gotPageInfo.GetArtist(), expectedPageInfo.GetArtist())
 	}
+	if gotPageInfo.GetPrice() != expectedPageInfo.GetPrice() {
+		t.Errorf("output %v not equal to expected %v", gotPageInfo.GetPrice(), expectedPageInfo.GetPrice())
+	}
 }
 
 // TestGetRecords verifies that concurrent implementation matches single threaded version
@@ -77,14 +208,54 @@ func TestGetRecords(t *testing.T) {
 	urls := ReadURLs(wd + "/input.txt")
 
 	var sing, parr records.Records
-	parr = GetRecords(urls)
+	parr = GetRecords(context.Background(), urls)
 	for _, u := range urls {
 		sing = append(
 			sing,
-			getAmazonPageInfo(u),
+			getPageInfo(context.Background(), u),
 		)
 	}
 	if reflect.DeepEqual(sing, parr) {
 		t.Errorf("non-concurrent and concurrent outputs do not match.\nexpected: %v.\ngot:%v.", sing, parr)
 	}
 }
+
+func TestParseURLs(t *testing.T) {
+	data := "https://www.amazon.co.uk/What-Kinda-Music-VINYL-Misch/dp/B084P38346/ref=sr_1_6?qid=1641156727\n" +
+		"\n" +
+		"https://www.amazon.co.uk/dp/B084P38346?psc=1\n" +
+		"not a url\n" +
+		"https://www.amazon.co.uk/Not-Waving-But-Drowning-VINYL/dp/B07NN37WH3/ref=pd_sbs_3?pd_rd_w=can3T"
+
+	urls, _, invalid, duplicates := parseURLs(data)
+	expected := []string{"https://www.amazon.co.uk/dp/B084P38346", "https://www.amazon.co.uk/dp/B07NN37WH3"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("parseURLs() urls = %v, expected %v", urls, expected)
+	}
+	if len(invalid) != 1 || len(duplicates) != 1 {
+		t.Errorf("parseURLs() invalid = %v, duplicates = %v, expected one of each", invalid, duplicates)
+	}
+}
+
+func TestAddURL(t *testing.T) {
+	filename := t.TempDir() + "/input.txt"
+	os.WriteFile(filename, []byte("https://www.amazon.co.uk/dp/B084P38346"), 0o644)
+
+	id, canonical, err := AddURL(filename, "https://www.amazon.co.uk/Not-Waving/dp/B07NN37WH3/ref=pd_sbs_3?psc=1")
+	if err != nil {
+		t.Fatalf("AddURL() returned an error: %s", err)
+	}
+	if id.String() != "amazon:B07NN37WH3" || canonical != "https://www.amazon.co.uk/dp/B07NN37WH3" {
+		t.Errorf("AddURL() = %s, %s", id, canonical)
+	}
+
+	if _, _, err := AddURL(filename, "https://www.amazon.co.uk/What-Kinda-Music/dp/B084P38346?qid=1"); !errors.Is(err, ErrDuplicateURL) {
+		t.Errorf("AddURL() of a duplicate = %v, expected %v", err, ErrDuplicateURL)
+	}
+
+	data, _ := os.ReadFile(filename)
+	expected := "https://www.amazon.co.uk/dp/B084P38346\nhttps://www.amazon.co.uk/dp/B07NN37WH3\n"
+	if string(data) != expected {
+		t.Errorf("input file = %q, expected %q", data, expected)
+	}
+}
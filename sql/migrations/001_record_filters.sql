@@ -0,0 +1,11 @@
+-- 001_record_filters.sql
+-- Adds the columns filtered on by GET / to an existing database.
+
+ALTER TABLE records ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE records ADD COLUMN IF NOT EXISTS target_price NUMERIC(6,2);
+
+CREATE INDEX IF NOT EXISTS records_search_idx ON records
+    USING GIN (to_tsvector('simple', artist || ' ' || album));
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS retailer VARCHAR (50) NOT NULL DEFAULT 'amazon';
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS available BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,6 @@
+-- 002_record_sort_keys.sql
+-- Adds the collation keys records are sorted by. Keys are computed by the go
+-- records package and backfilled for existing rows when the server starts.
+
+ALTER TABLE records ADD COLUMN IF NOT EXISTS artist_sort VARCHAR (100);
+ALTER TABLE records ADD COLUMN IF NOT EXISTS album_sort VARCHAR (100);
@@ -0,0 +1,11 @@
+-- 003_record_identity.sql
+-- Adds the canonical identity of records along with the edition, format and
+-- featured artists split out of scraped titles. Keys are computed by the go
+-- records package: run `webscraper backfill` after this migration to fill
+-- them, merge existing duplicates and create the unique identity index.
+
+ALTER TABLE records ADD COLUMN IF NOT EXISTS artist_key VARCHAR (100);
+ALTER TABLE records ADD COLUMN IF NOT EXISTS album_key VARCHAR (100);
+ALTER TABLE records ADD COLUMN IF NOT EXISTS featured_artists TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE records ADD COLUMN IF NOT EXISTS edition VARCHAR (100);
+ALTER TABLE records ADD COLUMN IF NOT EXISTS format VARCHAR (50);
@@ -0,0 +1,36 @@
+-- 004_listings_and_matches.sql
+-- Adds the retailer listings grouped under each record along with their
+-- barcode and catalogue number, and the suggested matches between records
+-- which users confirm or reject. Listings of existing records are created
+-- the next time they are scraped.
+
+CREATE TABLE IF NOT EXISTS listings
+(
+    id SERIAL PRIMARY KEY,
+    record_id int NOT NULL REFERENCES records (id),
+    retailer VARCHAR (50) NOT NULL DEFAULT 'amazon',
+    artist VARCHAR (100) NOT NULL,
+    album VARCHAR (100) NOT NULL,
+    artist_key VARCHAR (100) NOT NULL,
+    album_key VARCHAR (100) NOT NULL,
+    barcode VARCHAR (13),
+    catalogue_number VARCHAR (50),
+    UNIQUE (retailer, artist_key, album_key)
+);
+
+CREATE INDEX IF NOT EXISTS listings_barcode_idx ON listings (barcode);
+
+CREATE TABLE IF NOT EXISTS record_matches
+(
+    id SERIAL PRIMARY KEY,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE CASCADE,
+    candidate_id int NOT NULL,
+    score NUMERIC(4,3) NOT NULL,
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR (10) NOT NULL DEFAULT 'suggested'
+        CHECK (status IN ('suggested', 'confirmed', 'rejected')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    decided_at TIMESTAMPTZ,
+    UNIQUE (record_id, candidate_id),
+    CHECK (record_id < candidate_id)
+);
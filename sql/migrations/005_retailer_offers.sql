@@ -0,0 +1,8 @@
+-- 005_retailer_offers.sql
+-- Records the shipping cost of each price and allows a record to be priced by
+-- every retailer it is listed at on the same day.
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS shipping NUMERIC(6,2) NOT NULL DEFAULT 0;
+
+ALTER TABLE prices DROP CONSTRAINT IF EXISTS prices_date_record_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS prices_date_record_id_retailer_key ON prices (date, record_id, retailer);
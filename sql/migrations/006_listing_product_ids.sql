@@ -0,0 +1,14 @@
+-- 006_listing_product_ids.sql
+-- Identifies listings by the retailer's product id (e.g. the amazon ASIN) and
+-- stores their canonical url. Listings created before product ids were
+-- scraped are identified by their artist and album until next scraped.
+
+ALTER TABLE listings ADD COLUMN IF NOT EXISTS product_id VARCHAR (100);
+ALTER TABLE listings ADD COLUMN IF NOT EXISTS url TEXT;
+
+UPDATE listings SET product_id = 'name:' || artist_key || '/' || album_key WHERE product_id IS NULL;
+ALTER TABLE listings ALTER COLUMN product_id SET NOT NULL;
+
+ALTER TABLE listings DROP CONSTRAINT IF EXISTS listings_retailer_artist_key_album_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS listings_retailer_product_id_key ON listings (retailer, product_id);
+CREATE INDEX IF NOT EXISTS listings_identity_idx ON listings (artist_key, album_key);
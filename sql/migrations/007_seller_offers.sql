@@ -0,0 +1,27 @@
+-- 007_seller_offers.sql
+-- Records every seller's offer of a record, the seller and condition of each
+-- tracked price and which type of offer each record tracks.
+
+ALTER TABLE records ADD COLUMN IF NOT EXISTS offer_type VARCHAR (10) NOT NULL DEFAULT 'new';
+ALTER TABLE records DROP CONSTRAINT IF EXISTS records_offer_type_check;
+ALTER TABLE records ADD CONSTRAINT records_offer_type_check
+    CHECK (offer_type IN ('any', 'new', 'retailer', 'used'));
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS seller VARCHAR (100);
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS fulfilled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS condition VARCHAR (20) NOT NULL DEFAULT 'new';
+
+CREATE TABLE IF NOT EXISTS seller_offers
+(
+    id SERIAL PRIMARY KEY,
+    date DATE NOT NULL DEFAULT CURRENT_DATE,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE CASCADE,
+    retailer VARCHAR (50) NOT NULL,
+    seller VARCHAR (100) NOT NULL DEFAULT '',
+    fulfilled BOOLEAN NOT NULL DEFAULT FALSE,
+    condition VARCHAR (20) NOT NULL DEFAULT 'new',
+    price NUMERIC(6,2) NOT NULL,
+    shipping NUMERIC(6,2) NOT NULL DEFAULT 0,
+    available BOOLEAN NOT NULL DEFAULT TRUE,
+    UNIQUE (date, record_id, retailer, seller, condition)
+);
@@ -0,0 +1,5 @@
+-- 008_landed_price.sql
+-- Stores the landed price, including shipping, of every price snapshot.
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS landed_price NUMERIC(7,2)
+    GENERATED ALWAYS AS (price + shipping) STORED;
@@ -0,0 +1,32 @@
+-- 009_pending_prices.sql
+-- Records the currency of every price, and holds scraped prices flagged as
+-- anomalous against a record's history until they are approved, corrected
+-- or discarded, recording who decided them and why.
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS currency VARCHAR (3);
+
+CREATE TABLE IF NOT EXISTS pending_prices
+(
+    id SERIAL PRIMARY KEY,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE RESTRICT,
+    date DATE NOT NULL DEFAULT CURRENT_DATE,
+    retailer VARCHAR (50) NOT NULL,
+    url TEXT,
+    price NUMERIC(10,2) NOT NULL,
+    shipping NUMERIC(6,2) NOT NULL DEFAULT 0,
+    currency VARCHAR (3),
+    available BOOLEAN NOT NULL DEFAULT TRUE,
+    seller VARCHAR (100),
+    fulfilled BOOLEAN NOT NULL DEFAULT FALSE,
+    condition VARCHAR (20) NOT NULL DEFAULT 'new',
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR (10) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'corrected', 'discarded')),
+    corrected_price NUMERIC(6,2),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    decided_at TIMESTAMPTZ,
+    decided_by VARCHAR (100),
+    note TEXT
+);
+
+CREATE INDEX IF NOT EXISTS pending_prices_status_idx ON pending_prices (status);
@@ -0,0 +1,30 @@
+-- 010_price_sources.sql
+-- Records whether each price was scraped, entered by hand or imported, and
+-- the audit trail of every price entered, edited or deleted by hand. Prices
+-- recorded before it were all scraped.
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS source VARCHAR (10) NOT NULL DEFAULT 'scraped';
+ALTER TABLE prices DROP CONSTRAINT IF EXISTS prices_source_check;
+ALTER TABLE prices ADD CONSTRAINT prices_source_check
+    CHECK (source IN ('scraped', 'manual', 'imported'));
+
+CREATE TABLE IF NOT EXISTS price_audit
+(
+    id SERIAL PRIMARY KEY,
+    price_id int NOT NULL,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE RESTRICT,
+    date DATE NOT NULL,
+    retailer VARCHAR (50) NOT NULL,
+    action VARCHAR (10) NOT NULL CHECK (action IN ('insert', 'update', 'delete')),
+    old_price NUMERIC(6,2),
+    new_price NUMERIC(6,2),
+    old_shipping NUMERIC(6,2),
+    new_shipping NUMERIC(6,2),
+    old_source VARCHAR (10),
+    new_source VARCHAR (10),
+    changed_by VARCHAR (100) NOT NULL,
+    note TEXT,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS price_audit_record_idx ON price_audit (record_id);
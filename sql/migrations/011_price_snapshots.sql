@@ -0,0 +1,42 @@
+-- 011_price_snapshots.sql
+-- Keys prices and seller offers on the time of the snapshot they were
+-- scraped in rather than their date, so a record may be priced many times a
+-- day, and derives the daily open, close, low and high of each retailer's
+-- price. Rows recorded before it become the snapshot at midnight of their
+-- date, which is unique as their date was.
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS captured_at TIMESTAMPTZ;
+UPDATE prices SET captured_at = date::timestamptz WHERE captured_at IS NULL;
+ALTER TABLE prices ALTER COLUMN captured_at SET NOT NULL;
+ALTER TABLE prices ALTER COLUMN captured_at SET DEFAULT now();
+ALTER TABLE prices DROP CONSTRAINT IF EXISTS prices_date_record_id_retailer_key;
+DROP INDEX IF EXISTS prices_date_record_id_retailer_key;
+CREATE UNIQUE INDEX IF NOT EXISTS prices_captured_at_record_id_retailer_key ON prices (captured_at, record_id, retailer);
+CREATE INDEX IF NOT EXISTS prices_record_date_idx ON prices (record_id, date);
+
+ALTER TABLE seller_offers ADD COLUMN IF NOT EXISTS captured_at TIMESTAMPTZ;
+UPDATE seller_offers SET captured_at = date::timestamptz WHERE captured_at IS NULL;
+ALTER TABLE seller_offers ALTER COLUMN captured_at SET NOT NULL;
+ALTER TABLE seller_offers ALTER COLUMN captured_at SET DEFAULT now();
+ALTER TABLE seller_offers DROP CONSTRAINT IF EXISTS seller_offers_date_record_id_retailer_seller_condition_key;
+CREATE UNIQUE INDEX IF NOT EXISTS seller_offers_captured_at_record_id_retailer_seller_condition_key
+    ON seller_offers (captured_at, record_id, retailer, seller, condition);
+
+ALTER TABLE pending_prices ADD COLUMN IF NOT EXISTS captured_at TIMESTAMPTZ;
+UPDATE pending_prices SET captured_at = date::timestamptz WHERE captured_at IS NULL;
+ALTER TABLE pending_prices ALTER COLUMN captured_at SET NOT NULL;
+ALTER TABLE pending_prices ALTER COLUMN captured_at SET DEFAULT now();
+
+ALTER TABLE price_audit ADD COLUMN IF NOT EXISTS captured_at TIMESTAMPTZ;
+UPDATE price_audit SET captured_at = date::timestamptz WHERE captured_at IS NULL;
+ALTER TABLE price_audit ALTER COLUMN captured_at SET NOT NULL;
+
+CREATE OR REPLACE VIEW daily_prices AS
+SELECT record_id, retailer, date,
+    (array_agg(price ORDER BY captured_at))[1] AS open,
+    (array_agg(price ORDER BY captured_at DESC))[1] AS close,
+    MIN(price) AS low,
+    MAX(price) AS high,
+    COUNT(*) AS snapshots
+FROM prices
+GROUP BY record_id, retailer, date;
@@ -0,0 +1,27 @@
+-- 012_scrape_runs.sql
+-- Records each refresh of every record's prices as a scrape run, whose
+-- snapshots are written in a single transaction, and links the prices,
+-- seller offers and pending prices it writes to it. Rows recorded before it,
+-- and prices entered by hand, belong to no run.
+
+CREATE TABLE IF NOT EXISTS scrape_runs
+(
+    id SERIAL PRIMARY KEY,
+    captured_at TIMESTAMPTZ NOT NULL,
+    status VARCHAR (10) NOT NULL DEFAULT 'running'
+        CHECK (status IN ('running', 'completed', 'failed')),
+    records int NOT NULL DEFAULT 0,
+    failed int NOT NULL DEFAULT 0,
+    prices int NOT NULL DEFAULT 0,
+    held int NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+ALTER TABLE prices ADD COLUMN IF NOT EXISTS run_id int REFERENCES scrape_runs (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS prices_run_idx ON prices (run_id);
+
+ALTER TABLE seller_offers ADD COLUMN IF NOT EXISTS run_id int REFERENCES scrape_runs (id) ON DELETE SET NULL;
+
+ALTER TABLE pending_prices ADD COLUMN IF NOT EXISTS run_id int REFERENCES scrape_runs (id) ON DELETE SET NULL;
@@ -5,14 +5,171 @@ CREATE TABLE IF NOT EXISTS records
     id SERIAL PRIMARY KEY,
     artist VARCHAR (100) NOT NULL,
     album VARCHAR (100) NOT NULL,
+    artist_sort VARCHAR (100),
+    album_sort VARCHAR (100),
+    artist_key VARCHAR (100),
+    album_key VARCHAR (100),
+    featured_artists TEXT[] NOT NULL DEFAULT '{}',
+    edition VARCHAR (100),
+    format VARCHAR (50),
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    target_price NUMERIC(6,2),
+    offer_type VARCHAR (10) NOT NULL DEFAULT 'new'
+        CHECK (offer_type IN ('any', 'new', 'retailer', 'used')),
     UNIQUE (artist, album)
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS records_identity_idx ON records (artist_key, album_key);
+
+CREATE INDEX IF NOT EXISTS records_search_idx ON records
+    USING GIN (to_tsvector('simple', artist || ' ' || album));
+
+CREATE TABLE IF NOT EXISTS scrape_runs
+(
+    id SERIAL PRIMARY KEY,
+    captured_at TIMESTAMPTZ NOT NULL,
+    status VARCHAR (10) NOT NULL DEFAULT 'running'
+        CHECK (status IN ('running', 'completed', 'failed')),
+    records int NOT NULL DEFAULT 0,
+    failed int NOT NULL DEFAULT 0,
+    prices int NOT NULL DEFAULT 0,
+    held int NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
 CREATE TABLE IF NOT EXISTS prices
 (
     id SERIAL PRIMARY KEY,
     date DATE NOT NULL DEFAULT CURRENT_DATE,
     price NUMERIC(6,2) NOT NULL,
     record_id int NOT NULL REFERENCES records (id),
-    UNIQUE (date, record_id)
+    retailer VARCHAR (50) NOT NULL DEFAULT 'amazon',
+    available BOOLEAN NOT NULL DEFAULT TRUE,
+    shipping NUMERIC(6,2) NOT NULL DEFAULT 0,
+    landed_price NUMERIC(7,2) GENERATED ALWAYS AS (price + shipping) STORED,
+    seller VARCHAR (100),
+    fulfilled BOOLEAN NOT NULL DEFAULT FALSE,
+    condition VARCHAR (20) NOT NULL DEFAULT 'new',
+    currency VARCHAR (3),
+    source VARCHAR (10) NOT NULL DEFAULT 'scraped'
+        CHECK (source IN ('scraped', 'manual', 'imported')),
+    captured_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    run_id int REFERENCES scrape_runs (id) ON DELETE SET NULL,
+    UNIQUE (captured_at, record_id, retailer)
 );
+
+CREATE INDEX IF NOT EXISTS prices_record_date_idx ON prices (record_id, date);
+CREATE INDEX IF NOT EXISTS prices_run_idx ON prices (run_id);
+
+CREATE OR REPLACE VIEW daily_prices AS
+SELECT record_id, retailer, date,
+    (array_agg(price ORDER BY captured_at))[1] AS open,
+    (array_agg(price ORDER BY captured_at DESC))[1] AS close,
+    MIN(price) AS low,
+    MAX(price) AS high,
+    COUNT(*) AS snapshots
+FROM prices
+GROUP BY record_id, retailer, date;
+
+CREATE TABLE IF NOT EXISTS seller_offers
+(
+    id SERIAL PRIMARY KEY,
+    date DATE NOT NULL DEFAULT CURRENT_DATE,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE CASCADE,
+    retailer VARCHAR (50) NOT NULL,
+    seller VARCHAR (100) NOT NULL DEFAULT '',
+    fulfilled BOOLEAN NOT NULL DEFAULT FALSE,
+    condition VARCHAR (20) NOT NULL DEFAULT 'new',
+    price NUMERIC(6,2) NOT NULL,
+    shipping NUMERIC(6,2) NOT NULL DEFAULT 0,
+    available BOOLEAN NOT NULL DEFAULT TRUE,
+    captured_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    run_id int REFERENCES scrape_runs (id) ON DELETE SET NULL,
+    UNIQUE (captured_at, record_id, retailer, seller, condition)
+);
+
+CREATE TABLE IF NOT EXISTS listings
+(
+    id SERIAL PRIMARY KEY,
+    record_id int NOT NULL REFERENCES records (id),
+    retailer VARCHAR (50) NOT NULL DEFAULT 'amazon',
+    product_id VARCHAR (100) NOT NULL,
+    url TEXT,
+    artist VARCHAR (100) NOT NULL,
+    album VARCHAR (100) NOT NULL,
+    artist_key VARCHAR (100) NOT NULL,
+    album_key VARCHAR (100) NOT NULL,
+    barcode VARCHAR (13),
+    catalogue_number VARCHAR (50),
+    UNIQUE (retailer, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS listings_identity_idx ON listings (artist_key, album_key);
+CREATE INDEX IF NOT EXISTS listings_barcode_idx ON listings (barcode);
+
+CREATE TABLE IF NOT EXISTS record_matches
+(
+    id SERIAL PRIMARY KEY,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE CASCADE,
+    candidate_id int NOT NULL,
+    score NUMERIC(4,3) NOT NULL,
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR (10) NOT NULL DEFAULT 'suggested'
+        CHECK (status IN ('suggested', 'confirmed', 'rejected')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    decided_at TIMESTAMPTZ,
+    UNIQUE (record_id, candidate_id),
+    CHECK (record_id < candidate_id)
+);
+
+CREATE TABLE IF NOT EXISTS pending_prices
+(
+    id SERIAL PRIMARY KEY,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE RESTRICT,
+    date DATE NOT NULL DEFAULT CURRENT_DATE,
+    retailer VARCHAR (50) NOT NULL,
+    url TEXT,
+    price NUMERIC(10,2) NOT NULL,
+    shipping NUMERIC(6,2) NOT NULL DEFAULT 0,
+    currency VARCHAR (3),
+    available BOOLEAN NOT NULL DEFAULT TRUE,
+    seller VARCHAR (100),
+    fulfilled BOOLEAN NOT NULL DEFAULT FALSE,
+    condition VARCHAR (20) NOT NULL DEFAULT 'new',
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR (10) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'corrected', 'discarded')),
+    corrected_price NUMERIC(6,2),
+    captured_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    run_id int REFERENCES scrape_runs (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    decided_at TIMESTAMPTZ,
+    decided_by VARCHAR (100),
+    note TEXT
+);
+
+CREATE INDEX IF NOT EXISTS pending_prices_status_idx ON pending_prices (status);
+
+CREATE TABLE IF NOT EXISTS price_audit
+(
+    id SERIAL PRIMARY KEY,
+    price_id int NOT NULL,
+    record_id int NOT NULL REFERENCES records (id) ON DELETE RESTRICT,
+    date DATE NOT NULL,
+    captured_at TIMESTAMPTZ NOT NULL,
+    retailer VARCHAR (50) NOT NULL,
+    action VARCHAR (10) NOT NULL CHECK (action IN ('insert', 'update', 'delete')),
+    old_price NUMERIC(6,2),
+    new_price NUMERIC(6,2),
+    old_shipping NUMERIC(6,2),
+    new_shipping NUMERIC(6,2),
+    old_source VARCHAR (10),
+    new_source VARCHAR (10),
+    changed_by VARCHAR (100) NOT NULL,
+    note TEXT,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS price_audit_record_idx ON price_audit (record_id);